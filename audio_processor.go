@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// AudioEncoding describes the format of an audio file written by an AudioProcessor
+type AudioEncoding struct {
+	Codec      string `json:"codec"`             // Encoder, such as libvorbis or pcm_s16le
+	Bitrate    string `json:"bitrate,omitempty"` // Such as 128k, the encoder default when empty
+	Quality    string `json:"quality,omitempty"` // Variable bitrate quality, used when Bitrate is empty
+	SampleRate int    `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+}
+
+// Encodings used for intermediate and exported audio
+var (
+	// ToneLibAudioEncoding is the encoding of the backing track in ToneLib .song files
+	ToneLibAudioEncoding = AudioEncoding{Codec: "libvorbis", Bitrate: "128k", SampleRate: 44100, Channels: 2}
+	// PCMAudioEncoding is lossless, used for intermediate files between processing steps
+	PCMAudioEncoding = AudioEncoding{Codec: "pcm_s16le", SampleRate: 44100, Channels: 2}
+	// MixAudioEncoding is lossless floating point, used for mixes that can peak
+	// above full scale until their level is set
+	MixAudioEncoding = AudioEncoding{Codec: "pcm_f32le", SampleRate: 44100, Channels: 2}
+	// AnalysisAudioEncoding is mono PCM read back by DecodeAudio
+	AnalysisAudioEncoding = AudioEncoding{Codec: "pcm_s16le", SampleRate: 44100, Channels: 1}
+)
+
+// AudioProcessor performs the audio operations needed for exports. Every
+// operation reads whole files and writes the result to output in the given
+// encoding.
+type AudioProcessor interface {
+	// Validate checks that the backend is available
+	Validate() error
+	// Merge mixes the inputs into a single file, keeping their levels
+	Merge(inputs []string, output string, encoding AudioEncoding) error
+	// Transcode converts the input to the encoding
+	Transcode(input, output string, encoding AudioEncoding) error
+	// Trim keeps duration seconds of the input starting at start. A zero
+	// duration keeps everything after start.
+	Trim(input, output string, start, duration float64, encoding AudioEncoding) error
+	// Gain changes the volume of the input by the given decibels
+	Gain(input, output string, db float64, encoding AudioEncoding) error
+	// Fade fades the input in over fadeIn seconds and out over the last fadeOut
+	// seconds of its duration
+	Fade(input, output string, duration, fadeIn, fadeOut float64, encoding AudioEncoding) error
+	// Loudness measures the input following EBU R128
+	Loudness(input string) (*LoudnessStats, error)
+}
+
+// LoudnessStats is an EBU R128 loudness measurement
+type LoudnessStats struct {
+	Integrated float64 `json:"integrated"` // Integrated loudness in LUFS
+	Range      float64 `json:"range"`      // Loudness range in LU
+	TruePeak   float64 `json:"true_peak"`  // True peak in dBTP
+}
+
+// DefaultAudioProcessor is used by SNG files without their own processor
+var DefaultAudioProcessor AudioProcessor = NewFFmpegProcessor("")
+
+// FFmpegProcessor is an AudioProcessor running the ffmpeg command line tool
+type FFmpegProcessor struct {
+	Binary string // Path to the ffmpeg binary, looked up in PATH when it has no directory
+}
+
+// NewFFmpegProcessor creates an ffmpeg backend. An empty binary uses ffmpeg from PATH.
+func NewFFmpegProcessor(binary string) *FFmpegProcessor {
+	if binary == "" {
+		binary = "ffmpeg"
+	}
+	return &FFmpegProcessor{Binary: binary}
+}
+
+// Validate checks if ffmpeg is available and runs
+func (f *FFmpegProcessor) Validate() error {
+	if _, err := exec.LookPath(f.Binary); err != nil {
+		return fmt.Errorf("%s is not available: %w", f.Binary, err)
+	}
+
+	// Test ffmpeg with a simple command to verify it's working
+	if err := f.run([]string{"-version"}); err != nil {
+		return fmt.Errorf("ffmpeg validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// Merge mixes the inputs with amix. Mono inputs are spread to both channels by
+// the output channel count.
+func (f *FFmpegProcessor) Merge(inputs []string, output string, encoding AudioEncoding) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no audio files to merge")
+	}
+	if len(inputs) == 1 {
+		return f.Transcode(inputs[0], output, encoding)
+	}
+
+	var args []string
+	var filterInputs []string
+	for i, input := range inputs {
+		args = append(args, "-i", input)
+		filterInputs = append(filterInputs, fmt.Sprintf("[%d:a]", i))
+	}
+
+	// Use amix filter which handles different channel layouts better than amerge.
+	// normalize=0 keeps the stems at their original levels so a partial mix
+	// matches the full song.
+	filterComplex := fmt.Sprintf("%samix=inputs=%d:duration=longest:normalize=0[aout]", strings.Join(filterInputs, ""), len(inputs))
+	args = append(args, "-filter_complex", filterComplex, "-map", "[aout]")
+
+	log.Printf("Running ffmpeg to merge %d audio files", len(inputs))
+	return f.run(append(args, f.outputArgs(output, encoding)...))
+}
+
+// Transcode converts the input to the encoding
+func (f *FFmpegProcessor) Transcode(input, output string, encoding AudioEncoding) error {
+	args := []string{"-i", input, "-map", "0:a"}
+	return f.run(append(args, f.outputArgs(output, encoding)...))
+}
+
+// Trim cuts the input, seeking after decoding so the cut is sample accurate
+func (f *FFmpegProcessor) Trim(input, output string, start, duration float64, encoding AudioEncoding) error {
+	args := []string{"-i", input, "-map", "0:a", "-ss", fmt.Sprintf("%.3f", start)}
+	if duration > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.3f", duration))
+	}
+	return f.run(append(args, f.outputArgs(output, encoding)...))
+}
+
+// Gain changes the volume of the input with the volume filter
+func (f *FFmpegProcessor) Gain(input, output string, db float64, encoding AudioEncoding) error {
+	args := []string{"-i", input, "-map", "0:a", "-filter:a", fmt.Sprintf("volume=%.2fdB", db)}
+	return f.run(append(args, f.outputArgs(output, encoding)...))
+}
+
+// Fade applies the fades with the afade filter
+func (f *FFmpegProcessor) Fade(input, output string, duration, fadeIn, fadeOut float64, encoding AudioEncoding) error {
+	var fades []string
+	if fadeIn > 0 {
+		fades = append(fades, fmt.Sprintf("afade=t=in:st=0:d=%.3f", fadeIn))
+	}
+	if fadeOut > 0 {
+		fades = append(fades, fmt.Sprintf("afade=t=out:st=%.3f:d=%.3f", duration-fadeOut, fadeOut))
+	}
+	if len(fades) == 0 {
+		return f.Transcode(input, output, encoding)
+	}
+
+	args := []string{"-i", input, "-map", "0:a", "-filter:a", strings.Join(fades, ",")}
+	return f.run(append(args, f.outputArgs(output, encoding)...))
+}
+
+// Loudness runs the ebur128 filter over the input and reads its summary
+func (f *FFmpegProcessor) Loudness(input string) (*LoudnessStats, error) {
+	args := []string{"-hide_banner", "-nostats", "-i", input, "-map", "0:a", "-af", "ebur128=peak=true", "-f", "null", "-"}
+	stderr, err := f.runOutput(args)
+	if err != nil {
+		return nil, err
+	}
+	return parseEbur128Summary(stderr)
+}
+
+// parseEbur128Summary reads the loudness values from the summary the ffmpeg
+// ebur128 filter prints when it finishes
+func parseEbur128Summary(output string) (*LoudnessStats, error) {
+	summary := strings.LastIndex(output, "Summary:")
+	if summary < 0 {
+		return nil, fmt.Errorf("no ebur128 summary in ffmpeg output")
+	}
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(output[summary:], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "I:", "LRA:", "Peak:":
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ebur128 value %q: %w", line, err)
+			}
+			if _, exists := values[fields[0]]; !exists {
+				values[fields[0]] = value
+			}
+		}
+	}
+
+	integrated, ok := values["I:"]
+	if !ok {
+		return nil, fmt.Errorf("no integrated loudness in ebur128 summary")
+	}
+
+	return &LoudnessStats{
+		Integrated: integrated,
+		Range:      values["LRA:"],
+		TruePeak:   values["Peak:"],
+	}, nil
+}
+
+// outputArgs returns the encoder arguments for the output file
+func (f *FFmpegProcessor) outputArgs(output string, encoding AudioEncoding) []string {
+	var args []string
+	if encoding.Channels > 0 {
+		args = append(args, "-ac", fmt.Sprintf("%d", encoding.Channels))
+	}
+	if encoding.SampleRate > 0 {
+		args = append(args, "-ar", fmt.Sprintf("%d", encoding.SampleRate))
+	}
+	if encoding.Codec != "" {
+		args = append(args, "-c:a", encoding.Codec)
+	}
+	if encoding.Bitrate != "" {
+		args = append(args, "-b:a", encoding.Bitrate)
+	} else if encoding.Quality != "" {
+		args = append(args, "-q:a", encoding.Quality)
+	}
+
+	return append(args,
+		"-y", // Overwrite output file
+		output,
+	)
+}
+
+// run executes ffmpeg, including its error output in the returned error
+func (f *FFmpegProcessor) run(args []string) error {
+	_, err := f.runOutput(args)
+	return err
+}
+
+// runOutput executes ffmpeg and returns what it printed to stderr, where it
+// writes its logs and filter output
+func (f *FFmpegProcessor) runOutput(args []string) (string, error) {
+	cmd := exec.Command(f.Binary, args...)
+
+	// Log the exact command for debugging
+	log.Printf("FFmpeg command: %s %s", f.Binary, strings.Join(args, " "))
+
+	// Capture stdout and stderr for better error reporting
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		stderrStr := strings.TrimSpace(stderr.String())
+		if stderrStr != "" {
+			return "", fmt.Errorf("ffmpeg failed: %w\nFFmpeg stderr: %s", err, stderrStr)
+		}
+		return "", fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	return stderr.String(), nil
+}
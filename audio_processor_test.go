@@ -0,0 +1,350 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingAudioProcessor is an AudioProcessor that records the operations
+// instead of running them. Outputs are written with a short description of the
+// operation so later steps have a file to read.
+type recordingAudioProcessor struct {
+	calls    []string
+	loudness LoudnessStats // Returned for every Loudness measurement
+}
+
+func (r *recordingAudioProcessor) record(output string, call string) error {
+	r.calls = append(r.calls, call)
+	return os.WriteFile(output, []byte(call), 0644)
+}
+
+func (r *recordingAudioProcessor) Validate() error {
+	return nil
+}
+
+func (r *recordingAudioProcessor) Merge(inputs []string, output string, encoding AudioEncoding) error {
+	return r.record(output, fmt.Sprintf("merge %d %s", len(inputs), encoding.Codec))
+}
+
+func (r *recordingAudioProcessor) Transcode(input, output string, encoding AudioEncoding) error {
+	return r.record(output, fmt.Sprintf("transcode %s", encoding.Codec))
+}
+
+func (r *recordingAudioProcessor) Trim(input, output string, start, duration float64, encoding AudioEncoding) error {
+	return r.record(output, fmt.Sprintf("trim %.3f %.3f %s", start, duration, encoding.Codec))
+}
+
+func (r *recordingAudioProcessor) Gain(input, output string, db float64, encoding AudioEncoding) error {
+	return r.record(output, fmt.Sprintf("gain %.2f %s", db, encoding.Codec))
+}
+
+func (r *recordingAudioProcessor) Fade(input, output string, duration, fadeIn, fadeOut float64, encoding AudioEncoding) error {
+	return r.record(output, fmt.Sprintf("fade %.3f %.3f %.3f %s", duration, fadeIn, fadeOut, encoding.Codec))
+}
+
+func (r *recordingAudioProcessor) Loudness(input string) (*LoudnessStats, error) {
+	r.calls = append(r.calls, "loudness")
+	stats := r.loudness
+	return &stats, nil
+}
+
+// sngTestFile is a file to store in a test SNG package
+type sngTestFile struct {
+	name string
+	data []byte
+}
+
+// writeTestSngFile writes an SNG package with the given metadata and files to a
+// temporary directory and opens it
+func writeTestSngFile(t *testing.T, metadata map[string]string, files []sngTestFile) *SngFile {
+	t.Helper()
+
+	var buf bytes.Buffer
+	xorMask := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	buf.WriteString(SngFileIdentifier)
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	buf.Write(xorMask[:])
+
+	var meta bytes.Buffer
+	for key, value := range metadata {
+		binary.Write(&meta, binary.LittleEndian, int32(len(key)))
+		meta.WriteString(key)
+		binary.Write(&meta, binary.LittleEndian, int32(len(value)))
+		meta.WriteString(value)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint64(meta.Len()+8))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(metadata)))
+	buf.Write(meta.Bytes())
+
+	indexSize := 16
+	for _, file := range files {
+		indexSize += 1 + len(file.name) + 16
+	}
+	offset := uint64(buf.Len() + indexSize + 8)
+
+	binary.Write(&buf, binary.LittleEndian, uint64(indexSize-8))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(files)))
+	for _, file := range files {
+		buf.WriteByte(byte(len(file.name)))
+		buf.WriteString(file.name)
+		binary.Write(&buf, binary.LittleEndian, uint64(len(file.data)))
+		binary.Write(&buf, binary.LittleEndian, offset)
+		offset += uint64(len(file.data))
+	}
+
+	var dataSize uint64
+	for _, file := range files {
+		dataSize += uint64(len(file.data))
+	}
+	binary.Write(&buf, binary.LittleEndian, dataSize)
+	for _, file := range files {
+		for i, b := range file.data {
+			buf.WriteByte(b ^ (byte(i) ^ xorMask[i&0x0F]))
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "test.sng")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write SNG file: %v", err)
+	}
+
+	sngFile, err := OpenSngFile(path)
+	if err != nil {
+		t.Fatalf("failed to open SNG file: %v", err)
+	}
+	t.Cleanup(func() { sngFile.Close() })
+	return sngFile
+}
+
+// createTestSngFile creates an SNG package with the tempo map MIDI file and a
+// few audio stems
+func createTestSngFile(t *testing.T) *SngFile {
+	t.Helper()
+
+	var midiData bytes.Buffer
+	if _, err := createTempoMapMidiFile().WriteTo(&midiData); err != nil {
+		t.Fatalf("failed to write MIDI file: %v", err)
+	}
+
+	return writeTestSngFile(t, map[string]string{"name": "Test Song"}, []sngTestFile{
+		{"notes.mid", midiData.Bytes()},
+		{"song.opus", []byte("song audio")},
+		{"drums_1.opus", []byte("drums audio")},
+		{"preview.opus", []byte("preview audio")},
+	})
+}
+
+func TestSngFileRoundTrip(t *testing.T) {
+	sngFile := createTestSngFile(t)
+
+	if sngFile.GetMetadata()["name"] != "Test Song" {
+		t.Errorf("expected song name metadata, got %v", sngFile.GetMetadata())
+	}
+
+	data, err := sngFile.ReadFile("drums_1.opus")
+	if err != nil || string(data) != "drums audio" {
+		t.Errorf("expected unmasked drums audio, got %q (%v)", data, err)
+	}
+
+	stems := sngFile.AudioStems()
+	if len(stems) != 2 || stems[0].Name != "song" || stems[1].Group() != "drums" {
+		t.Errorf("expected song and drums stems, got %+v", stems)
+	}
+}
+
+func TestToneLibSongWithAudioProcessor(t *testing.T) {
+	sngFile := createTestSngFile(t)
+	recorder := &recordingAudioProcessor{loudness: LoudnessStats{Integrated: -16, TruePeak: -4}}
+	sngFile.SetAudioProcessor(recorder)
+
+	var buf bytes.Buffer
+	if err := WriteToneLibSongTo(&buf, sngFile); err != nil {
+		t.Fatalf("WriteToneLibSongTo failed: %v", err)
+	}
+
+	// The merged stems keep their level while the peak is below the ceiling
+	expected := []string{"merge 2 pcm_f32le", "loudness", "transcode libvorbis"}
+	if strings.Join(recorder.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("expected %v, got %v", expected, recorder.calls)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to read song archive: %v", err)
+	}
+
+	var audio, songData string
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+
+		switch file.Name {
+		case ToneLibAudioDataFile:
+			audio = string(data)
+		case "the_song.dat":
+			songData = string(data)
+		}
+	}
+
+	if audio != "transcode libvorbis" {
+		t.Errorf("expected the merged audio in the archive, got %q", audio)
+	}
+	if !strings.Contains(songData, ToneLibAudioDataFile) {
+		t.Errorf("expected the backing track to reference the audio file")
+	}
+}
+
+func TestExportAudioSlice(t *testing.T) {
+	sngFile := createTestSngFile(t)
+	recorder := &recordingAudioProcessor{}
+	sngFile.SetAudioProcessor(recorder)
+
+	timeline, err := sngFile.GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+	slice, err := ResolveSongSlice(timeline, "2", "3")
+	if err != nil {
+		t.Fatalf("ResolveSongSlice failed: %v", err)
+	}
+	sngFile.SetSlice(slice)
+
+	stems, err := SelectAudioStems(sngFile.AudioStems(), "-drums")
+	if err != nil {
+		t.Fatalf("SelectAudioStems failed: %v", err)
+	}
+
+	output := filepath.Join(t.TempDir(), "out.flac")
+	if err := sngFile.ExportAudio(stems, AudioFormatEncodings[AudioFormatFlac], output); err != nil {
+		t.Fatalf("ExportAudio failed: %v", err)
+	}
+
+	expected := []string{"merge 1 pcm_f32le", "trim 2.000 4.000 flac"}
+	if strings.Join(recorder.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("expected %v, got %v", expected, recorder.calls)
+	}
+}
+
+func TestExportAudioLoudness(t *testing.T) {
+	sngFile := createTestSngFile(t)
+	recorder := &recordingAudioProcessor{loudness: LoudnessStats{Integrated: -20, TruePeak: -8}}
+	sngFile.SetAudioProcessor(recorder)
+
+	gains, err := ParseStemGains("drums=-3, song=0")
+	if err != nil {
+		t.Fatalf("ParseStemGains failed: %v", err)
+	}
+	sngFile.SetMixOptions(AudioMixOptions{LoudnessTarget: StreamingLoudnessTarget, StemGains: gains})
+
+	output := filepath.Join(t.TempDir(), "out.ogg")
+	if err := sngFile.ExportAudio(sngFile.AudioStems(), AudioFormatEncodings[AudioFormatOgg], output); err != nil {
+		t.Fatalf("ExportAudio failed: %v", err)
+	}
+
+	// Only the drums group changes level, then the mix is raised from -20 to -14 LUFS
+	expected := []string{"gain -3.00 pcm_f32le", "merge 2 pcm_f32le", "loudness", "gain 6.00 libvorbis"}
+	if strings.Join(recorder.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("expected %v, got %v", expected, recorder.calls)
+	}
+
+	// The gain is limited when the peak would go over the ceiling
+	recorder.calls = nil
+	recorder.loudness = LoudnessStats{Integrated: -20, TruePeak: -3}
+	sngFile.SetMixOptions(AudioMixOptions{LoudnessTarget: StreamingLoudnessTarget})
+	if err := sngFile.ExportAudio(sngFile.AudioStems(), AudioFormatEncodings[AudioFormatOgg], output); err != nil {
+		t.Fatalf("ExportAudio failed: %v", err)
+	}
+
+	expected = []string{"merge 2 pcm_f32le", "loudness", "gain 2.00 libvorbis"}
+	if strings.Join(recorder.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("expected %v, got %v", expected, recorder.calls)
+	}
+
+	// Without a target, stems summed above the ceiling are turned down to it
+	recorder.calls = nil
+	recorder.loudness = LoudnessStats{Integrated: -9, TruePeak: 2}
+	sngFile.SetMixOptions(AudioMixOptions{})
+	if err := sngFile.ExportAudio(sngFile.AudioStems(), AudioFormatEncodings[AudioFormatOgg], output); err != nil {
+		t.Fatalf("ExportAudio failed: %v", err)
+	}
+
+	expected = []string{"merge 2 pcm_f32le", "loudness", "gain -3.00 libvorbis"}
+	if strings.Join(recorder.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("expected %v, got %v", expected, recorder.calls)
+	}
+}
+
+func TestParseStemGains(t *testing.T) {
+	gains, err := ParseStemGains("Drums=-3dB,vocals=+2.5")
+	if err != nil {
+		t.Fatalf("ParseStemGains failed: %v", err)
+	}
+	if len(gains) != 2 || gains["drums"] != -3 || gains["vocals"] != 2.5 {
+		t.Errorf("unexpected gains: %v", gains)
+	}
+
+	for _, value := range []string{"drums", "drums=loud"} {
+		if _, err := ParseStemGains(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseEbur128Summary(t *testing.T) {
+	output := `Input #0, ogg, from 'song.ogg':
+[Parsed_ebur128_0 @ 0x600000] t: 0.1 TARGET:-23 LUFS M: -120.7 S: -120.7 I: -70.0 LUFS LRA: 0.0 LU
+[Parsed_ebur128_0 @ 0x600000] Summary:
+
+  Integrated loudness:
+    I:         -19.5 LUFS
+    Threshold: -29.8 LUFS
+
+  Loudness range:
+    LRA:         6.1 LU
+    Threshold: -39.8 LUFS
+    LRA low:   -24.5 LUFS
+    LRA high:  -18.4 LUFS
+
+  True peak:
+    Peak:       -0.3 dBFS
+`
+	stats, err := parseEbur128Summary(output)
+	if err != nil {
+		t.Fatalf("parseEbur128Summary failed: %v", err)
+	}
+	if stats.Integrated != -19.5 || stats.Range != 6.1 || stats.TruePeak != -0.3 {
+		t.Errorf("unexpected loudness: %+v", stats)
+	}
+
+	if _, err := parseEbur128Summary("ffmpeg version 6.0"); err == nil {
+		t.Errorf("expected an error without a summary")
+	}
+}
+
+func TestFFmpegOutputArgs(t *testing.T) {
+	processor := NewFFmpegProcessor("")
+	if processor.Binary != "ffmpeg" {
+		t.Errorf("expected ffmpeg from PATH, got %q", processor.Binary)
+	}
+
+	args := strings.Join(processor.outputArgs("out.ogg", ToneLibAudioEncoding), " ")
+	if args != "-ac 2 -ar 44100 -c:a libvorbis -b:a 128k -y out.ogg" {
+		t.Errorf("unexpected ToneLib encoding arguments: %s", args)
+	}
+
+	args = strings.Join(processor.outputArgs("out.mp3", AudioFormatEncodings[AudioFormatMp3]), " ")
+	if args != "-ac 2 -ar 44100 -c:a libmp3lame -q:a 2 -y out.mp3" {
+		t.Errorf("unexpected mp3 encoding arguments: %s", args)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchSongExtensions lists the song files picked up by FindBatchSongs
+var batchSongExtensions = []string{".sng", ".chart", ".mid"}
+
+// BatchSong is a song found under a batch directory
+type BatchSong struct {
+	Input string `json:"input"` // Song file or song folder to process
+	Name  string `json:"name"`  // Path from the batch root without extension, the base of the mirrored output
+}
+
+// BatchJob is a song to process and where its output goes
+type BatchJob struct {
+	Song   BatchSong
+	Output string // Output path for the song, empty when the mode writes none
+}
+
+// BatchResult is the outcome of a single job
+type BatchResult struct {
+	Input   string  `json:"input"`
+	Output  string  `json:"output,omitempty"`
+	Success bool    `json:"success"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// BatchReport summarizes a batch run, with the results in job order
+type BatchReport struct {
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Seconds   float64       `json:"seconds"`
+	Results   []BatchResult `json:"results"`
+}
+
+// String returns the totals followed by every failure
+func (r *BatchReport) String() string {
+	result := fmt.Sprintf("Processed %d songs in %.1fs: %d succeeded, %d failed\n", r.Total, r.Seconds, r.Succeeded, r.Failed)
+	if r.Failed == 0 {
+		return result
+	}
+
+	result += "Failures:\n"
+	for _, res := range r.Results {
+		if !res.Success {
+			result += fmt.Sprintf("  %s: %s\n", res.Input, res.Error)
+		}
+	}
+	return result
+}
+
+// FindBatchSongs walks the directory tree for .sng, .chart and .mid files and
+// song folders. A song folder is a directory with a notes.mid or notes.chart,
+// processed as a whole with its audio and song.ini; files inside it are not
+// picked up separately. Songs are sorted by name.
+func FindBatchSongs(root string) ([]BatchSong, error) {
+	var songs []BatchSong
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if !IsSongFolder(path) {
+				return nil
+			}
+			name := rel
+			if name == "." {
+				name = filepath.Base(root)
+			}
+			songs = append(songs, BatchSong{Input: path, Name: name})
+			return filepath.SkipDir
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		for _, songExt := range batchSongExtensions {
+			if ext == songExt {
+				songs = append(songs, BatchSong{Input: path, Name: strings.TrimSuffix(rel, filepath.Ext(rel))})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s for songs: %w", root, err)
+	}
+
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].Name < songs[j].Name
+	})
+	return songs, nil
+}
+
+// RunBatch processes the jobs with a pool of workers calling run for each, and
+// logs the progress as jobs finish
+func RunBatch(jobs []BatchJob, workers int, run func(BatchJob) error) *BatchReport {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	start := time.Now()
+	report := &BatchReport{
+		Total:   len(jobs),
+		Results: make([]BatchResult, len(jobs)),
+	}
+
+	indexes := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	finished := 0
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				job := jobs[i]
+				jobStart := time.Now()
+				err := run(job)
+
+				result := BatchResult{
+					Input:   job.Song.Input,
+					Output:  job.Output,
+					Success: err == nil,
+					Seconds: time.Since(jobStart).Seconds(),
+				}
+				if err != nil {
+					result.Error = err.Error()
+				}
+
+				mu.Lock()
+				report.Results[i] = result
+				finished++
+				if err != nil {
+					report.Failed++
+					log.Printf("[%d/%d] Failed %s: %v", finished, len(jobs), job.Song.Input, err)
+				} else {
+					report.Succeeded++
+					log.Printf("[%d/%d] Done %s", finished, len(jobs), job.Song.Input)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report.Seconds = time.Since(start).Seconds()
+	return report
+}
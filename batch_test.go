@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindBatchSongs(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"rock/Song A/notes.chart",
+		"rock/Song A/song.ogg",
+		"rock/Song A/extra.mid", // Part of the song folder, not a song of its own
+		"rock/b.sng",
+		"pop/c.MID",
+		"pop/readme.txt",
+		"pop/Song D/notes.mid",
+		"pop/Song D/notes.chart",
+	}
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+
+	songs, err := FindBatchSongs(root)
+	if err != nil {
+		t.Fatalf("FindBatchSongs failed: %v", err)
+	}
+
+	expected := []BatchSong{
+		{Input: "pop/Song D", Name: "pop/Song D"},
+		{Input: "pop/c.MID", Name: "pop/c"},
+		{Input: "rock/Song A", Name: "rock/Song A"},
+		{Input: "rock/b.sng", Name: "rock/b"},
+	}
+	if len(songs) != len(expected) {
+		t.Fatalf("expected %d songs, got %+v", len(expected), songs)
+	}
+	for i, want := range expected {
+		want.Input = filepath.Join(root, filepath.FromSlash(want.Input))
+		want.Name = filepath.FromSlash(want.Name)
+		if songs[i] != want {
+			t.Errorf("song %d: expected %+v, got %+v", i, want, songs[i])
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	var jobs []BatchJob
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("song_%02d", i)
+		jobs = append(jobs, BatchJob{Song: BatchSong{Input: name + ".mid", Name: name}, Output: name + ".txt"})
+	}
+
+	report := RunBatch(jobs, 4, func(job BatchJob) error {
+		if strings.HasSuffix(job.Song.Name, "7") {
+			return fmt.Errorf("no tempo events")
+		}
+		return nil
+	})
+
+	if report.Total != 20 || report.Succeeded != 18 || report.Failed != 2 {
+		t.Errorf("expected 18 of 20 jobs to succeed, got %+v", report)
+	}
+	for i, result := range report.Results {
+		if result.Input != jobs[i].Song.Input {
+			t.Errorf("result %d: expected results in job order, got %s", i, result.Input)
+		}
+	}
+	if result := report.Results[7]; result.Success || result.Error != "no tempo events" {
+		t.Errorf("expected song_07 to fail with its error, got %+v", result)
+	}
+	if summary := report.String(); !strings.Contains(summary, "song_17.mid: no tempo events") {
+		t.Errorf("expected the failures in the summary, got:\n%s", summary)
+	}
+}
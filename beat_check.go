@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// BEAT track note numbers
+const (
+	beatNoteDownbeat = 12 // C-1
+	beatNoteBeat     = 13 // C#-1
+)
+
+// Beat issue kinds
+const (
+	BeatIssueMissingDownbeat    = "missing_downbeat"    // No note at the first beat of a measure
+	BeatIssueMissingBeat        = "missing_beat"        // No note at a beat inside a measure
+	BeatIssueDownbeatAsBeat     = "downbeat_as_beat"    // First beat of a measure uses the C#-1 beat note
+	BeatIssueUnexpectedDownbeat = "unexpected_downbeat" // C-1 downbeat note inside a measure
+	BeatIssueMisplacedBeat      = "misplaced_beat"      // Note that does not fall on any beat of the tempo map
+)
+
+// BeatIssue describes a single inconsistency between the BEAT track and the tempo map
+type BeatIssue struct {
+	Measure     int     `json:"measure"` // 1-based measure number from the tempo map
+	Tick        uint32  `json:"tick"`
+	TimeSeconds float64 `json:"time_seconds"`
+	Kind        string  `json:"kind"` // One of the BeatIssue values
+	Message     string  `json:"message"`
+}
+
+// BeatCheckReport is the result of comparing the BEAT track against the tempo map
+type BeatCheckReport struct {
+	Measures      int         `json:"measures"`       // Measures laid out by the tempo map
+	ExpectedBeats int         `json:"expected_beats"` // Beats laid out by the tempo map
+	BeatNotes     int         `json:"beat_notes"`     // Notes found in the BEAT track
+	Issues        []BeatIssue `json:"issues"`
+}
+
+// String returns a human readable listing of the issues grouped by measure
+func (r *BeatCheckReport) String() string {
+	result := fmt.Sprintf("Tempo map: %d measures, %d beats\n", r.Measures, r.ExpectedBeats)
+	result += fmt.Sprintf("BEAT track: %d beat notes\n", r.BeatNotes)
+
+	if len(r.Issues) == 0 {
+		result += "BEAT track matches the tempo map\n"
+		return result
+	}
+
+	badMeasures := 0
+	lastMeasure := -1
+	for _, issue := range r.Issues {
+		if issue.Measure != lastMeasure {
+			result += fmt.Sprintf("Measure %d:\n", issue.Measure)
+			lastMeasure = issue.Measure
+			badMeasures++
+		}
+		result += fmt.Sprintf("  * tick %d (%.3fs): %s\n", issue.Tick, issue.TimeSeconds, issue.Message)
+	}
+
+	result += fmt.Sprintf("Found %d issues in %d measures\n", len(r.Issues), badMeasures)
+	return result
+}
+
+// Beat fix actions, the changes RepairBeatTrack makes to resolve issues
+const (
+	beatFixAdd    = iota // Add a missing note
+	beatFixRekey         // Swap a note between downbeat (C-1) and beat (C#-1)
+	beatFixRemove        // Remove a note off the beat grid
+)
+
+// beatFix is a change to a single note of the BEAT track
+type beatFix struct {
+	action   int
+	tick     uint32
+	downbeat bool // Key of the note to add, or the current key of the note to change
+}
+
+// CheckBeatTrack compares the notes of the BEAT track against the beats expected
+// from the tempo and time signature map and reports every inconsistency
+func (m *MidiFile) CheckBeatTrack() (*BeatCheckReport, error) {
+	report, _, err := m.checkBeatTrack()
+	return report, err
+}
+
+// findBeatTrack returns the index of the BEAT track, or -1 without one
+func (m *MidiFile) findBeatTrack() int {
+	for i, track := range m.SMF.Tracks {
+		if getTrackName(track) == "BEAT" {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkBeatTrack builds the BEAT track report along with the fix for every
+// issue it lists
+func (m *MidiFile) checkBeatTrack() (*BeatCheckReport, []beatFix, error) {
+	tempoMap, err := extractTempoMap(m.SMF, m.GetAudioOffset())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	beatIndex := m.findBeatTrack()
+	if beatIndex == -1 {
+		return nil, nil, fmt.Errorf("no BEAT track found")
+	}
+	beatTrack := m.SMF.Tracks[beatIndex]
+
+	beatNotes, err := extractBeatNotesWithTiming(m.SMF, beatTrack, m.GetAudioOffset())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to extract beat notes: %w", err)
+	}
+
+	endTick := getLastEventTime(m.SMF)
+	if len(beatNotes) > 0 && beatNotes[len(beatNotes)-1].Time >= endTick {
+		endTick = beatNotes[len(beatNotes)-1].Time + 1
+	}
+
+	measures, expectedBeats := tempoMap.CreateMeasures(endTick)
+
+	report := &BeatCheckReport{
+		Measures:      len(measures),
+		ExpectedBeats: len(expectedBeats),
+		BeatNotes:     len(beatNotes),
+		Issues:        []BeatIssue{},
+	}
+	var fixes []beatFix
+
+	// Allow beat notes to be slightly off the grid, a 64th note either way
+	tolerance := uint32(tempoMap.TicksPerQuarter / 16)
+
+	used := make([]bool, len(beatNotes))
+	noteIdx := 0
+
+	for i, measure := range measures {
+		measureNumber := i + 1
+
+		// Collect the beat notes that belong to this measure
+		firstNote := noteIdx
+		for noteIdx < len(beatNotes) && beatNotes[noteIdx].Time+tolerance < measure.EndTime {
+			noteIdx++
+		}
+		measureNotes := beatNotes[firstNote:noteIdx]
+
+		for _, expected := range measure.BeatNotes {
+			match := -1
+			for j, note := range measureNotes {
+				if used[firstNote+j] {
+					continue
+				}
+				if tickDistance(note.Time, expected.Time) <= tolerance {
+					match = firstNote + j
+					break
+				}
+			}
+
+			if match == -1 {
+				kind, message := BeatIssueMissingBeat, "missing beat"
+				if expected.IsDownbeat {
+					kind, message = BeatIssueMissingDownbeat, "missing downbeat"
+				}
+				report.Issues = append(report.Issues, BeatIssue{
+					Measure:     measureNumber,
+					Tick:        expected.Time,
+					TimeSeconds: expected.TimeSeconds,
+					Kind:        kind,
+					Message:     message,
+				})
+				fixes = append(fixes, beatFix{action: beatFixAdd, tick: expected.Time, downbeat: expected.IsDownbeat})
+				continue
+			}
+
+			used[match] = true
+			note := beatNotes[match]
+
+			if expected.IsDownbeat && !note.IsDownbeat {
+				report.Issues = append(report.Issues, BeatIssue{
+					Measure:     measureNumber,
+					Tick:        note.Time,
+					TimeSeconds: note.TimeSeconds,
+					Kind:        BeatIssueDownbeatAsBeat,
+					Message:     "first beat of measure uses beat note (C#-1) instead of downbeat (C-1)",
+				})
+				fixes = append(fixes, beatFix{action: beatFixRekey, tick: note.Time, downbeat: false})
+			} else if !expected.IsDownbeat && note.IsDownbeat {
+				report.Issues = append(report.Issues, BeatIssue{
+					Measure:     measureNumber,
+					Tick:        note.Time,
+					TimeSeconds: note.TimeSeconds,
+					Kind:        BeatIssueUnexpectedDownbeat,
+					Message:     "downbeat note (C-1) inside measure",
+				})
+				fixes = append(fixes, beatFix{action: beatFixRekey, tick: note.Time, downbeat: true})
+			}
+		}
+
+		// Anything left over in this measure is off the beat grid
+		for j, note := range measureNotes {
+			if used[firstNote+j] {
+				continue
+			}
+			report.Issues = append(report.Issues, BeatIssue{
+				Measure:     measureNumber,
+				Tick:        note.Time,
+				TimeSeconds: note.TimeSeconds,
+				Kind:        BeatIssueMisplacedBeat,
+				Message:     "beat note does not fall on a beat of the tempo map",
+			})
+			fixes = append(fixes, beatFix{action: beatFixRemove, tick: note.Time, downbeat: note.IsDownbeat})
+		}
+	}
+
+	return report, fixes, nil
+}
+
+// RepairBeatTrack returns a copy of the MIDI file with the issues of the BEAT
+// track fixed: missing notes are added, notes with the wrong key are switched
+// and notes off the beat grid are removed. Every other note is kept as the
+// author placed it. A BEAT track generated from the tempo and time signature
+// map is added if there is none.
+func (m *MidiFile) RepairBeatTrack() (*smf.SMF, error) {
+	repaired := smf.NewSMF1()
+	repaired.TimeFormat = m.SMF.TimeFormat
+
+	beatIndex := m.findBeatTrack()
+	if beatIndex == -1 {
+		tempoMap, err := extractTempoMap(m.SMF, m.GetAudioOffset())
+		if err != nil {
+			return nil, err
+		}
+		_, expectedBeats := tempoMap.CreateMeasures(getLastEventTime(m.SMF))
+
+		for _, track := range m.SMF.Tracks {
+			if err := repaired.Add(track); err != nil {
+				return nil, fmt.Errorf("failed to add track: %w", err)
+			}
+		}
+		if err := repaired.Add(createBeatTrack(expectedBeats, uint32(tempoMap.TicksPerQuarter))); err != nil {
+			return nil, fmt.Errorf("failed to add BEAT track: %w", err)
+		}
+		return repaired, nil
+	}
+
+	_, fixes, err := m.checkBeatTrack()
+	if err != nil {
+		return nil, err
+	}
+	ticksPerQuarter, ok := m.SMF.TimeFormat.(smf.MetricTicks)
+	if !ok {
+		return nil, fmt.Errorf("unsupported time format, expected MetricTicks")
+	}
+
+	for i, track := range m.SMF.Tracks {
+		if i == beatIndex {
+			track = applyBeatFixes(track, fixes, uint32(ticksPerQuarter))
+		}
+		if err := repaired.Add(track); err != nil {
+			return nil, fmt.Errorf("failed to add track: %w", err)
+		}
+	}
+
+	return repaired, nil
+}
+
+// applyBeatFixes returns a copy of the BEAT track with the fixes applied to the
+// notes they point at, leaving the other events untouched
+func applyBeatFixes(track smf.Track, fixes []beatFix, ticksPerQuarter uint32) smf.Track {
+	type timedEvent struct {
+		time    uint32
+		message smf.Message
+		removed bool
+	}
+
+	// Pair every beat note on with its note off so changes cover both
+	type beatNote struct {
+		time     uint32
+		downbeat bool
+		on, off  int // Event indexes, off is -1 for notes never released
+	}
+
+	var events []timedEvent
+	var notes []beatNote
+	open := make(map[uint8][]int) // Open notes by key
+	var currentTime uint32
+	for _, event := range track {
+		currentTime += event.Delta
+		if event.Message.Is(smf.MetaEndOfTrackMsg) {
+			continue
+		}
+		events = append(events, timedEvent{time: currentTime, message: event.Message})
+
+		var ch, key, vel uint8
+		if event.Message.GetNoteStart(&ch, &key, &vel) && (key == beatNoteDownbeat || key == beatNoteBeat) {
+			notes = append(notes, beatNote{time: currentTime, downbeat: key == beatNoteDownbeat, on: len(events) - 1, off: -1})
+			open[key] = append(open[key], len(notes)-1)
+		} else if event.Message.GetNoteEnd(&ch, &key) && len(open[key]) > 0 {
+			notes[open[key][0]].off = len(events) - 1
+			open[key] = open[key][1:]
+		}
+	}
+
+	// Short notes so they never overlap, even with 32nd note beats
+	noteLength := ticksPerQuarter / 16
+	if noteLength == 0 {
+		noteLength = 1
+	}
+
+	fixed := make([]bool, len(notes))
+	for _, fix := range fixes {
+		if fix.action == beatFixAdd {
+			key := uint8(beatNoteBeat)
+			if fix.downbeat {
+				key = beatNoteDownbeat
+			}
+			events = append(events,
+				timedEvent{time: fix.tick, message: smf.Message(midi.NoteOn(0, key, 100))},
+				timedEvent{time: fix.tick + noteLength, message: smf.Message(midi.NoteOff(0, key))})
+			continue
+		}
+
+		for i, note := range notes {
+			if fixed[i] || note.time != fix.tick || note.downbeat != fix.downbeat {
+				continue
+			}
+			fixed[i] = true
+
+			indexes := []int{note.on}
+			if note.off != -1 {
+				indexes = append(indexes, note.off)
+			}
+
+			newKey := uint8(beatNoteDownbeat)
+			if note.downbeat {
+				newKey = beatNoteBeat
+			}
+			for _, index := range indexes {
+				if fix.action == beatFixRemove {
+					events[index].removed = true
+					continue
+				}
+
+				// Swap the key, keeping channel and velocity
+				var ch, key, vel uint8
+				if events[index].message.GetNoteOn(&ch, &key, &vel) {
+					events[index].message = smf.Message(midi.NoteOn(ch, newKey, vel))
+				} else if events[index].message.GetNoteOff(&ch, &key, &vel) {
+					events[index].message = smf.Message(midi.NoteOffVelocity(ch, newKey, vel))
+				}
+			}
+			break
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time < events[j].time
+	})
+
+	var result smf.Track
+	var lastTime uint32
+	for _, event := range events {
+		if event.removed {
+			continue
+		}
+		result = append(result, smf.Event{Delta: event.time - lastTime, Message: event.message})
+		lastTime = event.time
+	}
+	result.Close(0)
+	return result
+}
+
+// createBeatTrack builds a Rock Band style BEAT track with a C-1 note on every
+// downbeat and a C#-1 note on every other beat
+func createBeatTrack(beats []BeatNote, ticksPerQuarter uint32) smf.Track {
+	var track smf.Track
+	track.Add(0, smf.MetaTrackSequenceName("BEAT"))
+
+	// Short notes so they never overlap, even with 32nd note beats
+	noteLength := ticksPerQuarter / 16
+	if noteLength == 0 {
+		noteLength = 1
+	}
+
+	var lastTime uint32
+	for _, beat := range beats {
+		key := uint8(beatNoteBeat)
+		if beat.IsDownbeat {
+			key = beatNoteDownbeat
+		}
+
+		track.Add(beat.Time-lastTime, midi.NoteOn(0, key, 100))
+		track.Add(noteLength, midi.NoteOff(0, key))
+		lastTime = beat.Time + noteLength
+	}
+
+	track.Close(0)
+	return track
+}
+
+// tickDistance returns the absolute distance between two ticks
+func tickDistance(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -15,33 +15,35 @@ type ChartFile struct {
 	Events    EventsSection           `json:"events"`
 	Tracks    map[string]TrackSection `json:"tracks"`
 	Filename  string                  `json:"filename"`
+
+	audioOffset *float64 // Overrides Song.Offset when set
 }
 
 type SongSection struct {
-	Name         string `json:"name,omitempty"`
-	Artist       string `json:"artist,omitempty"`
-	Charter      string `json:"charter,omitempty"`
-	Album        string `json:"album,omitempty"`
-	Year         string `json:"year,omitempty"`
-	Offset       int    `json:"offset"`
-	Resolution   int    `json:"resolution"`
-	Player2      string `json:"player2,omitempty"`
-	Difficulty   int    `json:"difficulty"`
-	PreviewStart int    `json:"previewStart"`
-	PreviewEnd   int    `json:"previewEnd"`
-	Genre        string `json:"genre,omitempty"`
-	MediaType    string `json:"mediaType,omitempty"`
-	MusicStream  string `json:"musicStream,omitempty"`
-	GuitarStream string `json:"guitarStream,omitempty"`
-	RhythmStream string `json:"rhythmStream,omitempty"`
-	BassStream   string `json:"bassStream,omitempty"`
-	DrumStream   string `json:"drumStream,omitempty"`
-	Drum2Stream  string `json:"drum2Stream,omitempty"`
-	Drum3Stream  string `json:"drum3Stream,omitempty"`
-	Drum4Stream  string `json:"drum4Stream,omitempty"`
-	VocalStream  string `json:"vocalStream,omitempty"`
-	KeysStream   string `json:"keysStream,omitempty"`
-	CrowdStream  string `json:"crowdStream,omitempty"`
+	Name         string  `json:"name,omitempty"`
+	Artist       string  `json:"artist,omitempty"`
+	Charter      string  `json:"charter,omitempty"`
+	Album        string  `json:"album,omitempty"`
+	Year         string  `json:"year,omitempty"`
+	Offset       float64 `json:"offset"` // Seconds of audio before tick 0
+	Resolution   int     `json:"resolution"`
+	Player2      string  `json:"player2,omitempty"`
+	Difficulty   int     `json:"difficulty"`
+	PreviewStart int     `json:"previewStart"`
+	PreviewEnd   int     `json:"previewEnd"`
+	Genre        string  `json:"genre,omitempty"`
+	MediaType    string  `json:"mediaType,omitempty"`
+	MusicStream  string  `json:"musicStream,omitempty"`
+	GuitarStream string  `json:"guitarStream,omitempty"`
+	RhythmStream string  `json:"rhythmStream,omitempty"`
+	BassStream   string  `json:"bassStream,omitempty"`
+	DrumStream   string  `json:"drumStream,omitempty"`
+	Drum2Stream  string  `json:"drum2Stream,omitempty"`
+	Drum3Stream  string  `json:"drum3Stream,omitempty"`
+	Drum4Stream  string  `json:"drum4Stream,omitempty"`
+	VocalStream  string  `json:"vocalStream,omitempty"`
+	KeysStream   string  `json:"keysStream,omitempty"`
+	CrowdStream  string  `json:"crowdStream,omitempty"`
 }
 
 type SyncTrackSection struct {
@@ -71,8 +73,10 @@ type EventsSection struct {
 }
 
 type GlobalEvent struct {
-	Tick uint32 `json:"tick"`
-	Text string `json:"text"`
+	Tick  uint32 `json:"tick"`
+	Text  string `json:"text"`
+	Type  string `json:"type"`            // One of the ChartEvent values, see ParseChartEvent
+	Value string `json:"value,omitempty"` // Section name, lyric or crowd mode
 }
 
 type TrackSection struct {
@@ -110,8 +114,10 @@ type SpecialEvent struct {
 }
 
 type TrackEvent struct {
-	Tick uint32 `json:"tick"`
-	Text string `json:"text"`
+	Tick  uint32 `json:"tick"`
+	Text  string `json:"text"`
+	Type  string `json:"type"`            // One of the ChartEvent values, see ParseChartEvent
+	Value string `json:"value,omitempty"` // Section name, lyric or crowd mode
 }
 
 // PendingFlag represents a flag that needs to be applied to notes after all notes are parsed
@@ -249,7 +255,7 @@ func parseSongLine(chart *ChartFile, line string) error {
 	case "Year":
 		chart.Song.Year = value
 	case "Offset":
-		if val, err := strconv.Atoi(value); err == nil {
+		if val, err := strconv.ParseFloat(value, 64); err == nil {
 			chart.Song.Offset = val
 		}
 	case "Resolution":
@@ -385,9 +391,12 @@ func parseEventsLine(chart *ChartFile, line string) error {
 	text := strings.Join(eventParts[1:], " ")
 	text = unquoteString(text)
 
+	eventType, value := ParseChartEvent(text)
 	chart.Events.GlobalEvents = append(chart.Events.GlobalEvents, GlobalEvent{
-		Tick: uint32(tick),
-		Text: text,
+		Tick:  uint32(tick),
+		Text:  text,
+		Type:  eventType,
+		Value: value,
 	})
 
 	return nil
@@ -482,9 +491,12 @@ func parseTrackLine(chart *ChartFile, section, line string) error {
 	case "E": // Track event
 		if len(eventParts) >= 2 {
 			text := strings.Join(eventParts[1:], " ")
+			eventType, value := ParseChartEvent(text)
 			track.TrackEvents = append(track.TrackEvents, TrackEvent{
-				Tick: uint32(tick),
-				Text: text,
+				Tick:  uint32(tick),
+				Text:  text,
+				Type:  eventType,
+				Value: value,
 			})
 		}
 	}
@@ -695,6 +707,20 @@ func (c *ChartFile) GetBPMAtTick(tick uint32) float64 {
 	return float64(currentBPM) / 1000.0 // Convert from BPM*1000 to actual BPM
 }
 
+// GetAudioOffset returns the seconds of audio that play before tick 0, taken
+// from the Offset field unless it has been overridden
+func (c *ChartFile) GetAudioOffset() float64 {
+	if c.audioOffset != nil {
+		return *c.audioOffset
+	}
+	return c.Song.Offset
+}
+
+// SetAudioOffset overrides the chart Offset used for timing calculations
+func (c *ChartFile) SetAudioOffset(seconds float64) {
+	c.audioOffset = &seconds
+}
+
 func (c *ChartFile) GetMetadata() map[string]string {
 	result := make(map[string]string)
 
@@ -0,0 +1,89 @@
+package main
+
+import "strings"
+
+// Chart event types, see ParseChartEvent
+const (
+	ChartEventText        = "text"         // Any event not listed below
+	ChartEventSection     = "section"      // Practice section, Value is the section name
+	ChartEventLyric       = "lyric"        // Vocal syllable, Value is the raw lyric with Rock Band formatting
+	ChartEventPhraseStart = "phrase_start" // Start of a lyric line
+	ChartEventPhraseEnd   = "phrase_end"   // End of a lyric line
+	ChartEventCrowd       = "crowd"        // Crowd behavior, Value is the mode such as intense or noclap
+	ChartEventCoda        = "coda"         // Start of the big rock ending
+	ChartEventEnd         = "end"          // End of the song
+	ChartEventSoloStart   = "solo_start"   // Start of a solo section, usually a track event
+	ChartEventSoloEnd     = "solo_end"     // End of a solo section, usually a track event
+)
+
+// ParseChartEvent classifies the text of a global or track event. Brackets
+// around the text, as left behind by charts converted from Rock Band MIDI, are
+// ignored. value holds the section name, lyric or crowd mode and is empty for
+// the other types.
+//
+//	"section Verse 1"  → section, "Verse 1"
+//	"[prc_verse_1]"    → section, "verse_1"
+//	"lyric Hel-"       → lyric, "Hel-"
+//	"[crowd_intense]"  → crowd, "intense"
+//	"soloend"          → solo_end, ""
+func ParseChartEvent(text string) (eventType string, value string) {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+		trimmed = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+	}
+
+	switch {
+	case strings.HasPrefix(trimmed, "section "):
+		return ChartEventSection, strings.TrimSpace(strings.TrimPrefix(trimmed, "section "))
+	case strings.HasPrefix(trimmed, "prc_"):
+		return ChartEventSection, strings.TrimPrefix(trimmed, "prc_")
+	case trimmed == "lyric" || strings.HasPrefix(trimmed, "lyric "):
+		return ChartEventLyric, strings.TrimSpace(strings.TrimPrefix(trimmed, "lyric"))
+	case trimmed == "phrase_start":
+		return ChartEventPhraseStart, ""
+	case trimmed == "phrase_end":
+		return ChartEventPhraseEnd, ""
+	case strings.HasPrefix(trimmed, "crowd_"):
+		return ChartEventCrowd, strings.TrimPrefix(trimmed, "crowd_")
+	case trimmed == "coda":
+		return ChartEventCoda, ""
+	case trimmed == "end":
+		return ChartEventEnd, ""
+	case trimmed == "solo" || trimmed == "solostart" || trimmed == "solo_start":
+		return ChartEventSoloStart, ""
+	case trimmed == "soloend" || trimmed == "solo_end":
+		return ChartEventSoloEnd, ""
+	default:
+		return ChartEventText, ""
+	}
+}
+
+// GlobalEventsOfType returns the global events of the given type in tick order
+func (c *ChartFile) GlobalEventsOfType(eventType string) []GlobalEvent {
+	var events []GlobalEvent
+	if c == nil {
+		return events
+	}
+
+	for _, event := range c.Events.GlobalEvents {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// TrackEventsOfType returns the events of the given type on a single track
+func (t *TrackSection) TrackEventsOfType(eventType string) []TrackEvent {
+	var events []TrackEvent
+	if t == nil {
+		return events
+	}
+
+	for _, event := range t.TrackEvents {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
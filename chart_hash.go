@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// chartHashNotesFiles are the notes files of an SNG package the hash covers, in
+// the order the games pick them
+var chartHashNotesFiles = []string{"notes.mid", "notes.chart"}
+
+// ChartHash identifies a chart the way Clone Hero and YARG do, by hashing the
+// exact bytes of its notes file. Any edit to the notes file, even whitespace,
+// gives a new hash, while changes to audio, images or song.ini don't.
+type ChartHash struct {
+	File string `json:"file"` // Notes file the hashes cover
+	MD5  string `json:"md5"`  // Song hash of Clone Hero scores and setlists
+	SHA1 string `json:"sha1"` // Song hash of YARG
+}
+
+// NewChartHash hashes the data of a notes file
+func NewChartHash(file string, data []byte) *ChartHash {
+	md5Sum := md5.Sum(data)
+	sha1Sum := sha1.Sum(data)
+	return &ChartHash{
+		File: file,
+		MD5:  hex.EncodeToString(md5Sum[:]),
+		SHA1: hex.EncodeToString(sha1Sum[:]),
+	}
+}
+
+// Matches reports whether the hash is either of the chart hashes, ignoring case
+func (h *ChartHash) Matches(hash string) bool {
+	hash = strings.TrimSpace(hash)
+	return strings.EqualFold(hash, h.MD5) || strings.EqualFold(hash, h.SHA1)
+}
+
+// ChartHash hashes the notes file of the package, notes.mid when it has one and
+// notes.chart otherwise
+func (s *SngFile) ChartHash() (*ChartHash, error) {
+	for _, notesFile := range chartHashNotesFiles {
+		if !s.hasFile(notesFile) {
+			continue
+		}
+		data, err := s.ReadFile(notesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", notesFile, err)
+		}
+		return NewChartHash(notesFile, data), nil
+	}
+	return nil, fmt.Errorf("no MIDI or chart file found in SNG package")
+}
+
+// ChartHashFile hashes the chart of a .sng, .chart or .mid file or a song
+// folder. SNG packages and song folders are hashed through their notes file,
+// other files as they are.
+func ChartHashFile(path string) (*ChartHash, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		sngFile, err := OpenSongFolder(path)
+		if err != nil {
+			return nil, err
+		}
+		return sngFile.ChartHash()
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".sng" {
+		sngFile, err := OpenSngFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defer sngFile.Close()
+		return sngFile.ChartHash()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash chart: %w", err)
+	}
+	return NewChartHash(filepath.Base(path), data), nil
+}
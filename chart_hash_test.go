@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestChartHash(t *testing.T) {
+	hash := NewChartHash("notes.chart", []byte("abc"))
+	if hash.MD5 != "900150983cd24fb0d6963f7d28e17f72" || hash.SHA1 != "a9993e364706816aba3e25717850c26c9cd0d89d" {
+		t.Errorf("unexpected hashes of abc: %+v", hash)
+	}
+	if !hash.Matches("900150983CD24FB0D6963F7D28E17F72") || !hash.Matches(" a9993e364706816aba3e25717850c26c9cd0d89d") {
+		t.Errorf("expected either hash to match in any case")
+	}
+	if hash.Matches("") || hash.Matches("abc") {
+		t.Errorf("expected other strings not to match")
+	}
+
+	chartPath := filepath.Join(t.TempDir(), "song.chart")
+	if err := os.WriteFile(chartPath, []byte(validChartData), 0644); err != nil {
+		t.Fatalf("failed to write chart: %v", err)
+	}
+	fileHash, err := ChartHashFile(chartPath)
+	if err != nil {
+		t.Fatalf("ChartHashFile failed: %v", err)
+	}
+	if *fileHash != *NewChartHash("song.chart", []byte(validChartData)) {
+		t.Errorf("expected the hash of the chart bytes, got %+v", fileHash)
+	}
+}
+
+func TestSngChartHash(t *testing.T) {
+	// Packages with both notes files are hashed through notes.mid
+	sngFile := writeTestSngFile(t, map[string]string{"name": "Test Song"}, []sngTestFile{
+		{"notes.chart", []byte(validChartData)},
+		{"notes.mid", []byte("midi data")},
+		{"song.opus", []byte("song audio")},
+	})
+
+	hash, err := sngFile.ChartHash()
+	if err != nil {
+		t.Fatalf("ChartHash failed: %v", err)
+	}
+	if *hash != *NewChartHash("notes.mid", []byte("midi data")) {
+		t.Errorf("expected the hash of notes.mid, got %+v", hash)
+	}
+
+	fileHash, err := ChartHashFile(sngFile.reader.Name())
+	if err != nil {
+		t.Fatalf("ChartHashFile failed: %v", err)
+	}
+	if *fileHash != *hash {
+		t.Errorf("expected the same hash from the package path, got %+v", fileHash)
+	}
+
+	sngFile = writeTestSngFile(t, nil, []sngTestFile{{"song.opus", []byte("song audio")}})
+	if _, err := sngFile.ChartHash(); err == nil {
+		t.Errorf("expected an error for a package without notes")
+	}
+}
+
+func TestMidiInfoJSON(t *testing.T) {
+	smfData := smf.NewSMF1()
+	var track smf.Track
+	track.Add(0, smf.MetaTrackSequenceName("PART DRUMS"))
+	track.Close(0)
+	smfData.Add(track)
+
+	plain, err := smfData.MarshalJSONIndent()
+	if err != nil {
+		t.Fatalf("MarshalJSONIndent failed: %v", err)
+	}
+
+	// Without a hash the output is the SMF's own JSON
+	var output bytes.Buffer
+	if err := printMidiInfo(&output, smfData, "notes.mid", nil, true, ""); err != nil {
+		t.Fatalf("printMidiInfo failed: %v", err)
+	}
+	if strings.TrimSpace(output.String()) != string(plain) {
+		t.Errorf("expected the SMF JSON unchanged, got:\n%s", output.String())
+	}
+
+	// The hash follows the SMF keys, which keep their order
+	output.Reset()
+	hash := NewChartHash("notes.mid", []byte("midi data"))
+	if err := printMidiInfo(&output, smfData, "notes.mid", hash, true, ""); err != nil {
+		t.Fatalf("printMidiInfo failed: %v", err)
+	}
+	withHash := strings.TrimSpace(output.String())
+	smfKeys := strings.TrimSuffix(string(plain), "\n}")
+	if !strings.HasPrefix(withHash, smfKeys+",\n  \"chart_hash\": {") {
+		t.Errorf("expected the chart hash after the SMF keys, got:\n%s", withHash)
+	}
+	if !strings.Contains(withHash, hash.MD5) {
+		t.Errorf("expected the MD5 %s in the output", hash.MD5)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"testing"
@@ -531,6 +532,59 @@ func TestTrackEvents(t *testing.T) {
 	if trackEvent.Text != "solostart" {
 		t.Errorf("Expected track event text 'solostart', got '%s'", trackEvent.Text)
 	}
+	if trackEvent.Type != ChartEventSoloStart {
+		t.Errorf("Expected track event type '%s', got '%s'", ChartEventSoloStart, trackEvent.Type)
+	}
+}
+
+func TestParseChartEvent(t *testing.T) {
+	testCases := []struct {
+		text      string
+		eventType string
+		value     string
+	}{
+		{"section Verse 1", ChartEventSection, "Verse 1"},
+		{"[section chorus_1]", ChartEventSection, "chorus_1"},
+		{"[prc_verse_2]", ChartEventSection, "verse_2"},
+		{"lyric Hel-", ChartEventLyric, "Hel-"},
+		{"lyric", ChartEventLyric, ""},
+		{"phrase_start", ChartEventPhraseStart, ""},
+		{"phrase_end", ChartEventPhraseEnd, ""},
+		{"[crowd_intense]", ChartEventCrowd, "intense"},
+		{"crowd_noclap", ChartEventCrowd, "noclap"},
+		{"[coda]", ChartEventCoda, ""},
+		{"end", ChartEventEnd, ""},
+		{"solo", ChartEventSoloStart, ""},
+		{"soloend", ChartEventSoloEnd, ""},
+		{"song_start", ChartEventText, ""},
+		{"lyrical", ChartEventText, ""},
+	}
+
+	for _, tc := range testCases {
+		eventType, value := ParseChartEvent(tc.text)
+		if eventType != tc.eventType || value != tc.value {
+			t.Errorf("ParseChartEvent(%q): expected (%s, %q), got (%s, %q)", tc.text, tc.eventType, tc.value, eventType, value)
+		}
+	}
+
+	chart, err := ParseChartFile(strings.NewReader(validChartData))
+	if err != nil {
+		t.Fatalf("Failed to parse chart: %v", err)
+	}
+
+	sections := chart.GlobalEventsOfType(ChartEventSection)
+	if len(sections) != 3 || sections[0].Value != "Verse 1" || sections[2].Tick != 1536 {
+		t.Errorf("Unexpected sections: %+v", sections)
+	}
+
+	jsonData, err := json.Marshal(chart.Events.GlobalEvents[1])
+	if err != nil {
+		t.Fatalf("Failed to marshal event: %v", err)
+	}
+	expectedJSON := `{"tick":384,"text":"section Verse 1","type":"section","value":"Verse 1"}`
+	if string(jsonData) != expectedJSON {
+		t.Errorf("Expected JSON %s, got %s", expectedJSON, jsonData)
+	}
 }
 
 // Note Flag Processing Tests
@@ -934,6 +988,110 @@ func TestGetBPMAtTick(t *testing.T) {
 	}
 }
 
+func TestChartAudioOffset(t *testing.T) {
+	chartData := strings.Replace(validChartData, "Offset = 0", "Offset = 0.25", 1)
+	chart, err := ParseChartFile(strings.NewReader(chartData))
+	if err != nil {
+		t.Fatalf("Failed to parse chart: %v", err)
+	}
+
+	if chart.Song.Offset != 0.25 {
+		t.Errorf("Expected Offset 0.25, got %f", chart.Song.Offset)
+	}
+
+	timeline, err := chart.GetTimeline()
+	if err != nil {
+		t.Fatalf("Failed to get timeline: %v", err)
+	}
+	if timeline.Measures[0].StartTimeSeconds != 0.25 {
+		t.Errorf("Expected first measure at 0.25s, got %f", timeline.Measures[0].StartTimeSeconds)
+	}
+
+	chart.SetAudioOffset(-0.5)
+	timeline, err = chart.GetTimeline()
+	if err != nil {
+		t.Fatalf("Failed to get timeline: %v", err)
+	}
+	if timeline.AudioOffset != -0.5 || timeline.Measures[0].StartTimeSeconds != -0.5 {
+		t.Errorf("Expected overridden offset -0.5, got %f (first measure %f)",
+			timeline.AudioOffset, timeline.Measures[0].StartTimeSeconds)
+	}
+}
+
+func TestSliceChart(t *testing.T) {
+	chartData := `[Song]
+{
+  Offset = 0.5
+  Resolution = 192
+}
+[SyncTrack]
+{
+  0 = TS 4
+  0 = B 120000
+  768 = B 60000
+  1536 = B 90000
+}
+[Events]
+{
+  0 = E "section Intro"
+  1000 = E "section Verse"
+}
+[ExpertDrums]
+{
+  0 = N 0 0
+  700 = N 1 0
+  800 = N 0 1000
+  1600 = N 0 0
+  0 = S 2 1000
+}`
+
+	chart, err := ParseChartFile(strings.NewReader(chartData))
+	if err != nil {
+		t.Fatalf("Failed to parse chart: %v", err)
+	}
+
+	timeline, err := chart.GetTimeline()
+	if err != nil {
+		t.Fatalf("Failed to get timeline: %v", err)
+	}
+
+	slice, err := ResolveSongSlice(timeline, "2", "2")
+	if err != nil {
+		t.Fatalf("ResolveSongSlice failed: %v", err)
+	}
+	if slice.StartSeconds != 2.5 || slice.AudioOffset() != 0 {
+		t.Errorf("Expected slice at 2.5s with no offset, got %+v", slice)
+	}
+
+	sliced, err := SliceChart(chart, slice)
+	if err != nil {
+		t.Fatalf("SliceChart failed: %v", err)
+	}
+
+	if len(sliced.SyncTrack.BPMEvents) != 1 || sliced.SyncTrack.BPMEvents[0] != (BPMEvent{Tick: 0, BPM: 60000}) {
+		t.Errorf("Expected the 60 BPM tempo at tick 0, got %+v", sliced.SyncTrack.BPMEvents)
+	}
+	if len(sliced.SyncTrack.TimeSigEvents) != 1 || sliced.SyncTrack.TimeSigEvents[0].Tick != 0 {
+		t.Errorf("Expected the time signature at tick 0, got %+v", sliced.SyncTrack.TimeSigEvents)
+	}
+
+	var sections []string
+	for _, event := range sliced.GlobalEventsOfType(ChartEventSection) {
+		sections = append(sections, fmt.Sprintf("%d %s", event.Tick, event.Value))
+	}
+	if strings.Join(sections, ", ") != "0 Intro, 232 Verse" {
+		t.Errorf("Expected Intro carried over to tick 0, got %v", sections)
+	}
+
+	drums := sliced.Tracks["ExpertDrums"]
+	if len(drums.Notes) != 1 || drums.Notes[0].Tick != 32 || drums.Notes[0].Sustain != 736 {
+		t.Errorf("Expected the note at 800 clipped to the slice, got %+v", drums.Notes)
+	}
+	if len(drums.Specials) != 1 || drums.Specials[0].Tick != 0 || drums.Specials[0].Length != 232 {
+		t.Errorf("Expected the star power phrase clipped to 0-232, got %+v", drums.Specials)
+	}
+}
+
 func TestGetBPMAtTickNoBPMEvents(t *testing.T) {
 	chart := &ChartFile{
 		Song: SongSection{Resolution: 192},
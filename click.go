@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// Click levels, from loudest to quietest
+const (
+	ClickAccent      = "accent"      // First beat of a measure
+	ClickBeat        = "beat"        // Other beats
+	ClickSubdivision = "subdivision" // Clicks between beats
+)
+
+// clickTicksPerQuarter is the resolution of exported click MIDI files. Every
+// beat is a quarter note with its own tempo.
+const clickTicksPerQuarter = 480
+
+// clickKeys are the General MIDI percussion keys and velocities for each level
+var clickKeys = map[string]struct {
+	key      uint8
+	velocity uint8
+}{
+	ClickAccent:      {HiWoodBlock, 127},
+	ClickBeat:        {LowWoodBlock, 100},
+	ClickSubdivision: {LowWoodBlock, 60},
+}
+
+// ClickOptions configures a click track
+type ClickOptions struct {
+	Subdivisions int // Clicks per beat, 1 (or 0) for beats only
+	CountIn      int // Measures of clicks before the first measure
+}
+
+// Click is a single metronome click
+type Click struct {
+	Tick    uint32  `json:"tick"`    // Tick in the click MIDI file
+	Seconds float64 `json:"seconds"` // Time in the click output
+	Level   string  `json:"level"`   // One of the Click* levels
+}
+
+// ClickTrack is a metronome following the beats of a timeline. The tempo and
+// time signature changes make the beats of the MIDI file land on the song beats.
+type ClickTrack struct {
+	Clicks   []Click         `json:"clicks"`
+	Tempos   []TempoChange   `json:"tempos"`
+	TimeSigs []TimeSigChange `json:"time_sigs"`
+	Length   float64         `json:"length"`   // Seconds, up to the end of the last measure
+	EndTick  uint32          `json:"end_tick"` // Tick of the end of the last measure
+	// AudioDelay is how many seconds the song audio must be delayed to line up
+	// with the click. It is only set when the count-in starts before the audio.
+	AudioDelay float64 `json:"audio_delay"`
+}
+
+// clickBeat is a beat of the click track, in seconds of the song audio
+type clickBeat struct {
+	seconds      float64
+	downbeat     bool
+	measureBeats int // Beats in the measure, set on downbeats
+}
+
+// BuildClickTrack creates a click on every beat of the timeline, with accented
+// downbeats, optional subdivisions and count-in measures at the tempo and time
+// signature of the first measure. The click lines up with the song audio unless
+// the count-in starts before it, then AudioDelay is set.
+func BuildClickTrack(timeline *Timeline, options ClickOptions) (*ClickTrack, error) {
+	if timeline == nil || len(timeline.Measures) == 0 {
+		return nil, fmt.Errorf("timeline has no measures for a click track")
+	}
+	if options.Subdivisions <= 0 {
+		options.Subdivisions = 1
+	}
+	if options.CountIn < 0 {
+		return nil, fmt.Errorf("count-in must not be negative")
+	}
+
+	beats := collectClickBeats(timeline, options.CountIn)
+	if len(beats) == 0 {
+		return nil, fmt.Errorf("timeline has no beats for a click track")
+	}
+	endSeconds := timeline.Measures[len(timeline.Measures)-1].EndTimeSeconds
+
+	track := &ClickTrack{}
+	shift := 0.0
+	if beats[0].seconds < 0 {
+		shift = -beats[0].seconds
+		track.AudioDelay = shift
+	}
+	track.Length = endSeconds + shift
+
+	// Silence before the first click becomes a lead-in of a few quarter notes,
+	// short enough for the tempo to stay within the MIDI limits
+	var tick uint32
+	if lead := beats[0].seconds + shift; lead > 0.001 {
+		quarters := int(math.Ceil(lead / 4))
+		if quarters > 255 {
+			return nil, fmt.Errorf("click starts %.1fs after the audio, too late for a MIDI lead-in", lead)
+		}
+		track.Tempos = append(track.Tempos, TempoChange{Tick: 0, BPM: 60 * float64(quarters) / lead})
+		track.TimeSigs = append(track.TimeSigs, TimeSigChange{Tick: 0, Numerator: uint8(quarters), Denominator: 4})
+		tick = uint32(quarters * clickTicksPerQuarter)
+	}
+
+	for i, beat := range beats {
+		nextSeconds := endSeconds
+		if i+1 < len(beats) {
+			nextSeconds = beats[i+1].seconds
+		}
+		duration := nextSeconds - beat.seconds
+		if duration <= 0 {
+			continue
+		}
+
+		bpm := 60 / duration
+		if len(track.Tempos) == 0 || math.Abs(track.Tempos[len(track.Tempos)-1].BPM-bpm) > 1e-6 {
+			track.Tempos = append(track.Tempos, TempoChange{Tick: tick, BPM: bpm})
+		}
+		if beat.downbeat {
+			last := len(track.TimeSigs) - 1
+			if last < 0 || track.TimeSigs[last].Numerator != uint8(beat.measureBeats) {
+				track.TimeSigs = append(track.TimeSigs, TimeSigChange{Tick: tick, Numerator: uint8(beat.measureBeats), Denominator: 4})
+			}
+		}
+
+		for s := 0; s < options.Subdivisions; s++ {
+			level := ClickSubdivision
+			if s == 0 && beat.downbeat {
+				level = ClickAccent
+			} else if s == 0 {
+				level = ClickBeat
+			}
+			track.Clicks = append(track.Clicks, Click{
+				Tick:    tick + uint32(s*clickTicksPerQuarter/options.Subdivisions),
+				Seconds: beat.seconds + shift + float64(s)*duration/float64(options.Subdivisions),
+				Level:   level,
+			})
+		}
+
+		tick += clickTicksPerQuarter
+	}
+	track.EndTick = tick
+
+	return track, nil
+}
+
+// collectClickBeats lists the beats of the timeline, preceded by the count-in
+// measures
+func collectClickBeats(timeline *Timeline, countIn int) []clickBeat {
+	songBeats := timeline.GetBeats()
+
+	var beats []clickBeat
+	for i, beat := range songBeats {
+		if beat.TimeSeconds >= timeline.Measures[len(timeline.Measures)-1].EndTimeSeconds {
+			break
+		}
+		entry := clickBeat{seconds: beat.TimeSeconds, downbeat: beat.IsDownbeat || i == 0}
+		if entry.downbeat {
+			entry.measureBeats = 1
+			for j := i + 1; j < len(songBeats) && !songBeats[j].IsDownbeat; j++ {
+				entry.measureBeats++
+			}
+		}
+		beats = append(beats, entry)
+	}
+
+	if countIn == 0 || len(beats) == 0 {
+		return beats
+	}
+
+	// A pickup is shorter than the measure after it, the count-in follows the
+	// first full measure instead
+	full := timeline.Measures[0]
+	if len(timeline.Measures) > 1 && timeline.Measures[1].BeatsPerMeasure > full.BeatsPerMeasure {
+		full = timeline.Measures[1]
+	}
+	beatsPerMeasure := full.BeatsPerMeasure
+	if beatsPerMeasure <= 0 {
+		beatsPerMeasure = beats[0].measureBeats
+	}
+	beatLength := (full.EndTimeSeconds - full.StartTimeSeconds) / float64(beatsPerMeasure)
+
+	var countInBeats []clickBeat
+	countInStart := beats[0].seconds - float64(countIn*beatsPerMeasure)*beatLength
+	for i := 0; i < countIn*beatsPerMeasure; i++ {
+		countInBeats = append(countInBeats, clickBeat{
+			seconds:      countInStart + float64(i)*beatLength,
+			downbeat:     i%beatsPerMeasure == 0,
+			measureBeats: beatsPerMeasure,
+		})
+	}
+
+	return append(countInBeats, beats...)
+}
+
+// WriteMidiTo writes the click as a General MIDI file with a conductor track and
+// a single drum track
+func (c *ClickTrack) WriteMidiTo(writer io.Writer) error {
+	smfData := smf.NewSMF1()
+	smfData.TimeFormat = smf.MetricTicks(clickTicksPerQuarter)
+
+	var conductor []MidiEvent
+	for _, timeSig := range c.TimeSigs {
+		conductor = append(conductor, MidiEvent{Time: timeSig.Tick, Message: smf.MetaTimeSig(timeSig.Numerator, timeSig.Denominator, 24, 8)})
+	}
+	for _, tempo := range c.Tempos {
+		conductor = append(conductor, MidiEvent{Time: tempo.Tick, Message: smf.MetaTempo(tempo.BPM)})
+	}
+	sort.SliceStable(conductor, func(i, j int) bool {
+		return conductor[i].Time < conductor[j].Time
+	})
+
+	var tempoTrack smf.Track
+	tempoTrack.Add(0, smf.MetaTrackSequenceName("Tempo"))
+	var lastTime uint32
+	for _, event := range conductor {
+		tempoTrack.Add(event.Time-lastTime, event.Message)
+		lastTime = event.Time
+	}
+	tempoTrack.Close(c.EndTick - lastTime)
+	smfData.Add(tempoTrack)
+
+	var events []MidiEvent
+	for _, click := range c.Clicks {
+		sound := clickKeys[click.Level]
+		events = append(events,
+			MidiEvent{Time: click.Tick, Message: smf.Message(midi.NoteOn(gmDrumChannel, sound.key, sound.velocity))},
+			MidiEvent{Time: click.Tick + clickTicksPerQuarter/16, Message: smf.Message(midi.NoteOff(gmDrumChannel, sound.key))},
+		)
+	}
+	smfData.Add(createMidiTrack(TrackInfo{Name: "Click", Channel: gmDrumChannel, Events: events}))
+
+	if _, err := smfData.WriteTo(writer); err != nil {
+		return fmt.Errorf("error writing click MIDI file: %w", err)
+	}
+	return nil
+}
+
+// WriteWavTo renders the click to a WAV file with the built-in synthesizer
+func (c *ClickTrack) WriteWavTo(writer io.Writer, sampleRate int) error {
+	if sampleRate <= 0 {
+		sampleRate = DefaultSynthSampleRate
+	}
+
+	samples := make([]float64, int((c.Length+synthTailTime)*float64(sampleRate)))
+	renderClick(samples, sampleRate, c.Clicks)
+	return writeWav(writer, normalizeSamples(samples), sampleRate)
+}
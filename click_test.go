@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestBuildClickTrackCountIn(t *testing.T) {
+	timeline, err := (&MidiFile{SMF: createTempoMapMidiFile()}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+
+	clickTrack, err := BuildClickTrack(timeline, ClickOptions{Subdivisions: 2, CountIn: 1})
+	if err != nil {
+		t.Fatalf("BuildClickTrack failed: %v", err)
+	}
+
+	// A bar of 4/4 at 120 BPM counts in before the first measure at 0s
+	if math.Abs(clickTrack.AudioDelay-2) > 1e-6 {
+		t.Errorf("expected the audio to be delayed by 2s, got %.3f", clickTrack.AudioDelay)
+	}
+	if len(clickTrack.Clicks) != (4+14)*2 {
+		t.Fatalf("expected %d clicks, got %d", (4+14)*2, len(clickTrack.Clicks))
+	}
+
+	expected := []Click{
+		{Tick: 0, Seconds: 0, Level: ClickAccent},
+		{Tick: 240, Seconds: 0.25, Level: ClickSubdivision},
+		{Tick: 480, Seconds: 0.5, Level: ClickBeat},
+	}
+	for i, want := range expected {
+		got := clickTrack.Clicks[i]
+		if got.Tick != want.Tick || got.Level != want.Level || math.Abs(got.Seconds-want.Seconds) > 1e-6 {
+			t.Errorf("click %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	// The first song downbeat follows the count-in
+	if click := clickTrack.Clicks[8]; click.Level != ClickAccent || math.Abs(click.Seconds-2) > 1e-6 {
+		t.Errorf("expected the first measure accented at 2s, got %+v", click)
+	}
+
+	// The MIDI file has the measures of the count-in and the song
+	var buf bytes.Buffer
+	if err := clickTrack.WriteMidiTo(&buf); err != nil {
+		t.Fatalf("WriteMidiTo failed: %v", err)
+	}
+	smfData, err := smf.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("failed to read click MIDI: %v", err)
+	}
+	clickTimeline, err := (&MidiFile{SMF: smfData}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline of click failed: %v", err)
+	}
+
+	measures := []struct {
+		beats int
+		start float64
+	}{
+		{4, 0}, {4, 2}, {4, 4}, {3, 6}, {3, 8},
+	}
+	if len(clickTimeline.Measures) != len(measures) {
+		t.Fatalf("expected %d measures, got %d", len(measures), len(clickTimeline.Measures))
+	}
+	for i, want := range measures {
+		got := clickTimeline.Measures[i]
+		if got.BeatsPerMeasure != want.beats || math.Abs(got.StartTimeSeconds-want.start) > 1e-3 {
+			t.Errorf("measure %d: expected %d beats at %.1fs, got %d at %.3fs", i+1, want.beats, want.start, got.BeatsPerMeasure, got.StartTimeSeconds)
+		}
+	}
+
+	var wav bytes.Buffer
+	if err := clickTrack.WriteWavTo(&wav, 0); err != nil {
+		t.Fatalf("WriteWavTo failed: %v", err)
+	}
+	samples := readTestWav(t, wav.Bytes())
+	if peakBetween(samples, 2, 2.03) <= peakBetween(samples, 2.5, 2.53) {
+		t.Errorf("expected the downbeat at 2s to be accented")
+	}
+}
+
+func TestBuildClickTrackLeadIn(t *testing.T) {
+	timeline, err := (&MidiFile{SMF: createTempoMapMidiFile(), audioOffset: 1}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+
+	clickTrack, err := BuildClickTrack(timeline, ClickOptions{})
+	if err != nil {
+		t.Fatalf("BuildClickTrack failed: %v", err)
+	}
+
+	// The click stays in line with the audio, starting after a beat of silence
+	if clickTrack.AudioDelay != 0 {
+		t.Errorf("expected no audio delay, got %.3f", clickTrack.AudioDelay)
+	}
+	first := clickTrack.Clicks[0]
+	if first.Tick != clickTicksPerQuarter || math.Abs(first.Seconds-1) > 1e-6 {
+		t.Errorf("expected the first click after a lead-in quarter at 1s, got %+v", first)
+	}
+	if len(clickTrack.Clicks) != 14 || math.Abs(clickTrack.Length-9) > 1e-3 {
+		t.Errorf("expected 14 clicks over 9s, got %d over %.3fs", len(clickTrack.Clicks), clickTrack.Length)
+	}
+}
+
+func TestBuildClickTrackCountInPickup(t *testing.T) {
+	// A one beat pickup before two bars of 4/4 at 120 BPM
+	smfData := smf.NewSMF1()
+	smfData.TimeFormat = smf.MetricTicks(480)
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaTrackSequenceName("Tempo"))
+	conductor.Add(0, smf.MetaTempo(120))
+	conductor.Add(0, smf.MetaTimeSig(1, 4, 24, 8))
+	conductor.Add(480, smf.MetaTimeSig(4, 4, 24, 8))
+	conductor.Add(3840, smf.MetaText("end"))
+	conductor.Close(0)
+	smfData.Add(conductor)
+
+	timeline, err := (&MidiFile{SMF: smfData}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+	if timeline.Measures[0].BeatsPerMeasure != 1 {
+		t.Fatalf("expected a one beat pickup, got %d beats", timeline.Measures[0].BeatsPerMeasure)
+	}
+
+	clickTrack, err := BuildClickTrack(timeline, ClickOptions{Subdivisions: 1, CountIn: 1})
+	if err != nil {
+		t.Fatalf("BuildClickTrack failed: %v", err)
+	}
+
+	// The count-in is a full bar of 4/4, not a bar as long as the pickup
+	if math.Abs(clickTrack.AudioDelay-2) > 1e-6 {
+		t.Errorf("expected the audio to be delayed by 2s, got %.3f", clickTrack.AudioDelay)
+	}
+
+	expected := []Click{
+		{Seconds: 0, Level: ClickAccent},
+		{Seconds: 0.5, Level: ClickBeat},
+		{Seconds: 1, Level: ClickBeat},
+		{Seconds: 1.5, Level: ClickBeat},
+		{Seconds: 2, Level: ClickAccent}, // Pickup
+		{Seconds: 2.5, Level: ClickAccent},
+	}
+	for i, want := range expected {
+		got := clickTrack.Clicks[i]
+		if got.Level != want.Level || math.Abs(got.Seconds-want.Seconds) > 1e-6 {
+			t.Errorf("click %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+}
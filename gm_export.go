@@ -26,8 +26,9 @@ type TrackInfo struct {
 
 // GeneralMidiExporter manages the construction of a General MIDI file
 type GeneralMidiExporter struct {
-	smf    *smf.SMF    // Target MIDI file being built
-	tracks []TrackInfo // Accumulated track information
+	smf         *smf.SMF    // Target MIDI file being built
+	tracks      []TrackInfo // Accumulated track information
+	audioOffset float64     // Seconds of audio before tick 0 of the source
 }
 
 // NewGeneralMidiExporter creates a new MIDI exporter
@@ -54,31 +55,168 @@ func (e *GeneralMidiExporter) SetupTimingTrack(sourceData *smf.SMF) error {
 	return nil
 }
 
+// AddSectionMarkers writes the song sections as marker meta events on the
+// timing track, so sequencers can jump between them. Call it after the timing
+// track has been set up.
+func (e *GeneralMidiExporter) AddSectionMarkers(sections []Section) error {
+	if len(e.smf.Tracks) == 0 {
+		return fmt.Errorf("timing track must be set up before adding section markers")
+	}
+
+	e.smf.Tracks[0] = addSectionMarkers(e.smf.Tracks[0], sections)
+	return nil
+}
+
 // AddTrack adds a track to the exporter's track list
 func (e *GeneralMidiExporter) addTrack(trackInfo TrackInfo) error {
 	e.tracks = append(e.tracks, trackInfo)
 	return nil
 }
 
-// WriteTo finalizes the MIDI file and writes it to the provided writer
+// SetAudioOffset sets how many seconds of audio play before tick 0 of the source.
+// Positive offsets are written as a lead-in with its own tempo so the exported
+// MIDI starts together with the song's audio stems.
+func (e *GeneralMidiExporter) SetAudioOffset(seconds float64) {
+	e.audioOffset = seconds
+}
+
+// WriteTo finalizes the MIDI file and writes it to the provided writer. The
+// exporter is left unchanged, so it can be written more than once.
 func (e *GeneralMidiExporter) WriteTo(writer io.Writer) error {
+	output, err := e.build()
+	if err != nil {
+		return err
+	}
+
+	// Write the complete MIDI file
+	_, err = output.WriteTo(writer)
+	if err != nil {
+		return fmt.Errorf("error writing MIDI file: %w", err)
+	}
+
+	return nil
+}
+
+// build assembles the output MIDI file from the timing track and the
+// accumulated tracks, with every timed event shifted by the lead-in
+func (e *GeneralMidiExporter) build() (*smf.SMF, error) {
 	if len(e.tracks) == 0 {
-		return fmt.Errorf("no tracks to export")
+		return nil, fmt.Errorf("no tracks to export")
+	}
+
+	output := smf.NewSMF1()
+	output.TimeFormat = e.smf.TimeFormat
+
+	leadIn, leadInBPM := e.leadIn()
+	for i, track := range e.smf.Tracks {
+		if leadIn > 0 {
+			track = shiftTrack(track, leadIn, i == 0, leadInBPM)
+		}
+		output.Add(track)
 	}
 
 	// Create MIDI tracks from the accumulated track info
 	for _, trackInfo := range e.tracks {
-		midiTrack := createMidiTrack(trackInfo)
-		e.smf.Add(midiTrack)
+		if leadIn > 0 {
+			events := make([]MidiEvent, len(trackInfo.Events))
+			for i, event := range trackInfo.Events {
+				events[i] = MidiEvent{Time: event.Time + leadIn, Message: event.Message}
+			}
+			trackInfo.Events = events
+		}
+		output.Add(createMidiTrack(trackInfo))
 	}
 
-	// Write the complete MIDI file
-	_, err := e.smf.WriteTo(writer)
+	return output, nil
+}
+
+// RenderWavTo finalizes the MIDI file like WriteTo and renders it to a WAV file
+// with the built-in synthesizer instead of writing MIDI
+func (e *GeneralMidiExporter) RenderWavTo(writer io.Writer, options SynthOptions) error {
+	output, err := e.build()
 	if err != nil {
-		return fmt.Errorf("error writing MIDI file: %w", err)
+		return err
 	}
 
-	return nil
+	return RenderMidiToWav(writer, output, options)
+}
+
+// leadIn returns the ticks of lead-in for the audio offset and the tempo of
+// the lead-in. The lead-in has a tempo of its own, set so its whole number of
+// ticks lasts exactly the audio offset, so the song's tempo map keeps its
+// timing once it is shifted behind it.
+func (e *GeneralMidiExporter) leadIn() (uint32, float64) {
+	if e.audioOffset == 0 {
+		return 0, 0
+	}
+
+	if e.audioOffset < 0 {
+		log.Printf("Warning: negative audio offset %.3fs cannot be applied to MIDI export", e.audioOffset)
+		return 0, 0
+	}
+
+	ticksPerQuarter, ok := e.smf.TimeFormat.(smf.MetricTicks)
+	if !ok {
+		log.Printf("Warning: unsupported time format, ignoring audio offset")
+		return 0, 0
+	}
+
+	// Size the lead-in at the starting tempo so it keeps the feel of the song
+	bpm := 120.0
+	if len(e.smf.Tracks) > 0 {
+		var tick uint32
+		for _, event := range e.smf.Tracks[0] {
+			tick += event.Delta
+			if tick > 0 {
+				break
+			}
+			var tempo float64
+			if event.Message.GetMetaTempo(&tempo) {
+				bpm = tempo
+			}
+		}
+	}
+
+	ticks := uint32(e.audioOffset*bpm/60.0*float64(ticksPerQuarter) + 0.5)
+	if ticks == 0 {
+		return 0, 0
+	}
+	return ticks, float64(ticks) / float64(ticksPerQuarter) * 60.0 / e.audioOffset
+}
+
+// shiftTrack delays every timed event of the track by the given number of
+// ticks. Track names stay at tick 0 since they describe the whole track. The
+// timing track also gets the lead-in tempo at tick 0, with the starting time
+// signature so the lead-in counts in the same meter as the song.
+func shiftTrack(track smf.Track, ticks uint32, timing bool, leadInBPM float64) smf.Track {
+	var names, head, rest smf.Track
+
+	var currentTime, lastTime uint32
+	for _, event := range track {
+		currentTime += event.Delta
+		if currentTime == 0 && event.Message.Is(smf.MetaTrackNameMsg) {
+			names = append(names, smf.Event{Delta: 0, Message: event.Message})
+			continue
+		}
+		if timing && currentTime == 0 && event.Message.Is(smf.MetaTimeSigMsg) && len(head) == 0 {
+			head = append(head, smf.Event{Delta: 0, Message: event.Message})
+		}
+
+		// Rebase the deltas on the events kept in place
+		delta := currentTime - lastTime
+		if len(rest) == 0 {
+			delta = currentTime + ticks
+		}
+		rest = append(rest, smf.Event{Delta: delta, Message: event.Message})
+		lastTime = currentTime
+	}
+
+	shifted := names
+	if timing {
+		shifted = append(shifted, smf.Event{Delta: 0, Message: smf.Message(smf.MetaTempo(leadInBPM))})
+		shifted = append(shifted, head...)
+	}
+	return append(shifted, rest...)
 }
 
 // createMidiTrack builds a complete MIDI track from TrackInfo
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+func TestGeneralMidiExporterLeadIn(t *testing.T) {
+	exporter := NewGeneralMidiExporter()
+	if err := exporter.SetupTimingTrack(createTempoMapMidiFile()); err != nil {
+		t.Fatalf("SetupTimingTrack failed: %v", err)
+	}
+	if err := exporter.AddSectionMarkers([]Section{{Tick: 0, Name: "intro"}, {Tick: 3840, Name: "chorus"}}); err != nil {
+		t.Fatalf("AddSectionMarkers failed: %v", err)
+	}
+	exporter.addTrack(TrackInfo{Name: "Drums", Channel: gmDrumChannel, Events: []MidiEvent{
+		{Time: 0, Message: smf.Message(midi.NoteOn(gmDrumChannel, BassDrum1, 100))},
+		{Time: 120, Message: smf.Message(midi.NoteOff(gmDrumChannel, BassDrum1))},
+		{Time: 3840, Message: smf.Message(midi.NoteOn(gmDrumChannel, BassDrum1, 100))},
+		{Time: 3960, Message: smf.Message(midi.NoteOff(gmDrumChannel, BassDrum1))},
+	}})
+	// Not a whole number of ticks at the starting 120 BPM
+	exporter.SetAudioOffset(0.301)
+
+	var first, second bytes.Buffer
+	if err := exporter.WriteTo(&first); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if err := exporter.WriteTo(&second); err != nil {
+		t.Fatalf("second WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatalf("expected writing twice to give the same file")
+	}
+
+	output, err := smf.ReadFrom(&first)
+	if err != nil {
+		t.Fatalf("failed to read exported MIDI: %v", err)
+	}
+
+	var markers, hits []int64
+	for _, track := range output.Tracks {
+		var tick int64
+		for _, event := range track {
+			tick += int64(event.Delta)
+			var text string
+			var ch, key, vel uint8
+			if event.Message.GetMetaMarker(&text) {
+				markers = append(markers, tick)
+			} else if event.Message.GetNoteOn(&ch, &key, &vel) && vel > 0 {
+				hits = append(hits, tick)
+			}
+		}
+	}
+
+	if len(markers) != 2 || len(hits) != 2 || markers[0] != hits[0] || markers[1] != hits[1] {
+		t.Fatalf("expected the markers to line up with the hits, got markers %v and hits %v", markers, hits)
+	}
+
+	// The song keeps its timing behind the lead-in: the hits at 0s and 4s of the
+	// source play 0.301s later
+	for i, expected := range []int64{301000, 4301000} {
+		if got := output.TimeAt(hits[i]); got < expected-10 || got > expected+10 {
+			t.Errorf("hit %d: expected at %dµs, got %dµs", i, expected, got)
+		}
+	}
+}
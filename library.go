@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// DefaultLibraryIndex is the index file used when the library command is given
+// no path
+const DefaultLibraryIndex = "library.jsonl"
+
+// libraryMidiInstruments maps MIDI track names to instruments. The names match
+// the diff_* difficulty keys of song.ini.
+var libraryMidiInstruments = map[string]string{
+	"PART GUITAR":      "guitar",
+	"PART GUITAR COOP": "guitar_coop",
+	"PART RHYTHM":      "rhythm",
+	"PART BASS":        "bass",
+	"PART DRUMS":       "drums",
+	"PART KEYS":        "keys",
+	"PART VOCALS":      "vocals",
+	"HARM1":            "vocals_harm",
+	"PART REAL_GUITAR": "guitar_real",
+	"PART REAL_BASS":   "bass_real",
+	"PART REAL_BASS_X": "bass_real",
+	"PART REAL_KEYS_X": "keys_real",
+	"PART GUITAR GHL":  "guitarghl",
+	"PART BASS GHL":    "bassghl",
+}
+
+// libraryChartInstruments maps the instrument suffix of chart track sections to
+// instruments, GHL suffixes first so they aren't taken for the plain ones
+var libraryChartInstruments = []struct {
+	suffix     string
+	instrument string
+}{
+	{"GHLGuitar", "guitarghl"},
+	{"GHLBass", "bassghl"},
+	{"GHLRhythm", "rhythmghl"},
+	{"GHLCoop", "guitar_coopghl"},
+	{"Single", "guitar"},
+	{"DoubleGuitar", "guitar_coop"},
+	{"DoubleBass", "bass"},
+	{"DoubleRhythm", "rhythm"},
+	{"Drums", "drums"},
+	{"Keyboard", "keys"},
+}
+
+// LibrarySong is the index entry of a song
+type LibrarySong struct {
+	Path         string            `json:"path"` // Song file or song folder
+	Hash         string            `json:"hash"` // SHA-256 of the song file, or the notes file of a song folder
+	ChartHash    *ChartHash        `json:"chart_hash,omitempty"`
+	Name         string            `json:"name,omitempty"`
+	Artist       string            `json:"artist,omitempty"`
+	Album        string            `json:"album,omitempty"`
+	Genre        string            `json:"genre,omitempty"`
+	Year         string            `json:"year,omitempty"`
+	Charter      string            `json:"charter,omitempty"`
+	Instruments  []string          `json:"instruments"`            // Instruments with notes, sorted
+	Difficulties map[string]int    `json:"difficulties,omitempty"` // Ratings by instrument from the diff_* metadata
+	Duration     float64           `json:"duration"`               // Seconds to the end of the last measure
+	BPM          float64           `json:"bpm"`                    // Tempo played for the longest time
+	MinBPM       float64           `json:"min_bpm"`
+	MaxBPM       float64           `json:"max_bpm"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// HasInstrument reports whether the song has notes for the instrument
+func (s *LibrarySong) HasInstrument(instrument string) bool {
+	for _, name := range s.Instruments {
+		if name == instrument {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a one line summary of the song
+func (s *LibrarySong) String() string {
+	title := s.Name
+	if title == "" {
+		title = filepath.Base(s.Path)
+	}
+	if s.Artist != "" {
+		title = s.Artist + " - " + title
+	}
+
+	var instruments []string
+	for _, instrument := range s.Instruments {
+		if rating, ok := s.Difficulties[instrument]; ok {
+			instrument = fmt.Sprintf("%s(%d)", instrument, rating)
+		}
+		instruments = append(instruments, instrument)
+	}
+
+	minutes := int(s.Duration) / 60
+	seconds := int(s.Duration) % 60
+	return fmt.Sprintf("%s [%d:%02d, %.0f BPM] %s\n  %s", title, minutes, seconds, s.BPM, strings.Join(instruments, " "), s.Path)
+}
+
+// LibraryQuery filters songs of the index. Zero values match every song.
+type LibraryQuery struct {
+	Hash          string  // MD5 or SHA-1 chart hash, such as from a leaderboard export
+	Artist        string  // Case-insensitive substring of the artist
+	Name          string  // Case-insensitive substring of the song name
+	Instrument    string  // Instrument the song must have notes for
+	MinBPM        float64 // Lowest main tempo, zero for no bound
+	MaxBPM        float64 // Highest main tempo, zero for no bound
+	MinDifficulty int     // Lowest rating, -1 for no bound
+	MaxDifficulty int     // Highest rating, -1 for no bound
+}
+
+// Matches reports whether the song passes every filter of the query. The
+// difficulty bounds check the rating of the query instrument, or the band
+// rating without one, and never match unrated songs.
+func (q *LibraryQuery) Matches(song *LibrarySong) bool {
+	if q.Hash != "" && (song.ChartHash == nil || !song.ChartHash.Matches(q.Hash)) {
+		return false
+	}
+	if q.Artist != "" && !strings.Contains(strings.ToLower(song.Artist), strings.ToLower(q.Artist)) {
+		return false
+	}
+	if q.Name != "" && !strings.Contains(strings.ToLower(song.Name), strings.ToLower(q.Name)) {
+		return false
+	}
+	if q.Instrument != "" && !song.HasInstrument(q.Instrument) {
+		return false
+	}
+	if q.MinBPM > 0 && song.BPM < q.MinBPM {
+		return false
+	}
+	if q.MaxBPM > 0 && song.BPM > q.MaxBPM {
+		return false
+	}
+
+	if q.MinDifficulty >= 0 || q.MaxDifficulty >= 0 {
+		instrument := q.Instrument
+		if instrument == "" {
+			instrument = "band"
+		}
+		rating, ok := song.Difficulties[instrument]
+		if !ok {
+			return false
+		}
+		if q.MinDifficulty >= 0 && rating < q.MinDifficulty {
+			return false
+		}
+		if q.MaxDifficulty >= 0 && rating > q.MaxDifficulty {
+			return false
+		}
+	}
+
+	return true
+}
+
+// QueryLibrary returns the songs matching the query, sorted by artist and name
+func QueryLibrary(songs []LibrarySong, query LibraryQuery) []LibrarySong {
+	var matches []LibrarySong
+	for _, song := range songs {
+		if query.Matches(&song) {
+			matches = append(matches, song)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := strings.ToLower(matches[i].Artist), strings.ToLower(matches[j].Artist)
+		if a != b {
+			return a < b
+		}
+		return strings.ToLower(matches[i].Name) < strings.ToLower(matches[j].Name)
+	})
+	return matches
+}
+
+// ScanLibrary indexes every song found under root with FindBatchSongs. Songs
+// that fail to load are left out of the index and listed as failures in the
+// report.
+func ScanLibrary(root string, workers int) ([]LibrarySong, *BatchReport, error) {
+	batchSongs, err := FindBatchSongs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jobs := make([]BatchJob, len(batchSongs))
+	for i, song := range batchSongs {
+		jobs[i] = BatchJob{Song: song}
+	}
+
+	var mu sync.Mutex
+	indexed := make(map[string]*LibrarySong)
+	report := RunBatch(jobs, workers, func(job BatchJob) error {
+		song, err := IndexLibrarySong(job.Song.Input)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		indexed[job.Song.Input] = song
+		mu.Unlock()
+		return nil
+	})
+
+	var songs []LibrarySong
+	for _, song := range batchSongs {
+		if entry, ok := indexed[song.Input]; ok {
+			songs = append(songs, *entry)
+		}
+	}
+	return songs, report, nil
+}
+
+// IndexLibrarySong loads a .sng, .chart or .mid file or a song folder and
+// builds its index entry. Loose .chart and .mid files take their metadata from
+// the song.ini next to them.
+func IndexLibrarySong(path string) (*LibrarySong, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read song: %w", err)
+	}
+
+	entry := &LibrarySong{Path: path}
+	hashPath := path
+
+	var song SongInterface
+	var midiFile *MidiFile
+	var chartFile *ChartFile
+	metadata := make(map[string]string)
+
+	ext := strings.ToLower(filepath.Ext(path))
+	isPackage := info.IsDir() || ext == ".sng"
+	if isPackage {
+		var sngFile *SngFile
+		if info.IsDir() {
+			sngFile, err = OpenSongFolder(path)
+		} else {
+			sngFile, err = OpenSngFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer sngFile.Close()
+
+		if midiFile, chartFile, err = sngFile.loadNotes(); err != nil {
+			return nil, err
+		}
+		if entry.ChartHash, err = sngFile.ChartHash(); err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			// Song folders are identified by their notes file
+			hashPath = filepath.Join(path, entry.ChartHash.File)
+		}
+		song = sngFile
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read song: %w", err)
+		}
+		entry.ChartHash = NewChartHash(filepath.Base(path), data)
+
+		if ext == ".chart" {
+			if chartFile, err = ParseChartFile(bytes.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("error parsing chart file: %w", err)
+			}
+			song = chartFile
+		} else {
+			smfData, err := smf.ReadFrom(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("error reading MIDI file: %w", err)
+			}
+			midiFile = &MidiFile{SMF: smfData}
+			song = midiFile
+		}
+	}
+
+	if entry.Hash, err = hashLibraryFile(hashPath); err != nil {
+		return nil, err
+	}
+
+	for key, value := range song.GetMetadata() {
+		metadata[key] = value
+	}
+	if !isPackage {
+		iniMetadata, err := ReadSiblingSongIni(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range iniMetadata {
+			metadata[key] = value
+		}
+
+		// The song.ini delay applies the same way it does inside SNG packages
+		if delay, ok := songIniDelay(iniMetadata); ok {
+			song.SetAudioOffset(song.GetAudioOffset() + delay)
+		}
+	}
+
+	entry.Metadata = metadata
+	entry.Name = metadata["name"]
+	entry.Artist = metadata["artist"]
+	entry.Album = metadata["album"]
+	entry.Genre = metadata["genre"]
+	entry.Year = metadata["year"]
+	entry.Charter = metadata["charter"]
+	if entry.Charter == "" {
+		entry.Charter = metadata["frets"]
+	}
+
+	for key, value := range metadata {
+		if !strings.HasPrefix(key, "diff_") {
+			continue
+		}
+		if rating, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && rating >= 0 {
+			if entry.Difficulties == nil {
+				entry.Difficulties = make(map[string]int)
+			}
+			entry.Difficulties[strings.TrimPrefix(key, "diff_")] = rating
+		}
+	}
+
+	if midiFile != nil {
+		entry.Instruments = midiInstruments(midiFile.SMF)
+	} else {
+		entry.Instruments = chartInstruments(chartFile)
+	}
+
+	timeline, err := song.GetTimeline()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build timeline: %w", err)
+	}
+	if len(timeline.Measures) > 0 {
+		entry.Duration = timeline.Measures[len(timeline.Measures)-1].EndTimeSeconds
+		entry.BPM, entry.MinBPM, entry.MaxBPM = timelineTempo(timeline)
+	}
+
+	return entry, nil
+}
+
+// hashLibraryFile returns the SHA-256 of the file as hex. The file is streamed
+// through the hash since SNG packages hold all the audio of a song.
+func hashLibraryFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash song: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash song: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// midiInstruments returns the instruments of the MIDI tracks holding notes
+func midiInstruments(smfData *smf.SMF) []string {
+	found := make(map[string]bool)
+	for _, track := range smfData.Tracks {
+		instrument, ok := libraryMidiInstruments[getTrackName(track)]
+		if !ok || found[instrument] {
+			continue
+		}
+		for _, event := range track {
+			var channel, key, velocity uint8
+			if event.Message.GetNoteOn(&channel, &key, &velocity) && velocity > 0 {
+				found[instrument] = true
+				break
+			}
+		}
+	}
+	return sortedKeys(found)
+}
+
+// chartInstruments returns the instruments of the chart tracks holding notes,
+// and vocals when the chart has lyrics
+func chartInstruments(chartFile *ChartFile) []string {
+	found := make(map[string]bool)
+	for section, track := range chartFile.Tracks {
+		if len(track.Notes) == 0 {
+			continue
+		}
+		for _, mapping := range libraryChartInstruments {
+			if strings.HasSuffix(section, mapping.suffix) {
+				found[mapping.instrument] = true
+				break
+			}
+		}
+	}
+	if phrases, err := chartFile.GetVocalPhrases(); err == nil && len(phrases) > 0 {
+		found["vocals"] = true
+	}
+	return sortedKeys(found)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// timelineTempo returns the tempo played for the longest time along with the
+// lowest and highest tempo of the measures
+func timelineTempo(timeline *Timeline) (main, lowest, highest float64) {
+	durations := make(map[float64]float64)
+	for i, measure := range timeline.Measures {
+		bpm := math.Round(measure.BeatsPerMinute*100) / 100
+		durations[bpm] += measure.EndTimeSeconds - measure.StartTimeSeconds
+		if i == 0 || bpm < lowest {
+			lowest = bpm
+		}
+		if i == 0 || bpm > highest {
+			highest = bpm
+		}
+	}
+
+	longest := -1.0
+	for bpm, duration := range durations {
+		if duration > longest || (duration == longest && bpm < main) {
+			main, longest = bpm, duration
+		}
+	}
+	return main, lowest, highest
+}
+
+// WriteLibraryIndex writes the songs as JSON lines, one song per line
+func WriteLibraryIndex(writer io.Writer, songs []LibrarySong) error {
+	encoder := json.NewEncoder(writer)
+	for _, song := range songs {
+		if err := encoder.Encode(song); err != nil {
+			return fmt.Errorf("failed to write library index: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadLibraryIndex reads the songs of an index written by WriteLibraryIndex
+func ReadLibraryIndex(reader io.Reader) ([]LibrarySong, error) {
+	var songs []LibrarySong
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var song LibrarySong
+		if err := json.Unmarshal(scanner.Bytes(), &song); err != nil {
+			return nil, fmt.Errorf("invalid library index entry on line %d: %w", line, err)
+		}
+		songs = append(songs, song)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read library index: %w", err)
+	}
+	return songs, nil
+}
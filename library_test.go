@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexLibrarySong(t *testing.T) {
+	dir := t.TempDir()
+
+	songDir := filepath.Join(dir, "Song A")
+	if err := os.MkdirAll(songDir, 0755); err != nil {
+		t.Fatalf("failed to create song folder: %v", err)
+	}
+	chartPath := filepath.Join(songDir, "notes.chart")
+	if err := os.WriteFile(chartPath, []byte(validChartData), 0644); err != nil {
+		t.Fatalf("failed to write chart: %v", err)
+	}
+	ini := "[song]\nname = Song A\nartist = The Testers\ndiff_drums = 4\ndiff_guitar = -1\n"
+	if err := os.WriteFile(filepath.Join(songDir, "song.ini"), []byte(ini), 0644); err != nil {
+		t.Fatalf("failed to write song.ini: %v", err)
+	}
+
+	entry, err := IndexLibrarySong(chartPath)
+	if err != nil {
+		t.Fatalf("IndexLibrarySong failed: %v", err)
+	}
+	if entry.Name != "Song A" || entry.Artist != "The Testers" {
+		t.Errorf("expected the song.ini metadata over the chart, got %q by %q", entry.Name, entry.Artist)
+	}
+	if !reflect.DeepEqual(entry.Difficulties, map[string]int{"drums": 4}) {
+		t.Errorf("expected only the drums rating, got %v", entry.Difficulties)
+	}
+	if !entry.HasInstrument("drums") || !entry.HasInstrument("guitar") {
+		t.Errorf("expected drums and guitar, got %v", entry.Instruments)
+	}
+	if entry.ChartHash == nil || *entry.ChartHash != *NewChartHash("notes.chart", []byte(validChartData)) {
+		t.Errorf("expected the chart hash of notes.chart, got %+v", entry.ChartHash)
+	}
+	if len(entry.Hash) != 64 {
+		t.Errorf("expected a SHA-256 hash, got %q", entry.Hash)
+	}
+
+	// The song folder indexes the same as its notes file
+	folderEntry, err := IndexLibrarySong(songDir)
+	if err != nil {
+		t.Fatalf("IndexLibrarySong failed on the song folder: %v", err)
+	}
+	if folderEntry.Name != entry.Name || folderEntry.Hash != entry.Hash || !reflect.DeepEqual(folderEntry.Difficulties, entry.Difficulties) {
+		t.Errorf("expected the song folder to match its notes file, got %+v", folderEntry)
+	}
+
+	// Four seconds of 120 BPM and four of 90 BPM, the tie goes to the slower tempo
+	smfData := createTempoMapMidiFile()
+	createDrumSyncTracks(smfData)
+	var midiData bytes.Buffer
+	if _, err := smfData.WriteTo(&midiData); err != nil {
+		t.Fatalf("failed to write MIDI file: %v", err)
+	}
+	midiPath := filepath.Join(dir, "tempo.mid")
+	if err := os.WriteFile(midiPath, midiData.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write MIDI file: %v", err)
+	}
+
+	entry, err = IndexLibrarySong(midiPath)
+	if err != nil {
+		t.Fatalf("IndexLibrarySong failed: %v", err)
+	}
+	if !reflect.DeepEqual(entry.Instruments, []string{"drums"}) {
+		t.Errorf("expected drums only, got %v", entry.Instruments)
+	}
+	if math.Abs(entry.Duration-8) > 0.001 || entry.BPM != 90 || entry.MinBPM != 90 || entry.MaxBPM != 120 {
+		t.Errorf("expected 8s at 90-120 BPM, got %.3fs at %.2f (%.2f-%.2f)", entry.Duration, entry.BPM, entry.MinBPM, entry.MaxBPM)
+	}
+}
+
+func TestQueryLibrary(t *testing.T) {
+	songs := []LibrarySong{
+		{Path: "c.sng", Name: "Fast", Artist: "Zed", ChartHash: NewChartHash("notes.mid", []byte("abc")), Instruments: []string{"drums", "guitar"}, Difficulties: map[string]int{"drums": 5, "band": 4}, BPM: 180},
+		{Path: "a.sng", Name: "Slow", Artist: "abc", Instruments: []string{"bass", "drums"}, Difficulties: map[string]int{"drums": 2}, BPM: 80},
+		{Path: "b.sng", Name: "Medium", Artist: "ABC", Instruments: []string{"guitar"}, BPM: 120},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteLibraryIndex(&buf, songs); err != nil {
+		t.Fatalf("WriteLibraryIndex failed: %v", err)
+	}
+	songs, err := ReadLibraryIndex(&buf)
+	if err != nil {
+		t.Fatalf("ReadLibraryIndex failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    LibraryQuery
+		expected []string
+	}{
+		{"everything", LibraryQuery{MinDifficulty: -1, MaxDifficulty: -1}, []string{"b.sng", "a.sng", "c.sng"}},
+		{"artist", LibraryQuery{Artist: "abc", MinDifficulty: -1, MaxDifficulty: -1}, []string{"b.sng", "a.sng"}},
+		{"instrument", LibraryQuery{Instrument: "drums", MinDifficulty: -1, MaxDifficulty: -1}, []string{"a.sng", "c.sng"}},
+		{"tempo range", LibraryQuery{MinBPM: 100, MaxBPM: 200, MinDifficulty: -1, MaxDifficulty: -1}, []string{"b.sng", "c.sng"}},
+		{"instrument difficulty", LibraryQuery{Instrument: "drums", MinDifficulty: 3, MaxDifficulty: -1}, []string{"c.sng"}},
+		{"chart hash", LibraryQuery{Hash: "a9993e364706816aba3e25717850c26c9cd0d89d", MinDifficulty: -1, MaxDifficulty: -1}, []string{"c.sng"}},
+		{"band difficulty", LibraryQuery{MinDifficulty: -1, MaxDifficulty: 4}, []string{"c.sng"}},
+	}
+
+	for _, test := range tests {
+		var paths []string
+		for _, song := range QueryLibrary(songs, test.query) {
+			paths = append(paths, song.Path)
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, paths)
+		}
+	}
+}
@@ -19,6 +19,9 @@ import (
 // - Non-pitched markers: "All#" or "All^" → "All"
 // - Range dividers: "word%" → "word"
 // - Actual hyphens in lyrics: "Ex= Girl- friend" → "Ex-Girlfriend"
+// - Shared harmony lyrics: "$Hel- $lo" → "Hello"
+// - Syllable joiners (see NormalizeLyrics): "que§a" → "que‿a"
+// - Pronunciation hints (see NormalizeLyrics): "gon[gun]- na" → "gonna"
 //
 // See rockband-format/vocals.md for complete specification.
 func parseRockBandLyrics(rawLyrics []string) string {
@@ -26,45 +29,16 @@ func parseRockBandLyrics(rawLyrics []string) string {
 	var currentWord strings.Builder
 
 	for _, lyric := range rawLyrics {
-		if lyric == "" {
+		cleaned, joinNext, ok := cleanLyricSyllable(lyric)
+		if !ok {
 			continue
 		}
 
-		// Skip if it's just a "+" (syllable continuation marker)
-		if lyric == "+" {
-			continue
-		}
-
-		// Clean up the lyric text
-		cleaned := lyric
-
-		// Remove non-pitched markers (#, ^) and range dividers (%)
-		cleaned = strings.TrimSuffix(cleaned, "#")
-		cleaned = strings.TrimSuffix(cleaned, "^")
-		cleaned = strings.TrimSuffix(cleaned, "%")
-
-		// Handle actual hyphens (= becomes -)
-		cleaned = strings.ReplaceAll(cleaned, "=", "-")
-
-		// Check if this syllable continues with "+"
-		isSlideNote := strings.HasSuffix(cleaned, "+")
-		if isSlideNote {
-			cleaned = strings.TrimSuffix(cleaned, "+")
-			cleaned = strings.TrimSpace(cleaned)
-		}
-
-		// Check if this is a syllable continuation (starts with hyphen after cleaning markers)
-		isSyllableContinuation := strings.HasSuffix(cleaned, "-")
-		if isSyllableContinuation {
-			cleaned = strings.TrimSuffix(cleaned, "-")
-			cleaned = strings.TrimSpace(cleaned)
-		}
-
 		// Add to current word
 		currentWord.WriteString(cleaned)
 
 		// If this syllable doesn't continue to next (no trailing hyphen), complete the word
-		if !isSyllableContinuation && !isSlideNote {
+		if !joinNext {
 			word := currentWord.String()
 			if word != "" {
 				result = append(result, word)
@@ -84,6 +58,61 @@ func parseRockBandLyrics(rawLyrics []string) string {
 	return strings.Join(result, " ")
 }
 
+// cleanLyricSyllable strips the Rock Band formatting from a single raw syllable.
+// joinNext is true when the syllable is joined to the next one to form a word,
+// ok is false for syllables that carry no text at all.
+func cleanLyricSyllable(lyric string) (cleaned string, joinNext bool, ok bool) {
+	if lyric == "" {
+		return "", false, false
+	}
+
+	// Skip if it's just a "+" (syllable continuation marker)
+	if lyric == "+" {
+		return "", false, false
+	}
+
+	// Clean up the lyric text, dropping any pronunciation hint and the harmony
+	// shared lyric marker ($)
+	cleaned, _ = splitLyricPronunciation(lyric)
+	cleaned = strings.TrimPrefix(cleaned, "$")
+
+	// Remove range dividers (%) and non-pitched markers (#, ^)
+	cleaned = strings.TrimSuffix(cleaned, "%")
+	cleaned = strings.TrimSuffix(cleaned, "#")
+	cleaned = strings.TrimSuffix(cleaned, "^")
+
+	// Check if this syllable continues with "+"
+	isSlideNote := strings.HasSuffix(cleaned, "+")
+	if isSlideNote {
+		cleaned = strings.TrimSuffix(cleaned, "+")
+		cleaned = strings.TrimSpace(cleaned)
+	}
+
+	// Check if this is a syllable continuation (starts with hyphen after cleaning markers)
+	isSyllableContinuation := strings.HasSuffix(cleaned, "-")
+	if isSyllableContinuation {
+		cleaned = strings.TrimSuffix(cleaned, "-")
+		cleaned = strings.TrimSpace(cleaned)
+	}
+
+	// A trailing = is an actual hyphen that also joins the next syllable
+	if strings.HasSuffix(cleaned, "=") {
+		isSyllableContinuation = true
+	}
+
+	// Handle actual hyphens (= becomes -)
+	cleaned = strings.ReplaceAll(cleaned, "=", "-")
+
+	// § joins two syllables sung as one, shown as a tie. A trailing one joins the
+	// next syllable.
+	cleaned = strings.ReplaceAll(cleaned, "§", lyricTie)
+	if strings.HasSuffix(cleaned, lyricTie) {
+		isSyllableContinuation = true
+	}
+
+	return cleaned, isSyllableContinuation || isSlideNote, true
+}
+
 // extractLyrics extracts all lyric events from an SMF track and joins them into a single string.
 // It looks for both MetaLyric events and MetaText events (excluding bracketed animation markers),
 // then processes them through parseRockBandLyrics to handle Rock Band vocal formatting.
@@ -108,7 +137,7 @@ func extractLyrics(track smf.Track) string {
 }
 
 // extractChartLyrics extracts all lyric events from a Chart file and joins them into a single string.
-// It looks for GlobalEvents with "lyric " prefix, then processes them through parseRockBandLyrics
+// It looks for lyric GlobalEvents, then processes them through parseRockBandLyrics
 // to handle Rock Band vocal formatting consistently with MIDI lyrics.
 func extractChartLyrics(chart *ChartFile) string {
 	if chart == nil {
@@ -117,16 +146,9 @@ func extractChartLyrics(chart *ChartFile) string {
 
 	var lyrics []string
 
-	for _, event := range chart.Events.GlobalEvents {
-		// Look for events that start with "lyric "
-		if strings.HasPrefix(event.Text, "lyric ") {
-			// Extract the lyric text after "lyric " prefix
-			lyricText := strings.TrimPrefix(event.Text, "lyric ")
-			lyricText = strings.TrimSpace(lyricText)
-
-			if lyricText != "" {
-				lyrics = append(lyrics, lyricText)
-			}
+	for _, event := range chart.GlobalEventsOfType(ChartEventLyric) {
+		if event.Value != "" {
+			lyrics = append(lyrics, event.Value)
 		}
 	}
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Lyric export formats
+const (
+	LyricsFormatLRC  = "lrc"  // Line timed LRC
+	LyricsFormatELRC = "elrc" // Enhanced LRC with word timing
+	LyricsFormatSRT  = "srt"  // SubRip subtitles
+	LyricsFormatVTT  = "vtt"  // WebVTT subtitles with word timing
+)
+
+// WriteLyricsTo writes the vocal phrases as lines of lyrics in the given format
+func WriteLyricsTo(writer io.Writer, phrases []VocalPhrase, format string) error {
+	var sb strings.Builder
+
+	// Phrases without any text, such as vocal percussion, have nothing to show
+	var lines []VocalPhrase
+	for _, phrase := range phrases {
+		if phrase.Text() != "" {
+			lines = append(lines, phrase)
+		}
+	}
+
+	switch format {
+	case LyricsFormatLRC, LyricsFormatELRC:
+		for i, line := range lines {
+			if format == LyricsFormatELRC {
+				sb.WriteString(fmt.Sprintf("[%s]", formatLRCTime(line.StartSeconds)))
+				for _, word := range line.Words() {
+					sb.WriteString(fmt.Sprintf(" <%s> %s", formatLRCTime(word.StartSeconds), word.Text))
+				}
+				sb.WriteString(fmt.Sprintf(" <%s>\n", formatLRCTime(line.EndSeconds)))
+			} else {
+				sb.WriteString(fmt.Sprintf("[%s]%s\n", formatLRCTime(line.StartSeconds), line.Text()))
+			}
+
+			// Clear the display between phrases that don't touch
+			if i+1 >= len(lines) || lines[i+1].StartSeconds > line.EndSeconds {
+				sb.WriteString(fmt.Sprintf("[%s]\n", formatLRCTime(line.EndSeconds)))
+			}
+		}
+	case LyricsFormatSRT:
+		for i, line := range lines {
+			sb.WriteString(fmt.Sprintf("%d\n", i+1))
+			sb.WriteString(fmt.Sprintf("%s --> %s\n", formatSubtitleTime(line.StartSeconds, ","), formatSubtitleTime(line.EndSeconds, ",")))
+			sb.WriteString(line.Text() + "\n\n")
+		}
+	case LyricsFormatVTT:
+		sb.WriteString("WEBVTT\n\n")
+		for _, line := range lines {
+			sb.WriteString(fmt.Sprintf("%s --> %s\n", formatSubtitleTime(line.StartSeconds, "."), formatSubtitleTime(line.EndSeconds, ".")))
+			for i, word := range line.Words() {
+				if i > 0 {
+					sb.WriteString(" ")
+					// Inline timestamps let players highlight words karaoke style
+					if word.StartSeconds > line.StartSeconds && word.StartSeconds < line.EndSeconds {
+						sb.WriteString(fmt.Sprintf("<%s>", formatSubtitleTime(word.StartSeconds, ".")))
+					}
+				}
+				sb.WriteString(word.Text)
+			}
+			sb.WriteString("\n\n")
+		}
+	default:
+		return fmt.Errorf("unknown lyrics format %q (expected lrc, elrc, srt or vtt)", format)
+	}
+
+	_, err := io.WriteString(writer, sb.String())
+	return err
+}
+
+// formatLRCTime formats seconds as mm:ss.xx, clamping negative times to zero
+func formatLRCTime(seconds float64) string {
+	hundredths := int64(math.Round(math.Max(seconds, 0) * 100))
+	return fmt.Sprintf("%02d:%02d.%02d", hundredths/6000, (hundredths/100)%60, hundredths%100)
+}
+
+// formatSubtitleTime formats seconds as hh:mm:ss followed by the millisecond
+// separator and milliseconds, clamping negative times to zero
+func formatSubtitleTime(seconds float64, separator string) string {
+	millis := int64(math.Round(math.Max(seconds, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", millis/3600000, (millis/60000)%60, (millis/1000)%60, separator, millis%1000)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Lyric normalization modes
+const (
+	LyricModeDisplay  = "display"  // Words as shown in game, with hyphens and ties kept
+	LyricModeSearch   = "search"   // Lowercase tokens without punctuation, for search indexes
+	LyricModePhonetic = "phonetic" // Like search, but spelled as sung using pronunciation hints, for TTS
+)
+
+// lyricTie is shown in place of the § syllable joiner
+const lyricTie = "‿"
+
+// NormalizeLyrics joins raw syllables into timed words and normalizes them for
+// the given mode. Slides (+) extend the word they belong to. In the search and
+// phonetic modes hyphenated and tied words are split into separate tokens that
+// share the timing of the whole word.
+//
+// Besides the Rock Band lyric markers, two conventions used by community charts
+// are handled here rather than being part of the format:
+//   - § joins two syllables sung on one note, "que§a" is shown as "que‿a" and
+//     searched as "que" and "a"
+//   - Text in square brackets is a pronunciation hint, "gon[gun]- na" is shown
+//     and searched as "gonna" while the phonetic mode gives "gunna"
+func NormalizeLyrics(syllables []VocalSyllable, mode string) ([]VocalWord, error) {
+	switch mode {
+	case LyricModeDisplay, LyricModeSearch, LyricModePhonetic:
+	default:
+		return nil, fmt.Errorf("unknown lyric mode %q (expected %s, %s or %s)", mode, LyricModeDisplay, LyricModeSearch, LyricModePhonetic)
+	}
+
+	return normalizeLyricWords(syllables, mode), nil
+}
+
+// normalizeLyricWords implements NormalizeLyrics for a known mode
+func normalizeLyricWords(syllables []VocalSyllable, mode string) []VocalWord {
+	var words []VocalWord
+	var currentWord strings.Builder
+	var word VocalWord
+
+	finishWord := func() {
+		word.Text = currentWord.String()
+		words = append(words, splitWordForMode(word, mode)...)
+		currentWord.Reset()
+	}
+
+	for _, syllable := range syllables {
+		cleaned, joinNext, ok := cleanLyricSyllable(syllable.Text)
+		if !ok {
+			// Slides extend the word they belong to
+			if currentWord.Len() > 0 {
+				word.EndTick = syllable.EndTick
+				word.EndSeconds = syllable.EndSeconds
+			} else if len(words) > 0 && syllable.EndSeconds > words[len(words)-1].EndSeconds {
+				// Every token split from the last word shares its timing
+				for i := len(words) - 1; i >= 0 && words[i].StartTick == word.StartTick; i-- {
+					words[i].EndTick = syllable.EndTick
+					words[i].EndSeconds = syllable.EndSeconds
+				}
+			}
+			continue
+		}
+
+		if mode == LyricModePhonetic {
+			if _, pronunciation := splitLyricPronunciation(syllable.Text); pronunciation != "" {
+				cleaned = pronunciation
+			}
+		}
+
+		if currentWord.Len() == 0 {
+			word = VocalWord{
+				StartTick:    syllable.StartTick,
+				StartSeconds: syllable.StartSeconds,
+				Spoken:       true,
+			}
+		}
+		currentWord.WriteString(cleaned)
+		word.EndTick = syllable.EndTick
+		word.EndSeconds = syllable.EndSeconds
+		if syllable.Pitched {
+			word.Spoken = false
+		}
+
+		if !joinNext && currentWord.Len() > 0 {
+			finishWord()
+		}
+	}
+
+	if currentWord.Len() > 0 {
+		finishWord()
+	}
+
+	return words
+}
+
+// splitWordForMode returns the word as it should appear in the given mode. Search
+// and phonetic words are lowercased and split on anything that isn't a letter,
+// digit or apostrophe.
+func splitWordForMode(word VocalWord, mode string) []VocalWord {
+	if mode == LyricModeDisplay {
+		if word.Text == "" {
+			return nil
+		}
+		return []VocalWord{word}
+	}
+
+	text := strings.ToLower(strings.NewReplacer("’", "'", "‘", "'").Replace(word.Text))
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	var words []VocalWord
+	for _, token := range tokens {
+		// Apostrophes belong to elisions like 'cause or singin', but not on their own
+		if strings.Trim(token, "'") == "" {
+			continue
+		}
+
+		split := word
+		split.Text = token
+		words = append(words, split)
+	}
+	return words
+}
+
+// splitLyricPronunciation separates a bracketed pronunciation hint from a raw
+// lyric, "gon[gun]-" gives "gon-" and "gun". The hint is empty when there is none.
+func splitLyricPronunciation(lyric string) (text string, pronunciation string) {
+	start := strings.Index(lyric, "[")
+	if start < 0 {
+		return lyric, ""
+	}
+
+	end := strings.Index(lyric[start:], "]")
+	if end < 0 {
+		return lyric, ""
+	}
+	end += start
+
+	return lyric[:start] + lyric[end+1:], strings.TrimSpace(lyric[start+1 : end])
+}
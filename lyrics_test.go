@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+const lyricsChart = `[Song]
+{
+  Resolution = 192
+  Offset = 0
+}
+[SyncTrack]
+{
+  0 = TS 4
+  0 = B 120000
+}
+[Events]
+{
+  0 = E "phrase_start"
+  0 = E "lyric Hel-"
+  96 = E "lyric lo"
+  192 = E "lyric world"
+  384 = E "phrase_end"
+  768 = E "phrase_start"
+  768 = E "lyric Ex="
+  864 = E "lyric Girl-"
+  960 = E "lyric friend#"
+  1152 = E "phrase_end"
+}
+[ExpertSingle]
+{
+  0 = N 0 0
+}
+`
+
+func TestChartVocalPhrases(t *testing.T) {
+	chart, err := ParseChartFile(strings.NewReader(lyricsChart))
+	if err != nil {
+		t.Fatalf("Failed to parse chart: %v", err)
+	}
+
+	lines, err := chart.GetVocalPhrases()
+	if err != nil {
+		t.Fatalf("GetVocalPhrases failed: %v", err)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+
+	if lines[0].Text() != "Hello world" || lines[1].Text() != "Ex-Girlfriend" {
+		t.Errorf("unexpected line text: %q, %q", lines[0].Text(), lines[1].Text())
+	}
+
+	// 192 ticks per quarter at 120 BPM is 0.5 seconds per quarter
+	if lines[0].StartSeconds != 0 || lines[0].EndSeconds != 1.0 || lines[1].StartSeconds != 2.0 || lines[1].EndSeconds != 3.0 {
+		t.Errorf("unexpected line timing: %+v", lines)
+	}
+	words := lines[0].Words()
+	if len(words) != 2 || words[1].StartSeconds != 0.5 || words[1].EndSeconds != 1.0 {
+		t.Errorf("expected second word from 0.5s to 1.0s, got %+v", words)
+	}
+	if lines[1].Syllables[2].Pitched {
+		t.Errorf("expected syllable marked with # to be non-pitched")
+	}
+
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{LyricsFormatLRC, "[00:00.00]Hello world\n[00:01.00]\n[00:02.00]Ex-Girlfriend\n[00:03.00]\n"},
+		{LyricsFormatELRC, "[00:00.00] <00:00.00> Hello <00:00.50> world <00:01.00>\n[00:01.00]\n"},
+		{LyricsFormatSRT, "1\n00:00:00,000 --> 00:00:01,000\nHello world\n\n2\n"},
+		{LyricsFormatVTT, "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello <00:00:00.500>world\n\n"},
+	}
+
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		if err := WriteLyricsTo(&buf, lines, tc.format); err != nil {
+			t.Fatalf("WriteLyricsTo(%s) failed: %v", tc.format, err)
+		}
+		if !strings.HasPrefix(buf.String(), tc.expected) {
+			t.Errorf("%s output mismatch:\nexpected prefix:\n%s\ngot:\n%s", tc.format, tc.expected, buf.String())
+		}
+	}
+
+	if err := WriteLyricsTo(&bytes.Buffer{}, lines, "txt"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestBuildVocalPhrases_WithoutPhraseMarkers(t *testing.T) {
+	tempoMap := &TempoMap{TicksPerQuarter: 480}
+	tempoMap.normalize()
+
+	syllables := []VocalSyllable{
+		{StartTick: 0, EndTick: 240, Text: "one"},
+		{StartTick: 480, EndTick: 720, Text: "two"},
+		{StartTick: 4800, EndTick: 5040, Text: "three"},
+	}
+
+	phrases := buildVocalPhrases(syllables, nil, tempoMap)
+	if len(phrases) != 2 {
+		t.Fatalf("expected syllables split on the gap into 2 phrases, got %d", len(phrases))
+	}
+	if phrases[0].Text() != "one two" || phrases[1].Text() != "three" {
+		t.Errorf("unexpected phrase text: %q, %q", phrases[0].Text(), phrases[1].Text())
+	}
+	if phrases[0].EndTick != 720 {
+		t.Errorf("expected first phrase to end with its last syllable at 720, got %d", phrases[0].EndTick)
+	}
+}
+
+func TestMidiVocalPhrases(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+
+	var vocals smf.Track
+	vocals.Add(0, smf.MetaTrackSequenceName(VocalPartLead))
+	vocals.Add(0, midi.NoteOn(0, vocalPhraseNote, 100))
+	vocals.Add(0, smf.MetaLyric("Hel-"))
+	vocals.Add(0, midi.NoteOn(0, 60, 100))
+	vocals.Add(240, midi.NoteOff(0, 60))
+	vocals.Add(240, smf.MetaLyric("lo#"))
+	vocals.Add(0, midi.NoteOn(0, 62, 100))
+	vocals.Add(240, midi.NoteOff(0, 62))
+	vocals.Add(240, midi.NoteOff(0, vocalPhraseNote))
+	vocals.Add(960, midi.NoteOn(0, vocalPhraseNote, 100))
+	vocals.Add(0, smf.MetaLyric("again"))
+	vocals.Add(0, midi.NoteOn(0, 64, 100))
+	vocals.Add(480, midi.NoteOff(0, 64))
+	vocals.Add(0, midi.NoteOff(0, vocalPhraseNote))
+	vocals.Close(0)
+	smfData.Add(vocals)
+
+	song := &MidiFile{SMF: smfData}
+	phrases, err := song.GetVocalPhrases()
+	if err != nil {
+		t.Fatalf("GetVocalPhrases failed: %v", err)
+	}
+
+	if len(phrases) != 2 {
+		t.Fatalf("expected 2 phrases, got %d", len(phrases))
+	}
+	if phrases[0].Text() != "Hello" || phrases[1].Text() != "again" {
+		t.Errorf("unexpected phrase text: %q, %q", phrases[0].Text(), phrases[1].Text())
+	}
+	if phrases[0].StartTick != 0 || phrases[0].EndTick != 960 || phrases[1].StartTick != 1920 {
+		t.Errorf("unexpected phrase ticks: %+v", phrases)
+	}
+
+	first := phrases[0].Syllables
+	if len(first) != 2 || first[0].Pitch != 60 || !first[0].Pitched || first[1].Pitched {
+		t.Errorf("unexpected syllables: %+v", first)
+	}
+	if first[1].StartTick != 480 || first[1].EndTick != 720 || first[1].EndSeconds != 0.75 {
+		t.Errorf("unexpected syllable timing: %+v", first[1])
+	}
+}
+
+// addVocalTrack adds a vocal track with one note per lyric, each a quarter note
+// long and starting on consecutive beats. Phrase markers cover the given beats.
+func addVocalTrack(smfData *smf.SMF, name string, lyrics []string, phraseBeats [][2]uint32) {
+	type timedMessage struct {
+		time uint32
+		msg  smf.Message
+	}
+
+	var events []timedMessage
+	for _, phrase := range phraseBeats {
+		events = append(events,
+			timedMessage{phrase[0] * 480, smf.Message(midi.NoteOn(0, vocalPhraseNote, 100))},
+			timedMessage{phrase[1] * 480, smf.Message(midi.NoteOff(0, vocalPhraseNote))},
+		)
+	}
+	for i, lyric := range lyrics {
+		start := uint32(i) * 480
+		events = append(events,
+			timedMessage{start, smf.Message(smf.MetaLyric(lyric))},
+			timedMessage{start, smf.Message(midi.NoteOn(0, 60, 100))},
+			timedMessage{start + 240, smf.Message(midi.NoteOff(0, 60))},
+		)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time < events[j].time
+	})
+
+	var track smf.Track
+	track.Add(0, smf.MetaTrackSequenceName(name))
+	var lastTime uint32
+	for _, event := range events {
+		track.Add(event.time-lastTime, event.msg)
+		lastTime = event.time
+	}
+	track.Close(0)
+	smfData.Add(track)
+}
+
+func TestMidiVocalParts_Harmonies(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+	addVocalTrack(smfData, VocalPartHarm1, []string{"Hel-", "lo", "there", "friend"}, [][2]uint32{{0, 2}, {2, 4}})
+	addVocalTrack(smfData, VocalPartHarm2, []string{"$Hel-", "$lo", "there", "pal"}, [][2]uint32{{0, 4}})
+	addVocalTrack(smfData, VocalPartHarm3, []string{"$Hel-", "$lo", "oh", "yeah"}, nil)
+
+	parts, err := (&MidiFile{SMF: smfData}).GetVocalParts()
+	if err != nil {
+		t.Fatalf("GetVocalParts failed: %v", err)
+	}
+
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 harmony parts, got %d", len(parts))
+	}
+
+	expected := []struct {
+		name    string
+		phrases []string
+	}{
+		{VocalPartHarm1, []string{"Hello", "there friend"}},
+		{VocalPartHarm2, []string{"Hello there pal"}},
+		{VocalPartHarm3, []string{"Hello oh yeah"}}, // Inherits the HARM2 phrase markers
+	}
+
+	for i, want := range expected {
+		part := FindVocalPart(parts, want.name)
+		if part == nil {
+			t.Fatalf("missing part %s", want.name)
+		}
+		if parts[i].Name != want.name {
+			t.Errorf("expected part %d to be %s, got %s", i, want.name, parts[i].Name)
+		}
+		if len(part.Phrases) != len(want.phrases) {
+			t.Errorf("%s: expected %d phrases, got %d", want.name, len(want.phrases), len(part.Phrases))
+			continue
+		}
+		for j, text := range want.phrases {
+			if part.Phrases[j].Text() != text {
+				t.Errorf("%s phrase %d: expected %q, got %q", want.name, j, text, part.Phrases[j].Text())
+			}
+		}
+	}
+
+	harm2 := FindVocalPart(parts, VocalPartHarm2)
+	if !harm2.Phrases[0].Syllables[0].Shared || harm2.Phrases[0].Syllables[2].Shared {
+		t.Errorf("expected only $ syllables to be shared: %+v", harm2.Phrases[0].Syllables)
+	}
+
+	if FindVocalPart(parts, VocalPartLead) != nil {
+		t.Errorf("expected no lead part without PART VOCALS")
+	}
+}
+
+func TestExtractVocalNotes_Types(t *testing.T) {
+	var vocals smf.Track
+	vocals.Add(0, smf.MetaTrackSequenceName(VocalPartLead))
+	vocals.Add(0, smf.MetaText("[cowbell_start]"))
+	vocals.Add(0, smf.MetaLyric("Hey"))
+	vocals.Add(0, midi.NoteOn(0, 60, 100))
+	vocals.Add(0, midi.NoteOn(0, vocalPercussionNote, 100))
+	vocals.Add(120, midi.NoteOff(0, vocalPercussionNote))
+	vocals.Add(120, midi.NoteOff(0, 60))
+	vocals.Add(0, smf.MetaLyric("ho#"))
+	vocals.Add(0, midi.NoteOn(0, 62, 100))
+	vocals.Add(240, midi.NoteOff(0, 62))
+	vocals.Add(0, midi.NoteOn(0, vocalPercussionHiddenNote, 100))
+	vocals.Add(60, midi.NoteOff(0, vocalPercussionHiddenNote))
+	vocals.Close(0)
+
+	notes := extractVocalNotes(vocals)
+
+	expected := []struct {
+		key   uint8
+		lyric string
+		kind  string
+	}{
+		{60, "Hey", VocalNotePitched},
+		{vocalPercussionNote, "", VocalNotePercussion},
+		{62, "ho#", VocalNoteNonPitched},
+		{vocalPercussionHiddenNote, "", VocalNotePercussionHidden},
+	}
+
+	if len(notes) != len(expected) {
+		t.Fatalf("expected %d notes, got %d: %+v", len(expected), len(notes), notes)
+	}
+	for i, want := range expected {
+		if notes[i].Key != want.key || notes[i].Lyric != want.lyric || notes[i].Type != want.kind {
+			t.Errorf("note %d: expected %+v, got %+v", i, want, notes[i])
+		}
+	}
+
+	if kind := vocalPercussionKindAt(vocalPercussionSections(vocals), 0); kind != "cowbell" {
+		t.Errorf("expected cowbell percussion, got %q", kind)
+	}
+
+	smfData := smf.New()
+	smfData.TimeFormat = smf.MetricTicks(480)
+	smfData.Add(vocals)
+
+	exporter := NewGeneralMidiExporter()
+	if err := exporter.AddVocalTracks(smfData); err != nil {
+		t.Fatalf("AddVocalTracks failed: %v", err)
+	}
+
+	if len(exporter.tracks) != 2 || exporter.tracks[1].Name != "Vocal Percussion" {
+		t.Fatalf("expected lead vocals and percussion tracks, got %+v", exporter.tracks)
+	}
+
+	var hits []uint8
+	for _, event := range exporter.tracks[1].Events {
+		var ch, key, vel uint8
+		if event.Message.GetNoteOn(&ch, &key, &vel) {
+			if ch != gmDrumChannel {
+				t.Errorf("expected percussion on channel %d, got %d", gmDrumChannel, ch)
+			}
+			hits = append(hits, key)
+		}
+	}
+	// The hidden percussion sample is not a playable hit
+	if len(hits) != 1 || hits[0] != Cowbell {
+		t.Errorf("expected one cowbell hit, got %v", hits)
+	}
+}
+
+func TestAddVocalTracks_PercussionSections(t *testing.T) {
+	addHit := func(track *smf.Track, delta uint32) {
+		track.Add(delta, midi.NoteOn(0, vocalPercussionNote, 100))
+		track.Add(60, midi.NoteOff(0, vocalPercussionNote))
+	}
+
+	// Tambourine hits at 0 and 480, cowbell hits at 1920 and 2400
+	var lead smf.Track
+	lead.Add(0, smf.MetaTrackSequenceName(VocalPartLead))
+	lead.Add(0, smf.MetaText("[tambourine_start]"))
+	addHit(&lead, 0)
+	addHit(&lead, 420)
+	lead.Add(1380, smf.MetaText("[cowbell_start]"))
+	addHit(&lead, 0)
+	addHit(&lead, 420)
+	lead.Close(0)
+
+	// HARM1 repeats the lead's hits, HARM2 adds a clap at 960
+	var harm1 smf.Track
+	harm1.Add(0, smf.MetaTrackSequenceName(VocalPartHarm1))
+	harm1.Add(0, smf.MetaText("[tambourine_start]"))
+	addHit(&harm1, 0)
+	harm1.Close(0)
+
+	var harm2 smf.Track
+	harm2.Add(0, smf.MetaTrackSequenceName(VocalPartHarm2))
+	harm2.Add(960, smf.MetaText("[clap_start]"))
+	addHit(&harm2, 0)
+	harm2.Close(0)
+
+	smfData := smf.New()
+	smfData.TimeFormat = smf.MetricTicks(480)
+	smfData.Add(lead)
+	smfData.Add(harm1)
+	smfData.Add(harm2)
+
+	exporter := NewGeneralMidiExporter()
+	if err := exporter.AddVocalTracks(smfData); err != nil {
+		t.Fatalf("AddVocalTracks failed: %v", err)
+	}
+
+	if len(exporter.tracks) != 1 || exporter.tracks[0].Name != "Vocal Percussion" {
+		t.Fatalf("expected a single percussion track, got %+v", exporter.tracks)
+	}
+
+	type hit struct {
+		time uint32
+		key  uint8
+	}
+	var hits []hit
+	for _, event := range exporter.tracks[0].Events {
+		var ch, key, vel uint8
+		if event.Message.GetNoteOn(&ch, &key, &vel) && vel > 0 {
+			hits = append(hits, hit{event.Time, key})
+		}
+	}
+
+	expected := []hit{{0, Tambourine}, {480, Tambourine}, {960, HandClap}, {1920, Cowbell}, {2400, Cowbell}}
+	if len(hits) != len(expected) {
+		t.Fatalf("expected hits %v, got %v", expected, hits)
+	}
+	for i := range expected {
+		if hits[i] != expected[i] {
+			t.Errorf("hit %d: expected %v, got %v", i, expected[i], hits[i])
+		}
+	}
+}
+
+func TestParseRockBandLyrics(t *testing.T) {
+	testCases := []struct {
+		raw      []string
+		expected string
+	}{
+		{[]string{"Hel-", "lo"}, "Hello"},
+		{[]string{"Thun-", "der-", "+", "struck"}, "Thunderstruck"},
+		{[]string{"Yeah", "+"}, "Yeah"},
+		{[]string{"All#", "right!^"}, "All right!"},
+		{[]string{"in-#", "de-#", "fa-#", "ti-#", "ga-#", "bly#"}, "indefatigably"},
+		{[]string{"end%"}, "end"},
+		// A trailing = is a hyphen that is displayed and still joins the next
+		// syllable, the documented Ex-Girlfriend example. Earlier versions
+		// dropped the hyphen and printed ExGirlfriend.
+		{[]string{"Ex=", "Girl-", "friend"}, "Ex-Girlfriend"},
+		{[]string{"$Hel-", "$lo"}, "Hello"},
+	}
+
+	for _, tc := range testCases {
+		if got := parseRockBandLyrics(tc.raw); got != tc.expected {
+			t.Errorf("parseRockBandLyrics(%q) = %q, expected %q", tc.raw, got, tc.expected)
+		}
+	}
+}
+
+func TestNormalizeLyrics(t *testing.T) {
+	raw := []string{"Ex=", "Girl-", "friend", "+", "gon[gun]-", "na", "que§a", "All#", "right!#%", "$Don’t"}
+	syllables := make([]VocalSyllable, len(raw))
+	for i, text := range raw {
+		syllables[i] = VocalSyllable{
+			StartTick:    uint32(i) * 100,
+			EndTick:      uint32(i)*100 + 50,
+			StartSeconds: float64(i),
+			EndSeconds:   float64(i) + 0.5,
+			Text:         text,
+			Pitched:      !isNonPitchedLyric(text),
+		}
+	}
+
+	testCases := []struct {
+		mode     string
+		expected []string
+	}{
+		{LyricModeDisplay, []string{"Ex-Girlfriend", "gonna", "que‿a", "All", "right!", "Don’t"}},
+		{LyricModeSearch, []string{"ex", "girlfriend", "gonna", "que", "a", "all", "right", "don't"}},
+		{LyricModePhonetic, []string{"ex", "girlfriend", "gunna", "que", "a", "all", "right", "don't"}},
+	}
+
+	for _, tc := range testCases {
+		words, err := NormalizeLyrics(syllables, tc.mode)
+		if err != nil {
+			t.Fatalf("NormalizeLyrics(%s) failed: %v", tc.mode, err)
+		}
+
+		var texts []string
+		for _, word := range words {
+			texts = append(texts, word.Text)
+		}
+		if strings.Join(texts, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%s: expected %q, got %q", tc.mode, tc.expected, texts)
+		}
+
+		// The slide extends the end of the hyphenated word and all its tokens
+		if words[0].StartTick != 0 || words[0].EndTick != 350 || (tc.mode != LyricModeDisplay && words[1].EndSeconds != 3.5) {
+			t.Errorf("%s: unexpected timing for the first word: %+v, %+v", tc.mode, words[0], words[1])
+		}
+	}
+
+	words, _ := NormalizeLyrics(syllables, LyricModeDisplay)
+	if words[0].Spoken || !words[3].Spoken || !words[4].Spoken {
+		t.Errorf("expected only the # words to be spoken: %+v", words)
+	}
+
+	if _, err := NormalizeLyrics(syllables, "karaoke"); err == nil {
+		t.Errorf("expected error for unknown mode")
+	}
+}
@@ -7,35 +7,128 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 
 	"gitlab.com/gomidi/midi/v2/smf"
 )
 
+// songOptions are the command line options of a run on a single song
+type songOptions struct {
+	jsonOutput        bool
+	exportGmDrums     bool
+	exportGmVocals    bool
+	exportGmBass      bool
+	exportGm          bool
+	printTimeline     bool
+	quantizeTempo     bool
+	quantizeWindow    int
+	quantizeMethod    string
+	quantizePenalty   float64
+	checkBeat         bool
+	exportPreview     bool
+	previewLength     float64
+	checkSync         bool
+	exportLyrics      string
+	lyricsPart        string
+	exportToneLib     bool
+	createToneLibSong bool
+	filterTrack       string
+	extractFile       string
+	audioOffset       float64
+	audioOffsetSet    bool // audioOffset overrides the song's offset
+	exportAudio       string
+	audioStems        string
+	splitStems        bool
+	audioBitrate      string
+	loudnessTarget    float64
+	measureLoudness   bool
+	stemGain          string
+	ffmpegPath        string
+	renderWav         bool
+	renderClick       bool
+	exportClick       bool
+	clickSubdivisions int
+	countIn           int
+	sliceFrom         string
+	sliceTo           string
+}
+
 func main() {
-	jsonOutput := flag.Bool("json", false, "Output information as JSON (supported with: default analysis, --timeline)")
-	exportGmDrums := flag.Bool("export-gm-drums", false, "Export drum patterns to General MIDI file")
-	exportGmVocals := flag.Bool("export-gm-vocals", false, "Export vocal melody to General MIDI file")
-	exportGmBass := flag.Bool("export-gm-bass", false, "Export pro bass to General MIDI file")
-	exportGm := flag.Bool("export-gm", false, "Export drums, vocals, and bass to single General MIDI file")
-	printTimeline := flag.Bool("timeline", false, "Print beat timeline from BEAT track")
-	exportToneLib := flag.Bool("export-tonelib-xml", false, "Export to ToneLib the_song.dat XML format")
-	createToneLibSong := flag.Bool("export-tonelib-song", false, "Create complete ToneLib .song file (ZIP archive)")
-	filterTrack := flag.String("filter-track", "", "Filter to show only tracks whose name contains this string (case-insensitive)")
-	extractFile := flag.String("extract-file", "", "Extract and print contents of specified file from SNG package to stdout")
+	if len(os.Args) > 1 && os.Args[1] == "library" {
+		runLibraryCommand(os.Args[2:])
+		return
+	}
+
+	options := &songOptions{}
+	flag.BoolVar(&options.jsonOutput, "json", false, "Output information as JSON (supported with: default analysis, --timeline, --check-beat, --check-sync, --quantize-tempo)")
+	flag.BoolVar(&options.exportGmDrums, "export-gm-drums", false, "Export drum patterns to General MIDI file")
+	flag.BoolVar(&options.exportGmVocals, "export-gm-vocals", false, "Export vocal melody to General MIDI file")
+	flag.BoolVar(&options.exportGmBass, "export-gm-bass", false, "Export pro bass to General MIDI file")
+	flag.BoolVar(&options.exportGm, "export-gm", false, "Export drums, vocals, and bass to single General MIDI file")
+	flag.BoolVar(&options.printTimeline, "timeline", false, "Print beat timeline from BEAT track (or tempo map when there is none)")
+	flag.BoolVar(&options.quantizeTempo, "quantize-tempo", false, "Print timeline quantized to integer BPMs with a drift report against the original")
+	flag.IntVar(&options.quantizeWindow, "quantize-window", DefaultQuantizeWindow, "BPM search window either side of the rounded BPM for --quantize-tempo")
+	flag.StringVar(&options.quantizeMethod, "quantize-method", QuantizeMethodGlobal, "BPM search for --quantize-tempo: global (whole song) or greedy (measure by measure)")
+	flag.Float64Var(&options.quantizePenalty, "quantize-penalty", DefaultBPMChangePenalty, "Cost in seconds of drift each BPM change adds in the global BPM search")
+	flag.BoolVar(&options.checkBeat, "check-beat", false, "Check BEAT track against the tempo map, writes a repaired MIDI file if output is given")
+	flag.BoolVar(&options.exportPreview, "export-preview", false, "Export a faded preview clip with its MIDI and LRC lyrics from preview_start_time (or --from/--to), output is the base name")
+	flag.Float64Var(&options.previewLength, "preview-length", DefaultPreviewLength, "Seconds of --export-preview when the song sets no preview end")
+	flag.BoolVar(&options.checkSync, "check-sync", false, "Check the audio offset of an SNG package by matching drum notes to onsets in the drum stem (or --stems)")
+	flag.StringVar(&options.exportLyrics, "export-lyrics", "", "Export synchronized lyrics in the given format: lrc, elrc (word timing), srt or vtt")
+	flag.StringVar(&options.lyricsPart, "lyrics-part", "lead", "Vocal part for --export-lyrics: lead, harm1, harm2 or harm3")
+	flag.BoolVar(&options.exportToneLib, "export-tonelib-xml", false, "Export to ToneLib the_song.dat XML format")
+	flag.BoolVar(&options.createToneLibSong, "export-tonelib-song", false, "Create complete ToneLib .song file (ZIP archive)")
+	flag.StringVar(&options.filterTrack, "filter-track", "", "Filter to show only tracks whose name contains this string (case-insensitive)")
+	flag.StringVar(&options.extractFile, "extract-file", "", "Extract and print contents of specified file from SNG package to stdout")
+	flag.Float64Var(&options.audioOffset, "audio-offset", 0, "Override the audio offset in seconds (default: chart Offset plus song.ini delay, from the SNG package or the song folder)")
+	flag.StringVar(&options.exportAudio, "export-audio", "", "Export audio from SNG package in the given format: ogg, wav, flac or mp3")
+	flag.StringVar(&options.audioStems, "stems", "", "Stems for --export-audio, comma separated (such as guitar,bass), prefix with - to exclude (such as -drums)")
+	flag.BoolVar(&options.splitStems, "split-stems", false, "Write each stem of --export-audio to its own file, output is a directory")
+	flag.StringVar(&options.audioBitrate, "audio-bitrate", "", "Bitrate for --export-audio, such as 192k (default: variable bitrate for lossy formats)")
+	flag.Float64Var(&options.loudnessTarget, "loudness-target", 0, fmt.Sprintf("Integrated loudness in LUFS to normalize merged audio to (--export-audio and ToneLib songs), such as %g for streaming, 0 keeps the original level unless the merged stems would clip", StreamingLoudnessTarget))
+	flag.BoolVar(&options.measureLoudness, "loudness", false, "Measure the loudness of every audio stem with ffmpeg when printing SNG package info")
+	flag.StringVar(&options.stemGain, "stem-gain", "", "Gain in dB for stems when merging audio, comma separated (such as drums=-3,vocals=2)")
+	flag.StringVar(&options.ffmpegPath, "ffmpeg", "ffmpeg", "Path to the ffmpeg binary used for audio processing")
+	flag.BoolVar(&options.renderWav, "render-wav", false, "Render the --export-gm* output to a WAV file with the built-in synthesizer instead of writing MIDI")
+	flag.BoolVar(&options.renderClick, "render-click", false, "Mix a click on the timeline beats into --render-wav")
+	flag.BoolVar(&options.exportClick, "export-click", false, "Export a metronome following the timeline beats as MIDI and WAV files (output is the base name)")
+	flag.IntVar(&options.clickSubdivisions, "click-subdivisions", 1, "Clicks per beat for --export-click, such as 2 for eighth notes")
+	flag.IntVar(&options.countIn, "count-in", 0, "Measures of count-in before the first measure for --export-click")
+	batch := flag.Bool("batch", false, "Run the chosen mode on every .sng, .chart, .mid and song folder under the input directory, output is the directory for the mirrored results")
+	batchJobs := flag.Int("jobs", 0, "Songs processed at once in --batch mode (default: number of CPUs)")
+	flag.StringVar(&options.sliceFrom, "from", "", "Start exports at this measure number, section name or time in seconds (such as 45.5s)")
+	flag.StringVar(&options.sliceTo, "to", "", "End exports after this measure number, section name or time in seconds (such as 90s)")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <file> [output]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <file or song folder> [output]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s library <scan|query> [flags] ...\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	filename := flag.Arg(0)
+	options.audioOffsetSet = isFlagSet("audio-offset")
+
+	if *batch {
+		runBatchMode(options, flag.Arg(0), flag.Arg(1), *batchJobs)
+		return
+	}
+
+	if err := runSong(options, flag.Arg(0), flag.Arg(1), os.Stdout); err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
 
+// runSong runs the mode chosen by the options on a song file or song folder.
+// Exports are written to outputFile, or the mode's default when it is empty,
+// and printed results go to stdout.
+func runSong(options *songOptions, filename string, outputFile string, stdout io.Writer) error {
 	var song SongInterface
 	var sngFile *SngFile     // Keep for SNG-specific operations
 	var midiFile *smf.SMF    // Keep for MIDI-specific operations
@@ -43,14 +136,35 @@ func main() {
 	var err error
 
 	ext := strings.ToLower(filepath.Ext(filename))
+	info, statErr := os.Stat(filename)
+	isFolder := statErr == nil && info.IsDir()
 
-	if ext == ".sng" {
-		sngFile, err = OpenSngFile(filename)
-		if err != nil {
-			log.Printf("Error opening SNG file: %v\n", err)
-			os.Exit(1)
+	if ext == ".sng" || isFolder {
+		// Song folders hold the same files as a package and open as one
+		if isFolder {
+			sngFile, err = OpenSongFolder(filename)
+			if err != nil {
+				return fmt.Errorf("failed to open song folder: %w", err)
+			}
+		} else {
+			sngFile, err = OpenSngFile(filename)
+			if err != nil {
+				return fmt.Errorf("failed to open SNG file: %w", err)
+			}
 		}
 		defer sngFile.Close()
+		sngFile.SetAudioProcessor(NewFFmpegProcessor(options.ffmpegPath))
+
+		stemGains, err := ParseStemGains(options.stemGain)
+		if err != nil {
+			return err
+		}
+		for name := range stemGains {
+			if _, err := SelectAudioStems(sngFile.AudioStems(), name); err != nil {
+				return fmt.Errorf("invalid --stem-gain: %w", err)
+			}
+		}
+		sngFile.SetMixOptions(AudioMixOptions{LoudnessTarget: options.loudnessTarget, StemGains: stemGains})
 		song = sngFile
 
 		// Also try to load individual files for legacy operations
@@ -78,98 +192,133 @@ func main() {
 	} else if ext == ".chart" {
 		chartFile, err = OpenChartFile(filename)
 		if err != nil {
-			log.Printf("Error opening chart file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to open chart file: %w", err)
 		}
 		song = chartFile
 	} else {
 		// treat the file as a regular midi file
 		file, err := os.Open(filename)
 		if err != nil {
-			log.Printf("Error opening file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to open file: %w", err)
 		}
 		defer file.Close()
 
 		midiFile, err = smf.ReadFrom(file)
 		if err != nil {
-			log.Printf("Error reading MIDI file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to read MIDI file: %w", err)
 		}
 		song = &MidiFile{SMF: midiFile}
 	}
 
-	if *exportGmDrums || *exportGmVocals || *exportGmBass || *exportGm {
+	if sngFile == nil {
+		// Loose files in a song folder take the delay of its song.ini, like SNG
+		// packages do
+		iniMetadata, err := ReadSiblingSongIni(filename)
+		if err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+		if delay, ok := songIniDelay(iniMetadata); ok {
+			song.SetAudioOffset(song.GetAudioOffset() + delay)
+		}
+	}
+
+	if options.audioOffsetSet {
+		song.SetAudioOffset(options.audioOffset)
+	}
+
+	if options.sliceFrom != "" || options.sliceTo != "" {
+		timeline, err := song.GetTimeline()
+		if err != nil {
+			return fmt.Errorf("failed to get timeline for slice: %w", err)
+		}
+
+		slice, err := ResolveSongSlice(timeline, options.sliceFrom, options.sliceTo)
+		if err != nil {
+			return err
+		}
+		log.Printf("Slicing measures %d-%d (%.3fs to %.3fs)", slice.StartMeasure, slice.EndMeasure, slice.StartSeconds, slice.EndSeconds)
+
+		song, midiFile, chartFile, err = sliceSong(song, midiFile, chartFile, slice)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.exportGmDrums || options.exportGmVocals || options.exportGmBass || options.exportGm {
 		if midiFile == nil && chartFile == nil {
-			log.Printf("No MIDI or Chart data available for export\n")
-			os.Exit(1)
+			return fmt.Errorf("no MIDI or Chart data available for export")
 		}
-		outputFile := flag.Arg(1)
 		if outputFile == "" {
-			if *exportGmDrums {
+			if options.exportGmDrums {
 				outputFile = "gm_drums.mid"
-			} else if *exportGmVocals {
+			} else if options.exportGmVocals {
 				outputFile = "gm_vocals.mid"
-			} else if *exportGmBass {
+			} else if options.exportGmBass {
 				outputFile = "gm_bass.mid"
-			} else if *exportGm {
+			} else if options.exportGm {
 				outputFile = "gm_complete.mid"
 			}
+			if options.renderWav {
+				outputFile = strings.TrimSuffix(outputFile, ".mid") + ".wav"
+			}
 		}
 
 		file, err := os.Create(outputFile)
 		if err != nil {
-			log.Printf("Error creating output file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to create output file: %w", err)
 		}
 		defer file.Close()
 
 		exporter := NewGeneralMidiExporter()
+		exporter.SetAudioOffset(song.GetAudioOffset())
 
 		// Setup timing track from available source
 		if midiFile != nil {
 			err = exporter.SetupTimingTrack(midiFile)
 			if err != nil {
-				log.Printf("Error setting up timing track from MIDI: %v\n", err)
-				os.Exit(1)
+				return fmt.Errorf("failed to set up timing track from MIDI: %w", err)
 			}
 		} else if chartFile != nil {
 			err = exporter.SetupTimingTrackFromChart(chartFile)
 			if err != nil {
-				log.Printf("Error setting up timing track from Chart: %v\n", err)
-				os.Exit(1)
+				return fmt.Errorf("failed to set up timing track from Chart: %w", err)
+			}
+		}
+
+		// Section names become markers for navigating the exported file
+		if timeline, err := song.GetTimeline(); err == nil {
+			err = exporter.AddSectionMarkers(timeline.Sections)
+			if err != nil {
+				log.Printf("Warning: %v", err)
 			}
 		}
 
-		if *exportGmDrums || *exportGm {
+		if options.exportGmDrums || options.exportGm {
 			if midiFile != nil {
 				err = exporter.AddDrumTracks(midiFile)
 				if err != nil {
-					log.Printf("Error adding drum tracks from MIDI: %v\n", err)
-					os.Exit(1)
+					return fmt.Errorf("failed to add drum tracks from MIDI: %w", err)
 				}
 			} else if chartFile != nil {
 				err = exporter.AddChartDrumTracks(chartFile)
 				if err != nil {
-					log.Printf("Error adding drum tracks from Chart: %v\n", err)
-					os.Exit(1)
+					return fmt.Errorf("failed to add drum tracks from Chart: %w", err)
 				}
 			}
 		}
 
-		if *exportGmVocals || *exportGm {
+		if options.exportGmVocals || options.exportGm {
 			if midiFile != nil {
 				err = exporter.AddVocalTracks(midiFile)
 				if err != nil {
-					log.Printf("Error adding vocal tracks: %v\n", err)
-					os.Exit(1)
+					return fmt.Errorf("failed to add vocal tracks: %w", err)
 				}
 			} else {
 				log.Printf("Warning: Vocal export not supported for Chart files (Chart files contain no melodic data)")
 			}
 		}
 
-		if *exportGmBass || *exportGm {
+		if options.exportGmBass || options.exportGm {
 			if midiFile != nil {
 				err = exporter.AddBassTracks(midiFile)
 				if err != nil {
@@ -180,108 +329,615 @@ func main() {
 			}
 		}
 
-		err = exporter.WriteTo(file)
-		if err != nil {
-			log.Printf("Error writing MIDI file: %v\n", err)
-			os.Exit(1)
+		if options.renderWav {
+			var synthOptions SynthOptions
+			if options.renderClick {
+				// Beat times are positions in the audio, as is the exported MIDI
+				// once the audio offset lead-in is added
+				timeline, err := song.GetTimeline()
+				if err != nil {
+					return fmt.Errorf("failed to extract timeline for click: %w", err)
+				}
+				synthOptions.Click = timeline
+			}
+
+			err = exporter.RenderWavTo(file, synthOptions)
+			if err != nil {
+				return fmt.Errorf("failed to render WAV file: %w", err)
+			}
+		} else {
+			err = exporter.WriteTo(file)
+			if err != nil {
+				return fmt.Errorf("failed to write MIDI file: %w", err)
+			}
 		}
 
 		var exportType string
-		if *exportGmDrums && !*exportGmVocals && !*exportGmBass {
+		if options.exportGmDrums && !options.exportGmVocals && !options.exportGmBass {
 			exportType = "GM Drums"
-		} else if *exportGmVocals && !*exportGmDrums && !*exportGmBass {
+		} else if options.exportGmVocals && !options.exportGmDrums && !options.exportGmBass {
 			exportType = "GM Vocals"
-		} else if *exportGmBass && !*exportGmDrums && !*exportGmVocals {
+		} else if options.exportGmBass && !options.exportGmDrums && !options.exportGmVocals {
 			exportType = "GM Bass"
 		} else {
 			exportType = "Complete GM"
 		}
 
-		fmt.Printf("%s exported to: %s\n", exportType, outputFile)
-	} else if *printTimeline {
+		fmt.Fprintf(stdout, "%s exported to: %s\n", exportType, outputFile)
+		return nil
+	} else if options.printTimeline {
 		timeline, err := song.GetTimeline()
 		if err != nil {
-			log.Printf("Error extracting timeline: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to extract timeline: %w", err)
 		}
 
-		if *jsonOutput {
+		if options.jsonOutput {
 			jsonData, err := json.MarshalIndent(timeline, "", "  ")
 			if err != nil {
-				log.Printf("Error marshaling timeline to JSON: %v\n", err)
-				os.Exit(1)
+				return fmt.Errorf("failed to marshal timeline to JSON: %w", err)
 			}
-			fmt.Println(string(jsonData))
+			fmt.Fprintln(stdout, string(jsonData))
 		} else {
-			fmt.Printf("Timeline for: %s\n", filename)
-			fmt.Print(timeline.String())
+			fmt.Fprintf(stdout, "Timeline for: %s\n", filename)
+			fmt.Fprint(stdout, timeline.String())
+		}
+		return nil
+	} else if options.quantizeTempo {
+		if options.quantizeWindow < 0 {
+			return fmt.Errorf("quantize window must not be negative")
+		}
+		if options.quantizePenalty < 0 {
+			return fmt.Errorf("quantize penalty must not be negative")
+		}
+		return printQuantizedTimeline(stdout, song, filename, options.quantizeMethod, options.quantizeWindow, options.quantizePenalty, options.jsonOutput)
+	} else if options.checkBeat {
+		if midiFile == nil {
+			return fmt.Errorf("BEAT track check requires MIDI data")
+		}
+		return checkBeatTrack(stdout, &MidiFile{SMF: midiFile, audioOffset: song.GetAudioOffset()}, filename, outputFile, options.jsonOutput)
+	} else if options.exportLyrics != "" {
+		partName, err := vocalPartForOption(options.lyricsPart)
+		if err != nil {
+			return err
 		}
-	} else if *exportToneLib {
-		exportToToneLib(song, filename)
-	} else if *createToneLibSong {
-		outputFile := flag.Arg(1)
+
+		parts, err := song.GetVocalParts()
+		if err != nil {
+			return fmt.Errorf("failed to extract lyrics: %w", err)
+		}
+
+		part := FindVocalPart(parts, partName)
+		if part == nil {
+			return fmt.Errorf("no %s vocal part found", partName)
+		}
+		return exportLyricsFile(stdout, part.Phrases, options.exportLyrics, outputFile)
+	} else if options.exportToneLib {
+		return exportToToneLib(stdout, song, outputFile)
+	} else if options.createToneLibSong {
 		if outputFile == "" {
 			outputFile = "output.song"
 		}
-		createToneLibSongFile(song, outputFile)
-	} else if *extractFile != "" {
+		return createToneLibSongFile(stdout, song, outputFile)
+	} else if options.exportClick {
+		return exportClickTrack(stdout, song, ClickOptions{Subdivisions: options.clickSubdivisions, CountIn: options.countIn}, outputFile)
+	} else if options.exportPreview {
+		if options.sliceFrom == "" && options.sliceTo == "" {
+			song, midiFile, chartFile, err = slicePreview(song, sngFile, midiFile, chartFile, options.previewLength)
+			if err != nil {
+				return err
+			}
+		}
+		return exportSongPreview(stdout, song, sngFile, midiFile, chartFile, outputFile, options.audioBitrate, options.audioStems, options.lyricsPart)
+	} else if options.checkSync {
 		if sngFile == nil {
-			log.Printf("File extraction only supported for SNG files\n")
-			os.Exit(1)
+			return fmt.Errorf("sync check only supported for SNG files and song folders")
+		}
+		return checkAudioSync(stdout, sngFile, options.audioStems, options.sliceFrom != "" || options.sliceTo != "", options.jsonOutput)
+	} else if options.exportAudio != "" {
+		if sngFile == nil {
+			return fmt.Errorf("audio export only supported for SNG files and song folders")
+		}
+		return exportSngAudio(stdout, sngFile, options.exportAudio, outputFile, options.audioBitrate, options.audioStems, options.splitStems)
+	} else if options.extractFile != "" {
+		if sngFile == nil {
+			return fmt.Errorf("file extraction only supported for SNG files and song folders")
+		}
+		return extractFileFromSng(stdout, sngFile, options.extractFile, outputFile)
+	}
+
+	// SNG packages show the hash of their notes file with the package info
+	var chartHash *ChartHash
+	if sngFile != nil {
+		if err := printSngFile(stdout, sngFile, options.measureLoudness, options.jsonOutput); err != nil {
+			return err
+		}
+
+		if options.jsonOutput {
+			return nil
 		}
-		extractFileFromSng(sngFile, *extractFile)
 	} else {
-		if sngFile != nil {
-			printSngFile(sngFile, *jsonOutput)
+		chartHash, err = ChartHashFile(filename)
+		if err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+	}
 
-			if *jsonOutput {
-				return
-			}
+	if chartFile != nil {
+		return printChartInfo(stdout, chartFile, chartHash, options.jsonOutput, options.filterTrack)
+	}
+
+	if midiFile == nil {
+		return fmt.Errorf("no valid chart or MIDI data found in file: %s", filename)
+	}
+
+	return printMidiInfo(stdout, midiFile, filename, chartHash, options.jsonOutput, options.filterTrack)
+}
+
+// sliceSong cuts the song and its MIDI and chart data down to the slice. The
+// chart is dropped with a warning when only the MIDI data covers the slice.
+func sliceSong(song SongInterface, midiFile *smf.SMF, chartFile *ChartFile, slice *SongSlice) (SongInterface, *smf.SMF, *ChartFile, error) {
+	if midiFile != nil {
+		var err error
+		midiFile, err = SliceMidi(midiFile, slice)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to slice MIDI data: %w", err)
 		}
+	}
 
-		if chartFile != nil {
-			printChartInfo(chartFile, *jsonOutput, *filterTrack)
-			return
+	if chartFile != nil {
+		slicedChart, err := SliceChart(chartFile, slice)
+		if err != nil && midiFile == nil {
+			return nil, nil, nil, fmt.Errorf("failed to slice chart data: %w", err)
+		} else if err != nil {
+			// The song timeline comes from the MIDI file, the chart may be shorter
+			log.Printf("Warning: ignoring chart data that can't be sliced: %v\n", err)
 		}
+		chartFile = slicedChart
+	}
 
-		if midiFile == nil {
-			log.Printf("No valid chart or MIDI data found in file: %s\n", filename)
+	switch s := song.(type) {
+	case *SngFile:
+		s.SetSlice(slice)
+	case *ChartFile:
+		song = chartFile
+	case *MidiFile:
+		song = &MidiFile{SMF: midiFile, audioOffset: slice.AudioOffset()}
+	}
+
+	return song, midiFile, chartFile, nil
+}
+
+// slicePreview cuts the song down to its preview range
+func slicePreview(song SongInterface, sngFile *SngFile, midiFile *smf.SMF, chartFile *ChartFile, length float64) (SongInterface, *smf.SMF, *ChartFile, error) {
+	var preview *PreviewRange
+	if sngFile != nil {
+		preview, _ = sngFile.GetPreviewRange()
+	} else if chartFile != nil {
+		preview, _ = chartFile.GetPreviewRange()
+	}
+
+	timeline, err := song.GetTimeline()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get timeline for preview: %w", err)
+	}
+
+	slice, preview, err := ResolvePreviewSlice(timeline, preview, length)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	log.Printf("Preview from %s at %.3fs covers measures %d-%d (%.3fs to %.3fs)", preview.Source, preview.Start, slice.StartMeasure, slice.EndMeasure, slice.StartSeconds, slice.EndSeconds)
+
+	return sliceSong(song, midiFile, chartFile, slice)
+}
+
+// exportSongPreview writes the preview clip of the sliced song as <base>.ogg,
+// its notes as <base>.mid and its lyrics as <base>.lrc. The clip is only
+// written for SNG packages and the lyrics only when the slice has any.
+func exportSongPreview(stdout io.Writer, song SongInterface, sngFile *SngFile, midiFile *smf.SMF, chartFile *ChartFile, base string, bitrate string, selection string, lyricsPart string) error {
+	if base == "" {
+		base = "preview"
+	}
+
+	if sngFile != nil {
+		encoding := AudioFormatEncodings[AudioFormatOgg]
+		if bitrate != "" {
+			encoding.Bitrate = bitrate
+		}
+
+		stems, err := SelectAudioStems(sngFile.AudioStems(), selection)
+		if err != nil {
+			return err
+		}
+
+		outputFile := base + "." + AudioFormatOgg
+		if err := sngFile.ExportPreview(stems, encoding, outputFile); err != nil {
+			return fmt.Errorf("failed to export preview audio: %w", err)
+		}
+		fmt.Fprintf(stdout, "Preview audio exported to: %s\n", outputFile)
+	}
+
+	midiOutput := base + ".mid"
+	if midiFile != nil {
+		if err := midiFile.WriteFile(midiOutput); err != nil {
+			return fmt.Errorf("failed to write MIDI file: %w", err)
+		}
+		fmt.Fprintf(stdout, "Preview MIDI exported to: %s\n", midiOutput)
+	} else if chartFile != nil {
+		// Charts have no MIDI of their own, export their drums as General MIDI
+		exporter := NewGeneralMidiExporter()
+		exporter.SetAudioOffset(song.GetAudioOffset())
+		if err := exporter.SetupTimingTrackFromChart(chartFile); err != nil {
+			return fmt.Errorf("failed to set up timing track from Chart: %w", err)
+		}
+		if err := exporter.AddChartDrumTracks(chartFile); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+
+		file, err := os.Create(midiOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		if err := exporter.WriteTo(file); err != nil {
+			return fmt.Errorf("failed to write MIDI file: %w", err)
+		}
+		fmt.Fprintf(stdout, "Preview MIDI exported to: %s\n", midiOutput)
+	}
+
+	partName, err := vocalPartForOption(lyricsPart)
+	if err != nil {
+		return err
+	}
+	parts, err := song.GetVocalParts()
+	if err != nil {
+		log.Printf("Warning: no lyrics for the preview: %v\n", err)
+		return nil
+	}
+	part := FindVocalPart(parts, partName)
+	if part == nil || len(part.Phrases) == 0 {
+		return nil
+	}
+
+	lyricsOutput := base + "." + LyricsFormatLRC
+	file, err := os.Create(lyricsOutput)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+	if err := WriteLyricsTo(file, part.Phrases, LyricsFormatLRC); err != nil {
+		return fmt.Errorf("failed to export lyrics: %w", err)
+	}
+	fmt.Fprintf(stdout, "Preview lyrics exported to: %s\n", lyricsOutput)
+	return nil
+}
+
+// batchOutput is how a batch job passes on the output of a mode
+type batchOutput struct {
+	suffix string // Appended to the mirrored song path
+	stdout bool   // The mode prints its results, they are saved to the output path
+}
+
+// batchOutputFor picks the output of the mode chosen by the options, checked in
+// the same order runSong dispatches them
+func batchOutputFor(options *songOptions) batchOutput {
+	printed := batchOutput{suffix: ".txt", stdout: true}
+	if options.jsonOutput {
+		printed.suffix = ".json"
+	}
+
+	switch {
+	case options.exportGmDrums || options.exportGmVocals || options.exportGmBass || options.exportGm:
+		if options.renderWav {
+			return batchOutput{suffix: ".wav"}
+		}
+		return batchOutput{suffix: ".mid"}
+	case options.printTimeline, options.quantizeTempo, options.checkBeat:
+		return printed
+	case options.exportLyrics != "":
+		format := strings.ToLower(options.exportLyrics)
+		if format == LyricsFormatELRC {
+			format = LyricsFormatLRC
+		}
+		return batchOutput{suffix: "." + format}
+	case options.exportToneLib:
+		return batchOutput{suffix: ".dat"}
+	case options.createToneLibSong:
+		return batchOutput{suffix: ".song"}
+	case options.exportClick, options.exportPreview:
+		// Base names, the mode adds the extensions
+		return batchOutput{}
+	case options.checkSync:
+		return printed
+	case options.exportAudio != "":
+		if options.splitStems {
+			return batchOutput{}
+		}
+		return batchOutput{suffix: "." + strings.ToLower(options.exportAudio)}
+	case options.extractFile != "":
+		return batchOutput{suffix: "_" + filepath.Base(options.extractFile)}
+	}
+	return printed
+}
+
+// runBatchMode runs the mode chosen by the options on every song under
+// inputDir, writing each output to the same relative path under outputDir. A
+// failing song is reported and the others carry on. The report is printed and
+// saved as batch_report.json in outputDir.
+func runBatchMode(options *songOptions, inputDir string, outputDir string, jobs int) {
+	if info, err := os.Stat(inputDir); err != nil || !info.IsDir() {
+		log.Printf("Batch input must be a directory: %s\n", inputDir)
+		os.Exit(1)
+	}
+	if outputDir == "" {
+		outputDir = "batch_output"
+	}
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	songs, err := FindBatchSongs(inputDir)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	log.Printf("Found %d songs in %s", len(songs), inputDir)
+
+	output := batchOutputFor(options)
+	var batchJobs []BatchJob
+	for _, song := range songs {
+		batchJobs = append(batchJobs, BatchJob{Song: song, Output: filepath.Join(outputDir, song.Name+output.suffix)})
+	}
+
+	report := RunBatch(batchJobs, jobs, func(job BatchJob) error {
+		return runBatchJob(options, job, output)
+	})
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		log.Printf("Error creating output directory: %v\n", err)
+		os.Exit(1)
+	}
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling batch report to JSON: %v\n", err)
+		os.Exit(1)
+	}
+	reportFile := filepath.Join(outputDir, "batch_report.json")
+	if err := os.WriteFile(reportFile, jsonData, 0644); err != nil {
+		log.Printf("Error writing batch report: %v\n", err)
+		os.Exit(1)
+	}
+
+	if options.jsonOutput {
+		fmt.Println(string(jsonData))
+	} else {
+		fmt.Print(report.String())
+		fmt.Printf("Report written to: %s\n", reportFile)
+	}
+
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runLibraryCommand handles the library subcommand: scan builds a JSON lines
+// index of the songs under a directory, query searches it
+func runLibraryCommand(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s library scan [flags] <directory> [index]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s library query [flags] [index]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "The index defaults to %s\n", DefaultLibraryIndex)
+	}
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "scan":
+		flags := flag.NewFlagSet("library scan", flag.ExitOnError)
+		jobs := flags.Int("jobs", 0, "Songs indexed at once (default: number of CPUs)")
+		flags.Parse(args[1:])
+
+		if flags.NArg() < 1 {
+			usage()
+			flags.PrintDefaults()
+			os.Exit(1)
+		}
+		inputDir := flags.Arg(0)
+		indexFile := flags.Arg(1)
+		if indexFile == "" {
+			indexFile = DefaultLibraryIndex
+		}
+		if info, err := os.Stat(inputDir); err != nil || !info.IsDir() {
+			log.Printf("Library input must be a directory: %s\n", inputDir)
+			os.Exit(1)
+		}
+		if *jobs <= 0 {
+			*jobs = runtime.NumCPU()
+		}
+
+		songs, report, err := ScanLibrary(inputDir, *jobs)
+		if err != nil {
+			log.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
 
-		printMidiInfo(midiFile, filename, *jsonOutput, *filterTrack)
+		file, err := os.Create(indexFile)
+		if err != nil {
+			log.Printf("Error creating library index: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		if err := WriteLibraryIndex(file, songs); err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Print(report.String())
+		fmt.Printf("Indexed %d songs to: %s\n", len(songs), indexFile)
+	case "query":
+		flags := flag.NewFlagSet("library query", flag.ExitOnError)
+		hash := flags.String("hash", "", "Songs with this MD5 (Clone Hero) or SHA-1 (YARG) chart hash")
+		artist := flags.String("artist", "", "Songs whose artist contains this string (case-insensitive)")
+		name := flags.String("name", "", "Songs whose name contains this string (case-insensitive)")
+		instrument := flags.String("instrument", "", "Songs with notes for this instrument, such as drums, bass_real or vocals_harm")
+		minBPM := flags.Float64("min-bpm", 0, "Lowest main tempo")
+		maxBPM := flags.Float64("max-bpm", 0, "Highest main tempo")
+		minDifficulty := flags.Int("min-difficulty", -1, "Lowest difficulty rating of --instrument (or the band rating without one)")
+		maxDifficulty := flags.Int("max-difficulty", -1, "Highest difficulty rating of --instrument (or the band rating without one)")
+		jsonOutput := flags.Bool("json", false, "Output matching songs as JSON")
+		flags.Parse(args[1:])
+
+		indexFile := flags.Arg(0)
+		if indexFile == "" {
+			indexFile = DefaultLibraryIndex
+		}
+		file, err := os.Open(indexFile)
+		if err != nil {
+			log.Printf("Error opening library index: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		songs, err := ReadLibraryIndex(file)
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		matches := QueryLibrary(songs, LibraryQuery{
+			Hash:          *hash,
+			Artist:        *artist,
+			Name:          *name,
+			Instrument:    strings.ToLower(*instrument),
+			MinBPM:        *minBPM,
+			MaxBPM:        *maxBPM,
+			MinDifficulty: *minDifficulty,
+			MaxDifficulty: *maxDifficulty,
+		})
+
+		if *jsonOutput {
+			if matches == nil {
+				matches = []LibrarySong{}
+			}
+			jsonData, err := json.MarshalIndent(matches, "", "  ")
+			if err != nil {
+				log.Printf("Error marshaling songs to JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+		} else {
+			for _, song := range matches {
+				fmt.Println(song.String())
+			}
+			fmt.Printf("%d of %d songs\n", len(matches), len(songs))
+		}
+	default:
+		usage()
+		os.Exit(1)
 	}
 }
 
-func printMidiInfo(smfData *smf.SMF, filename string, jsonOutput bool, filterTrack string) {
+// runBatchJob runs the mode on a single song. Printed results are saved to the
+// output path and removed again when the song fails. A panic fails the song
+// instead of the whole batch.
+func runBatchJob(options *songOptions, job BatchJob, output batchOutput) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(job.Output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	if !output.stdout {
+		return runSong(options, job.Song.Input, job.Output, io.Discard)
+	}
+
+	file, err := os.Create(job.Output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	err = runSong(options, job.Song.Input, "", file)
+	file.Close()
+	if err != nil {
+		// Don't leave partial results behind
+		os.Remove(job.Output)
+	}
+	return err
+}
+
+// isFlagSet reports whether the named flag was passed on the command line
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// midiInfoJSON is the JSON output of the MIDI info, the SMF with the chart hash
+// after its keys
+type midiInfoJSON struct {
+	*smf.SMF
+	ChartHash *ChartHash
+}
+
+// MarshalJSON keeps the SMF's own encoding and appends the chart hash to it
+func (info midiInfoJSON) MarshalJSON() ([]byte, error) {
+	data, err := info.SMF.MarshalJSON()
+	if err != nil || info.ChartHash == nil {
+		return data, err
+	}
+
+	hash, err := json.Marshal(info.ChartHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The SMF encodes as an object with at least its format, the hash goes
+	// before the closing brace
+	data = append(data[:len(data)-1], `,"chart_hash":`...)
+	data = append(data, hash...)
+	return append(data, '}'), nil
+}
+
+func printMidiInfo(stdout io.Writer, smfData *smf.SMF, filename string, chartHash *ChartHash, jsonOutput bool, filterTrack string) error {
 	if smfData == nil {
 		if jsonOutput {
-			fmt.Println("null")
+			fmt.Fprintln(stdout, "null")
 		} else {
-			fmt.Printf("No MIDI data available\n")
+			fmt.Fprintf(stdout, "No MIDI data available\n")
 		}
-		return
+		return nil
 	}
 
 	if jsonOutput {
-		jsonData, err := json.MarshalIndent(smfData, "", "  ")
+		jsonData, err := json.MarshalIndent(midiInfoJSON{smfData, chartHash}, "", "  ")
 		if err != nil {
-			log.Printf("Error marshaling to JSON: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to marshal to JSON: %w", err)
 		}
-		fmt.Println(string(jsonData))
-		return
+		fmt.Fprintln(stdout, string(jsonData))
+		return nil
 	}
 
-	fmt.Printf("MIDI File: %s\n", filename)
-	fmt.Printf("Format: %d\n", smfData.Format())
+	fmt.Fprintf(stdout, "MIDI File: %s\n", filename)
+	if chartHash != nil {
+		fmt.Fprintf(stdout, "Chart hash MD5: %s\n", chartHash.MD5)
+		fmt.Fprintf(stdout, "Chart hash SHA-1: %s\n", chartHash.SHA1)
+	}
+	fmt.Fprintf(stdout, "Format: %d\n", smfData.Format())
 	if tf, ok := smfData.TimeFormat.(smf.MetricTicks); ok {
-		fmt.Printf("Ticks per quarter note: %d\n", tf)
+		fmt.Fprintf(stdout, "Ticks per quarter note: %d\n", tf)
 	} else {
-		fmt.Printf("Time format: %v\n", smfData.TimeFormat)
+		fmt.Fprintf(stdout, "Time format: %v\n", smfData.TimeFormat)
 	}
-	fmt.Printf("Number of tracks: %d\n", len(smfData.Tracks))
-	fmt.Println()
+	fmt.Fprintf(stdout, "Number of tracks: %d\n", len(smfData.Tracks))
+	fmt.Fprintln(stdout)
 
 	for i, track := range smfData.Tracks {
 		trackName := getTrackName(track)
@@ -294,14 +950,14 @@ func printMidiInfo(smfData *smf.SMF, filename string, jsonOutput bool, filterTra
 		}
 
 		if trackName != "" {
-			fmt.Printf("Track %d: %s\n", i, trackName)
+			fmt.Fprintf(stdout, "Track %d: %s\n", i, trackName)
 		} else {
-			fmt.Printf("Track %d:\n", i)
+			fmt.Fprintf(stdout, "Track %d:\n", i)
 		}
-		fmt.Printf("  Number of events: %d\n", len(track))
+		fmt.Fprintf(stdout, "  Number of events: %d\n", len(track))
 
 		if len(track) == 0 {
-			fmt.Println("  (empty track)")
+			fmt.Fprintln(stdout, "  (empty track)")
 			continue
 		}
 
@@ -352,51 +1008,52 @@ func printMidiInfo(smfData *smf.SMF, filename string, jsonOutput bool, filterTra
 			durationMs = float64(duration) / float64(tf) * 500 // Assuming 120 BPM
 		}
 
-		fmt.Printf("  Duration: %d ticks (%.2f seconds @ 120 BPM)\n", duration, durationMs/1000)
+		fmt.Fprintf(stdout, "  Duration: %d ticks (%.2f seconds @ 120 BPM)\n", duration, durationMs/1000)
 
 		// Print event counts by type
 		if len(eventCounts) > 0 {
-			fmt.Println("  Event counts by type:")
+			fmt.Fprintln(stdout, "  Event counts by type:")
 			for eventType, count := range eventCounts {
-				fmt.Printf("    %s: %d\n", eventType, count)
+				fmt.Fprintf(stdout, "    %s: %d\n", eventType, count)
 			}
 		}
 		cleanLyrics := extractLyrics(track)
 		if cleanLyrics != "" {
-			fmt.Printf("  Lyrics: %s\n", cleanLyrics)
+			fmt.Fprintf(stdout, "  Lyrics: %s\n", cleanLyrics)
 		}
 
 		if len(channels) > 0 {
-			fmt.Printf("  Channels used: ")
+			fmt.Fprintf(stdout, "  Channels used: ")
 			first := true
 			for ch := range channels {
 				if !first {
-					fmt.Printf(", ")
+					fmt.Fprintf(stdout, ", ")
 				}
-				fmt.Printf("%d", ch)
+				fmt.Fprintf(stdout, "%d", ch)
 				first = false
 			}
-			fmt.Println()
+			fmt.Fprintln(stdout)
 		}
 
 		if len(instruments) > 0 {
-			fmt.Println("  Instruments:")
+			fmt.Fprintln(stdout, "  Instruments:")
 			for ch, inst := range instruments {
-				fmt.Printf("    Channel %d: %s\n", ch, inst)
+				fmt.Fprintf(stdout, "    Channel %d: %s\n", ch, inst)
 			}
 		}
 
 		// If filtering is active, show detailed event information
 		if filterTrack != "" {
-			fmt.Println("  Detailed Events:")
-			printTrackEvents(track)
+			fmt.Fprintln(stdout, "  Detailed Events:")
+			printTrackEvents(stdout, track)
 		}
 
-		fmt.Println()
+		fmt.Fprintln(stdout)
 	}
+	return nil
 }
 
-func printTrackEvents(track smf.Track) {
+func printTrackEvents(stdout io.Writer, track smf.Track) {
 	var currentTime uint32 = 0
 
 	for eventIndex, event := range track {
@@ -404,49 +1061,49 @@ func printTrackEvents(track smf.Track) {
 		msg := event.Message
 		msgType := msg.Type()
 
-		fmt.Printf("    [%d] Tick: %d, Event: %s", eventIndex, currentTime, msgType.String())
+		fmt.Fprintf(stdout, "    [%d] Tick: %d, Event: %s", eventIndex, currentTime, msgType.String())
 
 		// Extract specific event data for common event types
 		var ch, key, vel uint8
 		var pitchValue int16
 
 		if msg.GetNoteOn(&ch, &key, &vel) {
-			fmt.Printf("(ch=%d, key=%d, vel=%d)", ch, key, vel)
+			fmt.Fprintf(stdout, "(ch=%d, key=%d, vel=%d)", ch, key, vel)
 		} else if msg.GetNoteOff(&ch, &key, &vel) {
-			fmt.Printf("(ch=%d, key=%d, vel=%d)", ch, key, vel)
+			fmt.Fprintf(stdout, "(ch=%d, key=%d, vel=%d)", ch, key, vel)
 		} else if msg.GetControlChange(&ch, &key, &vel) {
-			fmt.Printf("(ch=%d, cc=%d, val=%d)", ch, key, vel)
+			fmt.Fprintf(stdout, "(ch=%d, cc=%d, val=%d)", ch, key, vel)
 		} else if msg.GetProgramChange(&ch, &vel) {
-			fmt.Printf("(ch=%d, program=%d)", ch, vel)
+			fmt.Fprintf(stdout, "(ch=%d, program=%d)", ch, vel)
 		} else if msg.GetPitchBend(&ch, &pitchValue, nil) {
-			fmt.Printf("(ch=%d, value=%d)", ch, pitchValue)
+			fmt.Fprintf(stdout, "(ch=%d, value=%d)", ch, pitchValue)
 		} else if msg.GetPolyAfterTouch(&ch, &key, &vel) {
-			fmt.Printf("(ch=%d, key=%d, pressure=%d)", ch, key, vel)
+			fmt.Fprintf(stdout, "(ch=%d, key=%d, pressure=%d)", ch, key, vel)
 		} else if msg.GetAfterTouch(&ch, &vel) {
-			fmt.Printf("(ch=%d, pressure=%d)", ch, vel)
+			fmt.Fprintf(stdout, "(ch=%d, pressure=%d)", ch, vel)
 		} else {
 			// For meta events and other types, try to extract text/data
 			var text string
 			if msg.GetMetaTrackName(&text) {
-				fmt.Printf("(\"%s\")", text)
+				fmt.Fprintf(stdout, "(\"%s\")", text)
 			} else if msg.GetMetaText(&text) {
-				fmt.Printf("(\"%s\")", text)
+				fmt.Fprintf(stdout, "(\"%s\")", text)
 			} else if msg.GetMetaLyric(&text) {
-				fmt.Printf("(\"%s\")", text)
+				fmt.Fprintf(stdout, "(\"%s\")", text)
 			} else if msg.GetMetaMarker(&text) {
-				fmt.Printf("(\"%s\")", text)
+				fmt.Fprintf(stdout, "(\"%s\")", text)
 			} else {
 				var tempo float64
 				var num, denom uint8
 				if msg.GetMetaTempo(&tempo) {
-					fmt.Printf("(%.1f BPM)", tempo)
+					fmt.Fprintf(stdout, "(%.1f BPM)", tempo)
 				} else if msg.GetMetaTimeSig(&num, &denom, nil, nil) {
-					fmt.Printf("(%d/%d)", num, 1<<denom)
+					fmt.Fprintf(stdout, "(%d/%d)", num, 1<<denom)
 				}
 			}
 		}
 
-		fmt.Println()
+		fmt.Fprintln(stdout)
 	}
 }
 
@@ -467,200 +1124,529 @@ func getTrackName(track smf.Track) string {
 	return ""
 }
 
-func printSngFile(sngFile *SngFile, jsonOutput bool) {
+// printSngFile prints the package info, with the loudness of every stem when
+// measureLoudness is set
+func printSngFile(stdout io.Writer, sngFile *SngFile, measureLoudness bool, jsonOutput bool) error {
 	if jsonOutput {
 		output := map[string]interface{}{
 			"header":   sngFile.Header,
 			"metadata": sngFile.GetMetadata(),
 			"files":    sngFile.Files,
+			"stems":    sngFile.AudioStems(),
+			"images":   sngFile.Images(),
+		}
+		if chartHash, err := sngFile.ChartHash(); err == nil {
+			output["chart_hash"] = chartHash
+		}
+		if measureLoudness {
+			if loudness := measureStemLoudness(sngFile); loudness != nil {
+				output["loudness"] = loudness
+			}
 		}
 		jsonData, err := json.MarshalIndent(output, "", "  ")
 		if err != nil {
-			log.Printf("Error marshaling to JSON: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to marshal to JSON: %w", err)
 		}
-		fmt.Println(string(jsonData))
-		return
+		fmt.Fprintln(stdout, string(jsonData))
+		return nil
 	}
 
-	fmt.Printf("Version: %d\n", sngFile.Header.Version)
-	fmt.Println()
+	if sngFile.dir != "" {
+		fmt.Fprintf(stdout, "Song folder: %s\n", sngFile.dir)
+	} else {
+		fmt.Fprintf(stdout, "Version: %d\n", sngFile.Header.Version)
+	}
+	if chartHash, err := sngFile.ChartHash(); err == nil {
+		fmt.Fprintf(stdout, "Chart hash (%s):\n", chartHash.File)
+		fmt.Fprintf(stdout, "  MD5: %s\n", chartHash.MD5)
+		fmt.Fprintf(stdout, "  SHA-1: %s\n", chartHash.SHA1)
+	}
+	fmt.Fprintln(stdout)
 
 	metadata := sngFile.GetMetadata()
 	if len(metadata) > 0 {
-		fmt.Println("Metadata:")
+		fmt.Fprintln(stdout, "Metadata:")
 		for key, value := range metadata {
-			fmt.Printf("  %s: %s\n", key, value)
+			fmt.Fprintf(stdout, "  %s: %s\n", key, value)
 		}
-		fmt.Println()
+		fmt.Fprintln(stdout)
 	}
 
 	files := sngFile.ListFiles()
-	fmt.Printf("Contains %d files:\n", len(files))
+	fmt.Fprintf(stdout, "Contains %d files:\n", len(files))
 	for i, filename := range files {
 		entry := sngFile.Files[i]
-		fmt.Printf("  %s (%d bytes)\n", filename, entry.Size)
+		fmt.Fprintf(stdout, "  %s (%d bytes)\n", filename, entry.Size)
+	}
+	fmt.Fprintln(stdout)
+
+	images := sngFile.Images()
+	if len(images) > 0 {
+		fmt.Fprintln(stdout, "Images:")
+		for _, image := range images {
+			if image.Format == "" {
+				fmt.Fprintf(stdout, "  %s: %s (unknown format)\n", image.Kind, image.Filename)
+			} else if image.Width > 0 {
+				fmt.Fprintf(stdout, "  %s: %s (%s, %dx%d)\n", image.Kind, image.Filename, image.Format, image.Width, image.Height)
+			} else {
+				fmt.Fprintf(stdout, "  %s: %s (%s)\n", image.Kind, image.Filename, image.Format)
+			}
+		}
+		fmt.Fprintln(stdout)
+	}
+
+	stems := sngFile.AudioStems()
+	if len(stems) > 0 {
+		var loudness map[string]*LoudnessStats
+		if measureLoudness {
+			loudness = measureStemLoudness(sngFile)
+		}
+		fmt.Fprintln(stdout, "Audio stems:")
+		for _, stem := range stems {
+			if stats, ok := loudness[stem.Name]; ok {
+				fmt.Fprintf(stdout, "  %s: %s (%.1f LUFS, range %.1f LU, peak %.1f dBTP)\n", stem.Name, stem.Filename, stats.Integrated, stats.Range, stats.TruePeak)
+			} else {
+				fmt.Fprintf(stdout, "  %s: %s\n", stem.Name, stem.Filename)
+			}
+		}
+		fmt.Fprintln(stdout)
+	}
+	return nil
+}
+
+// measureStemLoudness measures the loudness of every audio stem by name. It
+// returns nil when the audio backend is unavailable, and leaves out stems that
+// fail to measure.
+func measureStemLoudness(sngFile *SngFile) map[string]*LoudnessStats {
+	stems := sngFile.AudioStems()
+	if len(stems) == 0 {
+		return nil
+	}
+	if err := sngFile.AudioProcessor().Validate(); err != nil {
+		log.Printf("Warning: can't measure loudness: %v\n", err)
+		return nil
+	}
+
+	loudness := make(map[string]*LoudnessStats)
+	for _, stem := range stems {
+		stats, err := sngFile.MeasureLoudness(stem)
+		if err != nil {
+			log.Printf("Warning: %v\n", err)
+			continue
+		}
+		loudness[stem.Name] = stats
+	}
+	return loudness
+}
+
+// printQuantizedTimeline prints the song timeline quantized to integer BPMs along
+// with how far each measure drifts from the original timing
+func printQuantizedTimeline(stdout io.Writer, song SongInterface, filename string, method string, window int, changePenalty float64, jsonOutput bool) error {
+	timeline, err := song.GetTimeline()
+	if err != nil {
+		return fmt.Errorf("failed to extract timeline: %w", err)
+	}
+
+	quantized, err := QuantizeTimeline(timeline, method, window, changePenalty)
+	if err != nil {
+		return fmt.Errorf("failed to quantize timeline: %w", err)
+	}
+	report := CalculateDriftReport(timeline, quantized, method, window)
+
+	if jsonOutput {
+		output := map[string]interface{}{
+			"timeline": quantized,
+			"drift":    report,
+		}
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal quantized timeline to JSON: %w", err)
+		}
+		fmt.Fprintln(stdout, string(jsonData))
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "Quantized timeline for: %s\n", filename)
+	fmt.Fprint(stdout, quantized.String())
+	fmt.Fprintln(stdout)
+	fmt.Fprint(stdout, report.String())
+	return nil
+}
+
+// checkBeatTrack prints the BEAT track report and writes a MIDI file with a
+// repaired BEAT track when an output file is given
+func checkBeatTrack(stdout io.Writer, midiFile *MidiFile, filename string, outputFile string, jsonOutput bool) error {
+	report, err := midiFile.CheckBeatTrack()
+	if err != nil {
+		return fmt.Errorf("failed to check BEAT track: %w", err)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal beat check to JSON: %w", err)
+		}
+		fmt.Fprintln(stdout, string(jsonData))
+	} else {
+		fmt.Fprintf(stdout, "Beat check for: %s\n", filename)
+		fmt.Fprint(stdout, report.String())
 	}
-	fmt.Println()
+
+	if outputFile == "" {
+		return nil
+	}
+
+	repaired, err := midiFile.RepairBeatTrack()
+	if err != nil {
+		return fmt.Errorf("failed to repair BEAT track: %w", err)
+	}
+
+	err = repaired.WriteFile(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to write MIDI file: %w", err)
+	}
+
+	if !jsonOutput {
+		fmt.Fprintf(stdout, "Repaired BEAT track written to: %s\n", outputFile)
+	}
+	return nil
+}
+
+// exportLyricsFile writes the vocal phrases as lyrics to the output file, or
+// stdout when no output file is given
+func exportLyricsFile(stdout io.Writer, phrases []VocalPhrase, format string, outputFile string) error {
+	if len(phrases) == 0 {
+		log.Printf("Warning: No lyrics found\n")
+	}
+
+	var writer io.Writer
+	if outputFile != "" {
+		file, err := os.Create(outputFile)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		writer = file
+	} else {
+		writer = stdout
+	}
+
+	err := WriteLyricsTo(writer, phrases, strings.ToLower(format))
+	if err != nil {
+		return fmt.Errorf("failed to export lyrics: %w", err)
+	}
+
+	if outputFile != "" {
+		fmt.Fprintf(stdout, "Lyrics exported to: %s\n", outputFile)
+	}
+	return nil
 }
 
 // exportToToneLib exports song data to ToneLib the_song.dat XML format
-func exportToToneLib(song SongInterface, filename string) {
+func exportToToneLib(stdout io.Writer, song SongInterface, outputFile string) error {
 	var writer io.Writer
-	outputFile := flag.Arg(1)
 	if outputFile != "" {
 		file, err := os.Create(outputFile)
 		if err != nil {
-			log.Printf("Error creating output file: %v\n", err)
-			return
+			return fmt.Errorf("failed to create output file: %w", err)
 		}
 		defer file.Close()
 		writer = file
 	} else {
-		writer = os.Stdout
+		writer = stdout
 	}
 
 	err := WriteToneLibXMLTo(writer, song)
 	if err != nil {
-		log.Printf("Error exporting to ToneLib: %v\n", err)
-		return
+		return fmt.Errorf("failed to export to ToneLib: %w", err)
 	}
+	return nil
 }
 
 // createToneLibSongFile creates a complete ToneLib .song ZIP archive
-func createToneLibSongFile(song SongInterface, outputFile string) {
-	fmt.Printf("Creating ToneLib song file: %s\n", outputFile)
+func createToneLibSongFile(stdout io.Writer, song SongInterface, outputFile string) error {
+	fmt.Fprintf(stdout, "Creating ToneLib song file: %s\n", outputFile)
 
 	file, err := os.Create(outputFile)
 	if err != nil {
-		log.Printf("Error creating output file: %v\n", err)
-		return
+		return fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer file.Close()
 
 	err = WriteToneLibSongTo(file, song)
 	if err != nil {
-		log.Printf("Error creating ToneLib song file: %v\n", err)
-		return
+		return fmt.Errorf("failed to create ToneLib song file: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "Successfully created ToneLib song file: %s\n", outputFile)
+	return nil
+}
+
+// exportClickTrack writes a metronome for the song as <output>.mid and <output>.wav
+func exportClickTrack(stdout io.Writer, song SongInterface, options ClickOptions, base string) error {
+	timeline, err := song.GetTimeline()
+	if err != nil {
+		return fmt.Errorf("failed to extract timeline: %w", err)
+	}
+
+	clickTrack, err := BuildClickTrack(timeline, options)
+	if err != nil {
+		return fmt.Errorf("failed to build click track: %w", err)
+	}
+
+	if base == "" {
+		base = "click"
+	}
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".mid"), ".wav")
+
+	outputs := []struct {
+		filename string
+		write    func(io.Writer) error
+	}{
+		{base + ".mid", clickTrack.WriteMidiTo},
+		{base + ".wav", func(w io.Writer) error { return clickTrack.WriteWavTo(w, DefaultSynthSampleRate) }},
+	}
+	for _, output := range outputs {
+		file, err := os.Create(output.filename)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		if err := output.write(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write %s: %w", output.filename, err)
+		}
+		file.Close()
+		fmt.Fprintf(stdout, "Click exported to: %s\n", output.filename)
+	}
+
+	if clickTrack.AudioDelay > 0 {
+		fmt.Fprintf(stdout, "The count-in starts before the song audio, delay the audio by %.3fs to play along\n", clickTrack.AudioDelay)
+	}
+	return nil
+}
+
+// checkAudioSync compares the drum notes of the package against the onsets of
+// the drum stems, or the selected stems, and prints the offset report. The
+// absolute offset suggestion is left out for slices, which have their own
+// offset.
+func checkAudioSync(stdout io.Writer, sngFile *SngFile, selection string, sliced bool, jsonOutput bool) error {
+	stems := sngFile.AudioStems()
+	var err error
+	if selection != "" {
+		stems, err = SelectAudioStems(stems, selection)
+	} else if drums, drumsErr := SelectAudioStems(stems, "drums"); drumsErr == nil {
+		stems = drums
+	} else {
+		log.Printf("No drum stem found, checking against the full mix")
+	}
+	if err != nil {
+		return err
+	}
+
+	timeline, err := sngFile.GetTimeline()
+	if err != nil {
+		return fmt.Errorf("failed to extract timeline: %w", err)
+	}
+
+	noteTimes, err := sngFile.DrumNoteTimes()
+	if err != nil {
+		return fmt.Errorf("failed to read drum notes: %w", err)
 	}
 
-	fmt.Printf("Successfully created ToneLib song file: %s\n", outputFile)
+	samples, sampleRate, err := sngFile.DecodeAudio(stems)
+	if err != nil {
+		return fmt.Errorf("failed to decode audio: %w", err)
+	}
+
+	report, err := CheckSync(timeline, noteTimes, DetectOnsets(samples, sampleRate))
+	if err != nil {
+		return fmt.Errorf("failed to check sync: %w", err)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal sync check to JSON: %w", err)
+		}
+		fmt.Fprintln(stdout, string(jsonData))
+		return nil
+	}
+
+	var names []string
+	for _, stem := range stems {
+		names = append(names, stem.Name)
+	}
+	fmt.Fprintf(stdout, "Sync check against stems: %s\n", strings.Join(names, ", "))
+	fmt.Fprint(stdout, report.String())
+	if !sliced && report.Matched > 0 && math.Abs(report.Offset) > syncTolerance {
+		fmt.Fprintf(stdout, "Suggested: --audio-offset %.3f\n", report.SuggestedAudioOffset())
+	}
+	return nil
+}
+
+// exportSngAudio mixes the selected stems of the package into the output file,
+// or writes each of them to the output directory when splitStems is set
+func exportSngAudio(stdout io.Writer, sngFile *SngFile, format string, output string, bitrate string, selection string, splitStems bool) error {
+	format = strings.ToLower(format)
+	encoding, ok := AudioFormatEncodings[format]
+	if !ok {
+		return fmt.Errorf("unknown audio format %q (expected ogg, wav, flac or mp3)", format)
+	}
+	if bitrate != "" {
+		encoding.Bitrate = bitrate
+	}
+
+	stems, err := SelectAudioStems(sngFile.AudioStems(), selection)
+	if err != nil {
+		return err
+	}
+
+	if !splitStems {
+		outputFile := output
+		if outputFile == "" {
+			outputFile = "audio." + format
+		}
+
+		if err := sngFile.ExportAudio(stems, encoding, outputFile); err != nil {
+			return fmt.Errorf("failed to export audio: %w", err)
+		}
+		fmt.Fprintf(stdout, "Audio exported to: %s\n", outputFile)
+		return nil
+	}
+
+	outputDir := output
+	if outputDir == "" {
+		outputDir = "."
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// Normalizing each stem on its own would lose the balance between them
+	mixOptions := sngFile.MixOptions()
+	mixOptions.LoudnessTarget = 0
+	sngFile.SetMixOptions(mixOptions)
+
+	for _, stem := range stems {
+		outputFile := filepath.Join(outputDir, stem.Name+"."+format)
+		if err := sngFile.ExportAudio([]AudioStem{stem}, encoding, outputFile); err != nil {
+			return fmt.Errorf("failed to export %s: %w", stem.Name, err)
+		}
+		fmt.Fprintf(stdout, "Stem %s exported to: %s\n", stem.Name, outputFile)
+	}
+	return nil
 }
 
 // extractFileFromSng extracts and prints the contents of a file from an SNG package
-func extractFileFromSng(sngFile *SngFile, filename string) {
+func extractFileFromSng(stdout io.Writer, sngFile *SngFile, filename string, outputFile string) error {
 	data, err := sngFile.ReadFile(filename)
 	if err != nil {
-		log.Printf("Error reading file '%s' from SNG package: %v\n", filename, err)
-		os.Exit(1)
+		return fmt.Errorf("failed to read file '%s' from SNG package: %w", filename, err)
 	}
 
-	// Check if output file is specified as second argument
-	outputFile := flag.Arg(1)
 	if outputFile != "" {
 		// Write to specified output file
 		file, err := os.Create(outputFile)
 		if err != nil {
-			log.Printf("Error creating output file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to create output file: %w", err)
 		}
 		defer file.Close()
 
 		_, err = file.Write(data)
 		if err != nil {
-			log.Printf("Error writing to output file: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to write to output file: %w", err)
 		}
 
-		fmt.Printf("Extracted '%s' to: %s\n", filename, outputFile)
+		fmt.Fprintf(stdout, "Extracted '%s' to: %s\n", filename, outputFile)
 	} else {
 		// Write to stdout
-		_, err = os.Stdout.Write(data)
+		_, err = stdout.Write(data)
 		if err != nil {
-			log.Printf("Error writing to stdout: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to write to stdout: %w", err)
 		}
 	}
+	return nil
 }
 
-func printChartInfo(chart *ChartFile, jsonOutput bool, filterTrack string) {
+func printChartInfo(stdout io.Writer, chart *ChartFile, chartHash *ChartHash, jsonOutput bool, filterTrack string) error {
 	if jsonOutput {
-		jsonData, err := json.MarshalIndent(chart, "", "  ")
+		output := struct {
+			*ChartFile
+			ChartHash *ChartHash `json:"chart_hash,omitempty"`
+		}{chart, chartHash}
+		jsonData, err := json.MarshalIndent(output, "", "  ")
 		if err != nil {
-			log.Printf("Error marshaling chart to JSON: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to marshal chart to JSON: %w", err)
 		}
-		fmt.Println(string(jsonData))
-		return
+		fmt.Fprintln(stdout, string(jsonData))
+		return nil
 	}
 
-	fmt.Printf("Chart File: %s\n", chart.Filename)
+	fmt.Fprintf(stdout, "Chart File: %s\n", chart.Filename)
+	if chartHash != nil {
+		fmt.Fprintf(stdout, "Chart hash MD5: %s\n", chartHash.MD5)
+		fmt.Fprintf(stdout, "Chart hash SHA-1: %s\n", chartHash.SHA1)
+	}
 	if chart.Song.Name != "" {
-		fmt.Printf("Title: %s\n", chart.Song.Name)
+		fmt.Fprintf(stdout, "Title: %s\n", chart.Song.Name)
 	}
 	if chart.Song.Artist != "" {
-		fmt.Printf("Artist: %s\n", chart.Song.Artist)
+		fmt.Fprintf(stdout, "Artist: %s\n", chart.Song.Artist)
 	}
 	if chart.Song.Album != "" {
-		fmt.Printf("Album: %s\n", chart.Song.Album)
+		fmt.Fprintf(stdout, "Album: %s\n", chart.Song.Album)
 	}
 	if chart.Song.Charter != "" {
-		fmt.Printf("Charter: %s\n", chart.Song.Charter)
+		fmt.Fprintf(stdout, "Charter: %s\n", chart.Song.Charter)
 	}
 	if chart.Song.Year != "" {
-		fmt.Printf("Year: %s\n", chart.Song.Year)
+		fmt.Fprintf(stdout, "Year: %s\n", chart.Song.Year)
 	}
 	if chart.Song.Genre != "" {
-		fmt.Printf("Genre: %s\n", chart.Song.Genre)
+		fmt.Fprintf(stdout, "Genre: %s\n", chart.Song.Genre)
 	}
-	fmt.Printf("Resolution: %d ticks per quarter note\n", chart.Song.Resolution)
-	fmt.Printf("Offset: %d\n", chart.Song.Offset)
+	fmt.Fprintf(stdout, "Resolution: %d ticks per quarter note\n", chart.Song.Resolution)
+	fmt.Fprintf(stdout, "Offset: %g\n", chart.Song.Offset)
 	if chart.Song.MusicStream != "" {
-		fmt.Printf("Audio: %s\n", chart.Song.MusicStream)
+		fmt.Fprintf(stdout, "Audio: %s\n", chart.Song.MusicStream)
 	}
-	fmt.Println()
+	fmt.Fprintln(stdout)
 
 	// Sync track info
-	fmt.Printf("Sync Track:\n")
-	fmt.Printf("  BPM changes: %d\n", len(chart.SyncTrack.BPMEvents))
+	fmt.Fprintf(stdout, "Sync Track:\n")
+	fmt.Fprintf(stdout, "  BPM changes: %d\n", len(chart.SyncTrack.BPMEvents))
 	if len(chart.SyncTrack.BPMEvents) > 0 {
 		firstBPM := chart.SyncTrack.BPMEvents[0]
-		fmt.Printf("  Starting BPM: %.1f\n", float64(firstBPM.BPM)/1000.0)
+		fmt.Fprintf(stdout, "  Starting BPM: %.1f\n", float64(firstBPM.BPM)/1000.0)
 	}
-	fmt.Printf("  Time signature changes: %d\n", len(chart.SyncTrack.TimeSigEvents))
-	fmt.Printf("  Anchor events: %d\n", len(chart.SyncTrack.AnchorEvents))
-	fmt.Println()
+	fmt.Fprintf(stdout, "  Time signature changes: %d\n", len(chart.SyncTrack.TimeSigEvents))
+	fmt.Fprintf(stdout, "  Anchor events: %d\n", len(chart.SyncTrack.AnchorEvents))
+	fmt.Fprintln(stdout)
 
 	// Events info
-	fmt.Printf("Global Events: %d\n", len(chart.Events.GlobalEvents))
+	fmt.Fprintf(stdout, "Global Events: %d\n", len(chart.Events.GlobalEvents))
 
 	// Count lyrics and sections
-	var lyricCount, sectionCount int
-	for _, event := range chart.Events.GlobalEvents {
-		if strings.HasPrefix(event.Text, "lyric ") {
-			lyricCount++
-		} else if strings.HasPrefix(event.Text, "section ") {
-			sectionCount++
-		}
-	}
+	lyricCount := len(chart.GlobalEventsOfType(ChartEventLyric))
+	sections := chart.GlobalEventsOfType(ChartEventSection)
 	if lyricCount > 0 {
-		fmt.Printf("  Lyrics: %d\n", lyricCount)
+		fmt.Fprintf(stdout, "  Lyrics: %d\n", lyricCount)
 	}
-	if sectionCount > 0 {
-		fmt.Printf("  Sections: %d\n", sectionCount)
+	if len(sections) > 0 {
+		fmt.Fprintf(stdout, "  Sections: %d\n", len(sections))
 	}
 
 	// Extract and display full lyrics (similar to MIDI files)
 	if lyricCount > 0 {
 		fullLyrics := extractChartLyrics(chart)
 		if fullLyrics != "" {
-			fmt.Printf("  Full Lyrics: %s\n", fullLyrics)
+			fmt.Fprintf(stdout, "  Full Lyrics: %s\n", fullLyrics)
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(stdout)
 
 	// Track info
-	fmt.Printf("Tracks: %d\n", len(chart.Tracks))
+	fmt.Fprintf(stdout, "Tracks: %d\n", len(chart.Tracks))
 	for trackName, track := range chart.Tracks {
 		// Apply track filtering if specified
 		if filterTrack != "" {
@@ -669,17 +1655,17 @@ func printChartInfo(chart *ChartFile, jsonOutput bool, filterTrack string) {
 			}
 		}
 
-		fmt.Printf("  %s:\n", trackName)
-		fmt.Printf("    Notes: %d\n", len(track.Notes))
-		fmt.Printf("    Specials: %d\n", len(track.Specials))
-		fmt.Printf("    Track Events: %d\n", len(track.TrackEvents))
+		fmt.Fprintf(stdout, "  %s:\n", trackName)
+		fmt.Fprintf(stdout, "    Notes: %d\n", len(track.Notes))
+		fmt.Fprintf(stdout, "    Specials: %d\n", len(track.Specials))
+		fmt.Fprintf(stdout, "    Track Events: %d\n", len(track.TrackEvents))
 
 		if len(track.Notes) > 0 {
 			firstNote := track.Notes[0]
 			lastNote := track.Notes[len(track.Notes)-1]
 			duration := lastNote.Tick - firstNote.Tick
 			durationSeconds := calculateTickDuration(chart, firstNote.Tick, lastNote.Tick)
-			fmt.Printf("    Duration: %d ticks (%.2f seconds)\n", duration, durationSeconds)
+			fmt.Fprintf(stdout, "    Duration: %d ticks (%.2f seconds)\n", duration, durationSeconds)
 
 			// Count notes by fret
 			fretCounts := make(map[uint8]int)
@@ -691,16 +1677,16 @@ func printChartInfo(chart *ChartFile, jsonOutput bool, filterTrack string) {
 				}
 			}
 
-			fmt.Printf("    Notes by fret: ")
+			fmt.Fprintf(stdout, "    Notes by fret: ")
 			for fret := uint8(0); fret <= 7; fret++ {
 				if count, exists := fretCounts[fret]; exists && count > 0 {
-					fmt.Printf("F%d:%d ", fret, count)
+					fmt.Fprintf(stdout, "F%d:%d ", fret, count)
 				}
 			}
-			fmt.Println()
+			fmt.Fprintln(stdout)
 
 			if sustainCount > 0 {
-				fmt.Printf("    Sustained notes: %d\n", sustainCount)
+				fmt.Fprintf(stdout, "    Sustained notes: %d\n", sustainCount)
 			}
 		}
 
@@ -710,7 +1696,7 @@ func printChartInfo(chart *ChartFile, jsonOutput bool, filterTrack string) {
 			for _, special := range track.Specials {
 				specialTypes[special.Type]++
 			}
-			fmt.Printf("    Special types: ")
+			fmt.Fprintf(stdout, "    Special types: ")
 			for sType, count := range specialTypes {
 				var typeName string
 				switch sType {
@@ -725,19 +1711,20 @@ func printChartInfo(chart *ChartFile, jsonOutput bool, filterTrack string) {
 				default:
 					typeName = fmt.Sprintf("S%d", sType)
 				}
-				fmt.Printf("%s:%d ", typeName, count)
+				fmt.Fprintf(stdout, "%s:%d ", typeName, count)
 			}
-			fmt.Println()
+			fmt.Fprintln(stdout)
 		}
 
 		// If filtering is active, show detailed event information
 		if filterTrack != "" {
-			fmt.Printf("    Detailed Events:\n")
-			printChartTrackEvents(&track)
+			fmt.Fprintf(stdout, "    Detailed Events:\n")
+			printChartTrackEvents(stdout, &track)
 		}
 
-		fmt.Println()
+		fmt.Fprintln(stdout)
 	}
+	return nil
 }
 
 func calculateTickDuration(chart *ChartFile, startTick, endTick uint32) float64 {
@@ -782,7 +1769,7 @@ func calculateTickDuration(chart *ChartFile, startTick, endTick uint32) float64
 	return totalSeconds
 }
 
-func printChartTrackEvents(track *TrackSection) {
+func printChartTrackEvents(stdout io.Writer, track *TrackSection) {
 	// Combine all events and sort by tick
 	type eventInfo struct {
 		tick uint32
@@ -831,6 +1818,6 @@ func printChartTrackEvents(track *TrackSection) {
 	})
 
 	for _, event := range allEvents {
-		fmt.Printf("      Tick %d: %s\n", event.tick, event.text)
+		fmt.Fprintf(stdout, "      Tick %d: %s\n", event.tick, event.text)
 	}
 }
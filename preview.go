@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Preview clip settings
+const (
+	DefaultPreviewLength = 30.0 // Seconds of preview when the song only sets a start
+	previewFadeIn        = 0.5  // Seconds of fade in at the start of the clip
+	previewFadeOut       = 2.0  // Seconds of fade out at the end of the clip
+)
+
+// Preview range sources
+const (
+	PreviewSourceMetadata = "metadata" // preview_start_time of song.ini or the SNG metadata
+	PreviewSourceChart    = "chart"    // PreviewStart and PreviewEnd of notes.chart
+	PreviewSourceSection  = "section"  // First chorus section, when the song sets no preview
+	PreviewSourceStart    = "start"    // Start of the song, when there is nothing better
+)
+
+// PreviewRange is the part of a song played as its preview in song browsers
+type PreviewRange struct {
+	Start  float64 `json:"start"`  // Seconds in the audio
+	End    float64 `json:"end"`    // Seconds in the audio, zero when only the start is set
+	Source string  `json:"source"` // One of the PreviewSource values
+}
+
+// GetPreviewRange returns the PreviewStart and PreviewEnd of the chart, stored
+// in milliseconds. Returns false when the chart sets no preview.
+func (c *ChartFile) GetPreviewRange() (*PreviewRange, bool) {
+	if c.Song.PreviewStart <= 0 && c.Song.PreviewEnd <= 0 {
+		return nil, false
+	}
+
+	preview := &PreviewRange{
+		Start:  float64(c.Song.PreviewStart) / 1000.0,
+		Source: PreviewSourceChart,
+	}
+	if c.Song.PreviewEnd > c.Song.PreviewStart {
+		preview.End = float64(c.Song.PreviewEnd) / 1000.0
+	}
+	return preview, true
+}
+
+// GetPreviewRange returns the preview_start_time metadata of the package, in
+// milliseconds, falling back to the preview of notes.chart. Returns false when
+// neither sets a preview.
+func (s *SngFile) GetPreviewRange() (*PreviewRange, bool) {
+	if value, ok := s.Metadata["preview_start_time"]; ok {
+		if ms, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && ms >= 0 {
+			return &PreviewRange{Start: ms / 1000.0, Source: PreviewSourceMetadata}, true
+		}
+	}
+
+	if chartData, err := s.ReadFile("notes.chart"); err == nil {
+		if chartFile, err := ParseChartFile(bytes.NewReader(chartData)); err == nil {
+			return chartFile.GetPreviewRange()
+		}
+	}
+
+	return nil, false
+}
+
+// ResolvePreviewSlice turns the preview range into the measures of the timeline
+// around it. A nil preview starts at the first chorus section, or the start of
+// the song without one. Previews without an end last length seconds, cut short
+// at the end of the song.
+func ResolvePreviewSlice(timeline *Timeline, preview *PreviewRange, length float64) (*SongSlice, *PreviewRange, error) {
+	if timeline == nil || len(timeline.Measures) == 0 {
+		return nil, nil, fmt.Errorf("song has no measures for a preview")
+	}
+	if length <= 0 {
+		length = DefaultPreviewLength
+	}
+
+	if preview == nil {
+		preview = &PreviewRange{Start: timeline.Measures[0].StartTimeSeconds, Source: PreviewSourceStart}
+		for _, section := range timeline.Sections {
+			if strings.Contains(strings.ToLower(section.Name), "chorus") {
+				preview = &PreviewRange{Start: section.Seconds, Source: PreviewSourceSection}
+				break
+			}
+		}
+	}
+
+	songEnd := timeline.Measures[len(timeline.Measures)-1].EndTimeSeconds
+	if preview.Start >= songEnd {
+		return nil, nil, fmt.Errorf("preview starts at %.3fs, past the end of the song (%.3fs)", preview.Start, songEnd)
+	}
+
+	end := preview.End
+	if end <= preview.Start {
+		end = preview.Start + length
+	}
+
+	from := fmt.Sprintf("%.3fs", preview.Start)
+	to := fmt.Sprintf("%.3fs", end)
+	if end >= songEnd {
+		to = ""
+	}
+
+	slice, err := ResolveSongSlice(timeline, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid preview range: %w", err)
+	}
+	return slice, preview, nil
+}
+
+// ExportPreview mixes the stems of the sliced package into a clip faded in and
+// out. The package must have a slice set with SetSlice.
+func (s *SngFile) ExportPreview(stems []AudioStem, encoding AudioEncoding, outputPath string) error {
+	if s.slice == nil {
+		return fmt.Errorf("preview export needs a slice of the song")
+	}
+	if err := s.AudioProcessor().Validate(); err != nil {
+		return fmt.Errorf("preview export failed: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "sng-audio-preview-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	clipPath := filepath.Join(tempDir, "clip.wav")
+	if err := s.mixAudioStems(stems, clipPath, PCMAudioEncoding); err != nil {
+		return fmt.Errorf("preview export failed: %w", err)
+	}
+
+	duration := s.slice.EndSeconds - s.slice.AudioTrimStart()
+	fadeOut := previewFadeOut
+	if fadeOut > duration/2 {
+		fadeOut = duration / 2
+	}
+	return s.AudioProcessor().Fade(clipPath, outputPath, duration, previewFadeIn, fadeOut, encoding)
+}
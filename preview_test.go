@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePreviewSlice(t *testing.T) {
+	timeline, err := (&MidiFile{SMF: createTempoMapMidiFile()}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+	timeline.setSections([]Section{{Tick: 1920, Name: "verse_1"}, {Tick: 3840, Name: "chorus_1"}})
+
+	tests := []struct {
+		name      string
+		preview   *PreviewRange
+		length    float64
+		startMeas int
+		endMeas   int
+		source    string
+	}{
+		{"start and length", &PreviewRange{Start: 2.5, Source: PreviewSourceMetadata}, 3, 2, 3, PreviewSourceMetadata},
+		{"start and end", &PreviewRange{Start: 0.5, End: 2.5, Source: PreviewSourceChart}, 0, 1, 2, PreviewSourceChart},
+		{"chorus fallback", nil, 0, 3, 4, PreviewSourceSection},
+	}
+
+	for _, test := range tests {
+		slice, preview, err := ResolvePreviewSlice(timeline, test.preview, test.length)
+		if err != nil {
+			t.Fatalf("%s: ResolvePreviewSlice failed: %v", test.name, err)
+		}
+		if slice.StartMeasure != test.startMeas || slice.EndMeasure != test.endMeas {
+			t.Errorf("%s: expected measures %d-%d, got %d-%d", test.name, test.startMeas, test.endMeas, slice.StartMeasure, slice.EndMeasure)
+		}
+		if preview.Source != test.source {
+			t.Errorf("%s: expected source %s, got %s", test.name, test.source, preview.Source)
+		}
+	}
+
+	timeline.Sections = nil
+	_, preview, err := ResolvePreviewSlice(timeline, nil, 0)
+	if err != nil || preview.Source != PreviewSourceStart {
+		t.Errorf("expected the preview at the start of a song without sections, got %+v (%v)", preview, err)
+	}
+}
+
+func TestChartPreviewRange(t *testing.T) {
+	chart, err := ParseChartFile(bytes.NewReader([]byte(validChartData)))
+	if err != nil {
+		t.Fatalf("ParseChartFile failed: %v", err)
+	}
+
+	preview, ok := chart.GetPreviewRange()
+	if !ok || preview.Start != 30 || preview.End != 60 || preview.Source != PreviewSourceChart {
+		t.Errorf("expected a preview from 30s to 60s, got %+v", preview)
+	}
+
+	chart.Song.PreviewStart, chart.Song.PreviewEnd = 0, 0
+	if _, ok := chart.GetPreviewRange(); ok {
+		t.Errorf("expected no preview without PreviewStart and PreviewEnd")
+	}
+}
+
+func TestExportPreview(t *testing.T) {
+	var midiData bytes.Buffer
+	if _, err := createTempoMapMidiFile().WriteTo(&midiData); err != nil {
+		t.Fatalf("failed to write MIDI file: %v", err)
+	}
+	sngFile := writeTestSngFile(t, map[string]string{"preview_start_time": "2500"}, []sngTestFile{
+		{"notes.mid", midiData.Bytes()},
+		{"song.opus", []byte("song audio")},
+		{"drums_1.opus", []byte("drums audio")},
+	})
+	recorder := &recordingAudioProcessor{loudness: LoudnessStats{Integrated: -16, TruePeak: -4}}
+	sngFile.SetAudioProcessor(recorder)
+
+	preview, ok := sngFile.GetPreviewRange()
+	if !ok || preview.Start != 2.5 || preview.Source != PreviewSourceMetadata {
+		t.Fatalf("expected the preview at 2.5s from the metadata, got %+v", preview)
+	}
+
+	timeline, err := sngFile.GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+	slice, _, err := ResolvePreviewSlice(timeline, preview, 3)
+	if err != nil {
+		t.Fatalf("ResolvePreviewSlice failed: %v", err)
+	}
+	sngFile.SetSlice(slice)
+
+	output := filepath.Join(t.TempDir(), "preview.ogg")
+	if err := sngFile.ExportPreview(sngFile.AudioStems(), AudioFormatEncodings[AudioFormatOgg], output); err != nil {
+		t.Fatalf("ExportPreview failed: %v", err)
+	}
+
+	// Measures 2 and 3 run from 2s to 6s, peak checked, then faded in and out
+	expected := []string{"merge 2 pcm_f32le", "trim 2.000 4.000 pcm_f32le", "loudness", "transcode pcm_s16le", "fade 4.000 0.500 2.000 libvorbis"}
+	if strings.Join(recorder.calls, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("expected %v, got %v", expected, recorder.calls)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultQuantizeWindow is how many BPM either side of the rounded BPM are tried
+// when quantizing a measure
+const DefaultQuantizeWindow = 2
+
+// DefaultBPMChangePenalty is the cost of changing BPM between two measures, in
+// seconds of drift. Larger values keep the tempo steady at the expense of drift.
+const DefaultBPMChangePenalty = 0.05
+
+// Quantize methods
+const (
+	QuantizeMethodGreedy = "greedy" // Each measure picks the best BPM given the previous choices
+	QuantizeMethodGlobal = "global" // Search over the whole song, see QuantizeBPMsGlobal
+)
+
+// QuantizeTimeline quantizes the timeline with the named method. The change
+// penalty only applies to the global search.
+func QuantizeTimeline(timeline *Timeline, method string, window int, changePenalty float64) (*Timeline, error) {
+	switch method {
+	case QuantizeMethodGreedy:
+		return QuantizeBPMsWithWindow(timeline, window), nil
+	case QuantizeMethodGlobal:
+		return QuantizeBPMsGlobal(timeline, window, changePenalty), nil
+	default:
+		return nil, fmt.Errorf("unknown quantize method %q (expected %s or %s)", method, QuantizeMethodGreedy, QuantizeMethodGlobal)
+	}
+}
+
+// quantizeTimeBucket is the resolution in seconds QuantizeBPMsGlobal tells the
+// end times of paths apart by
+const quantizeTimeBucket = 0.001
+
+// quantizeBeamWidth is how many of the cheapest search states QuantizeBPMsGlobal
+// keeps for each measure, which keeps the search linear in the song length
+const quantizeBeamWidth = 256
+
+// QuantizeBPMsGlobal picks integer BPMs for the whole song at once with dynamic
+// programming. The cost of a tempo map is the sum of the absolute drift at the end
+// of every measure plus changePenalty for every BPM change, so steady songs collapse
+// to a single BPM while songs with a drifting tempo stay locked to the audio.
+//
+// Each measure tries the BPMs within window of its own rounded BPM and of the
+// previous measure's rounded BPM. A search state is the BPM of a measure together
+// with the time the measure ends, since paths reaching the same BPM at different
+// times carry different drift into the rest of the song. End times are bucketed
+// to quantizeTimeBucket, and paths already costing more than the greedy
+// quantization are dropped, as they can't lead to the cheapest tempo map. Only
+// the quantizeBeamWidth cheapest states of a measure are carried on to the next,
+// so long songs may miss the cheapest tempo map but never do worse than greedy.
+func QuantizeBPMsGlobal(timeline *Timeline, window int, changePenalty float64) *Timeline {
+	if len(timeline.Measures) == 0 {
+		return timeline
+	}
+
+	greedy := QuantizeBPMsWithWindow(timeline, window)
+	bound := quantizeCost(timeline, greedy, changePenalty)
+	// Merged paths may each shift by a bucket, leave room so the greedy path
+	// itself is never dropped
+	bound += float64(len(timeline.Measures)) * quantizeTimeBucket
+
+	type stateKey struct {
+		bpm    int
+		bucket int64
+	}
+
+	type searchState struct {
+		bpm     int
+		cost    float64
+		endTime float64
+		prev    int // Index of the state in the previous layer, -1 for the first measure
+	}
+
+	layers := make([][]searchState, len(timeline.Measures))
+	start := []searchState{{endTime: timeline.AudioOffset, prev: -1}}
+
+	for i, measure := range timeline.Measures {
+		candidates := bpmCandidates(measure.BeatsPerMinute, window)
+		if i > 0 {
+			for _, bpm := range bpmCandidates(timeline.Measures[i-1].BeatsPerMinute, window) {
+				if !containsInt(candidates, bpm) {
+					candidates = append(candidates, bpm)
+				}
+			}
+		}
+
+		previous := start
+		if i > 0 {
+			previous = layers[i-1]
+		}
+
+		index := make(map[stateKey]int)
+		for j, prev := range previous {
+			for _, bpm := range candidates {
+				endTime := prev.endTime + float64(measure.BeatsPerMeasure)*60.0/float64(bpm)
+				cost := prev.cost + abs(endTime-measure.EndTimeSeconds)
+				if i > 0 && prev.bpm != bpm {
+					cost += changePenalty
+				}
+				if cost > bound {
+					continue
+				}
+
+				state := searchState{bpm: bpm, cost: cost, endTime: endTime, prev: j}
+				if i == 0 {
+					state.prev = -1
+				}
+
+				key := stateKey{bpm: bpm, bucket: int64(math.Round(endTime / quantizeTimeBucket))}
+				if k, ok := index[key]; ok {
+					if cost < layers[i][k].cost {
+						layers[i][k] = state
+					}
+					continue
+				}
+				index[key] = len(layers[i])
+				layers[i] = append(layers[i], state)
+			}
+		}
+
+		if len(layers[i]) == 0 {
+			// Every path was dropped, which the bound's slack should prevent
+			return greedy
+		}
+
+		if len(layers[i]) > quantizeBeamWidth {
+			layer := layers[i]
+			sort.Slice(layer, func(a, b int) bool { return layer[a].cost < layer[b].cost })
+			layers[i] = layer[:quantizeBeamWidth]
+		}
+	}
+
+	// Walk back from the cheapest final state
+	last := len(layers) - 1
+	bestIdx := 0
+	for j, state := range layers[last] {
+		if state.cost < layers[last][bestIdx].cost {
+			bestIdx = j
+		}
+	}
+
+	bpms := make([]int, len(timeline.Measures))
+	for i := last; i >= 0; i-- {
+		state := layers[i][bestIdx]
+		bpms[i] = state.bpm
+		bestIdx = state.prev
+	}
+
+	// The beam may have dropped the greedy path on the way
+	quantized := applyQuantizedBPMs(timeline, bpms)
+	if quantizeCost(timeline, quantized, changePenalty) > quantizeCost(timeline, greedy, changePenalty) {
+		return greedy
+	}
+	return quantized
+}
+
+// quantizeCost is the cost QuantizeBPMsGlobal minimizes: the absolute drift at
+// the end of every measure plus changePenalty for every BPM change
+func quantizeCost(original, quantized *Timeline, changePenalty float64) float64 {
+	var cost float64
+	for i, measure := range quantized.Measures {
+		cost += abs(measure.EndTimeSeconds - original.Measures[i].EndTimeSeconds)
+		if i > 0 && measure.BeatsPerMinute != quantized.Measures[i-1].BeatsPerMinute {
+			cost += changePenalty
+		}
+	}
+	return cost
+}
+
+// applyQuantizedBPMs returns a copy of the timeline with the given BPM for every
+// measure and the measure times recalculated from those BPMs
+func applyQuantizedBPMs(timeline *Timeline, bpms []int) *Timeline {
+	quantizedTimeline := &Timeline{
+		BeatNotes:    timeline.BeatNotes, // Keep original beat notes unchanged
+		TicksPerBeat: timeline.TicksPerBeat,
+		AudioOffset:  timeline.AudioOffset,
+		Source:       timeline.Source,
+		Sections:     timeline.Sections,
+	}
+
+	quantizedMeasures := make([]Measure, len(timeline.Measures))
+	currentTime := timeline.AudioOffset
+
+	for i, measure := range timeline.Measures {
+		quantizedMeasures[i] = measure
+		quantizedMeasures[i].BeatsPerMinute = float64(bpms[i])
+
+		duration := float64(measure.BeatsPerMeasure) * 60.0 / float64(bpms[i])
+		quantizedMeasures[i].StartTimeSeconds = currentTime
+		quantizedMeasures[i].EndTimeSeconds = currentTime + duration
+		currentTime += duration
+	}
+
+	quantizedTimeline.Measures = quantizedMeasures
+	return quantizedTimeline
+}
+
+// bpmCandidates returns the positive integer BPMs within window of the rounded BPM
+func bpmCandidates(bpm float64, window int) []int {
+	base := int(bpm + 0.5)
+
+	var candidates []int
+	for candidate := base - window; candidate <= base+window; candidate++ {
+		if candidate >= 1 {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = append(candidates, 1)
+	}
+	return candidates
+}
+
+// containsInt reports whether value is in values
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// MeasureDrift compares the end of a quantized measure against the original timeline
+type MeasureDrift struct {
+	Measure             int     `json:"measure"` // 1-based measure number
+	OriginalBPM         float64 `json:"original_bpm"`
+	QuantizedBPM        float64 `json:"quantized_bpm"`
+	OriginalEndSeconds  float64 `json:"original_end_seconds"`
+	QuantizedEndSeconds float64 `json:"quantized_end_seconds"`
+	DriftSeconds        float64 `json:"drift_seconds"` // Quantized minus original, positive when the quantized measure ends late
+}
+
+// DriftReport summarizes how far a quantized timeline drifts from the audio
+type DriftReport struct {
+	Method              string         `json:"method"`
+	Window              int            `json:"window"`
+	BPMChanges          int            `json:"bpm_changes"` // Number of measures whose BPM differs from the previous measure
+	Measures            []MeasureDrift `json:"measures"`
+	MaxDriftSeconds     float64        `json:"max_drift_seconds"` // Largest absolute drift
+	MaxDriftMeasure     int            `json:"max_drift_measure"` // Measure with the largest absolute drift
+	AverageDriftSeconds float64        `json:"average_drift_seconds"`
+	FinalDriftSeconds   float64        `json:"final_drift_seconds"` // Drift at the end of the last measure
+}
+
+// CalculateDriftReport compares every measure of the quantized timeline with the
+// matching measure of the original timeline
+func CalculateDriftReport(original, quantized *Timeline, method string, window int) *DriftReport {
+	report := &DriftReport{
+		Method:   method,
+		Window:   window,
+		Measures: []MeasureDrift{},
+	}
+
+	count := len(original.Measures)
+	if len(quantized.Measures) < count {
+		count = len(quantized.Measures)
+	}
+
+	if count == 0 {
+		return report
+	}
+
+	var totalDrift float64
+	for i := 0; i < count; i++ {
+		originalMeasure := original.Measures[i]
+		quantizedMeasure := quantized.Measures[i]
+
+		drift := quantizedMeasure.EndTimeSeconds - originalMeasure.EndTimeSeconds
+		report.Measures = append(report.Measures, MeasureDrift{
+			Measure:             i + 1,
+			OriginalBPM:         originalMeasure.BeatsPerMinute,
+			QuantizedBPM:        quantizedMeasure.BeatsPerMinute,
+			OriginalEndSeconds:  originalMeasure.EndTimeSeconds,
+			QuantizedEndSeconds: quantizedMeasure.EndTimeSeconds,
+			DriftSeconds:        drift,
+		})
+
+		if i > 0 && quantizedMeasure.BeatsPerMinute != quantized.Measures[i-1].BeatsPerMinute {
+			report.BPMChanges++
+		}
+
+		totalDrift += abs(drift)
+		if abs(drift) > report.MaxDriftSeconds || report.MaxDriftMeasure == 0 {
+			report.MaxDriftSeconds = abs(drift)
+			report.MaxDriftMeasure = i + 1
+		}
+	}
+
+	report.AverageDriftSeconds = totalDrift / float64(count)
+	report.FinalDriftSeconds = report.Measures[count-1].DriftSeconds
+
+	return report
+}
+
+// String returns the per-measure drift table followed by a summary
+func (r *DriftReport) String() string {
+	result := fmt.Sprintf("Drift report (%s search, window ±%d BPM):\n", r.Method, r.Window)
+
+	for _, measure := range r.Measures {
+		result += fmt.Sprintf("Measure %d: %.3f BPM -> %.0f BPM, ends %.3fs (original %.3fs), drift %+.1fms\n",
+			measure.Measure,
+			measure.OriginalBPM,
+			measure.QuantizedBPM,
+			measure.QuantizedEndSeconds,
+			measure.OriginalEndSeconds,
+			measure.DriftSeconds*1000,
+		)
+	}
+
+	result += fmt.Sprintf("BPM changes: %d\n", r.BPMChanges)
+	result += fmt.Sprintf("Max drift: %.1fms at measure %d\n", r.MaxDriftSeconds*1000, r.MaxDriftMeasure)
+	result += fmt.Sprintf("Average drift: %.1fms\n", r.AverageDriftSeconds*1000)
+	result += fmt.Sprintf("Final drift: %+.1fms\n", r.FinalDriftSeconds*1000)
+
+	return result
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// Section is a named part of the song, such as a verse or solo, used to
+// navigate practice sessions
+type Section struct {
+	Tick    uint32  `json:"tick"`
+	Seconds float64 `json:"seconds"` // Filled in from the timeline measures
+	Name    string  `json:"name"`
+}
+
+// extractMidiSections finds the [section ...] and [prc_...] text events of the
+// EVENTS track
+func extractMidiSections(smfData *smf.SMF) []Section {
+	sections := []Section{}
+
+	for _, track := range smfData.Tracks {
+		if getTrackName(track) != "EVENTS" {
+			continue
+		}
+
+		var currentTime uint32
+		for _, event := range track {
+			currentTime += event.Delta
+
+			var text string
+			if !event.Message.GetMetaText(&text) {
+				continue
+			}
+
+			if eventType, name := ParseChartEvent(text); eventType == ChartEventSection && name != "" {
+				sections = append(sections, Section{Tick: currentTime, Name: name})
+			}
+		}
+	}
+
+	return sections
+}
+
+// extractChartSections returns the section global events of the chart
+func extractChartSections(chart *ChartFile) []Section {
+	sections := []Section{}
+
+	for _, event := range chart.GlobalEventsOfType(ChartEventSection) {
+		if event.Value != "" {
+			sections = append(sections, Section{Tick: event.Tick, Name: event.Value})
+		}
+	}
+
+	return sections
+}
+
+// setSections stores the sections on the timeline, timing each one from the
+// measure it falls in and labeling that measure with the section name. When
+// several sections start in the same measure the first one labels it.
+func (t *Timeline) setSections(sections []Section) {
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].Tick < sections[j].Tick
+	})
+
+	for i := range sections {
+		section := &sections[i]
+
+		measureIdx := -1
+		for j, measure := range t.Measures {
+			if section.Tick >= measure.StartTime && section.Tick < measure.EndTime {
+				measureIdx = j
+				break
+			}
+		}
+
+		if measureIdx < 0 {
+			// Past the last measure, pin it to the end of the song
+			if len(t.Measures) > 0 {
+				section.Seconds = t.Measures[len(t.Measures)-1].EndTimeSeconds
+			}
+			continue
+		}
+
+		measure := &t.Measures[measureIdx]
+		fraction := float64(section.Tick-measure.StartTime) / float64(measure.EndTime-measure.StartTime)
+		section.Seconds = measure.StartTimeSeconds + fraction*(measure.EndTimeSeconds-measure.StartTimeSeconds)
+
+		if measure.Section == "" {
+			measure.Section = section.Name
+		}
+	}
+
+	t.Sections = sections
+}
+
+// addSectionMarkers merges the sections into a track as marker meta events,
+// keeping the end of track event last
+func addSectionMarkers(track smf.Track, sections []Section) smf.Track {
+	type timedEvent struct {
+		time    uint32
+		message smf.Message
+	}
+
+	var events []timedEvent
+	var currentTime uint32
+	for _, event := range track {
+		currentTime += event.Delta
+		if event.Message.Is(smf.MetaEndOfTrackMsg) {
+			continue
+		}
+		events = append(events, timedEvent{currentTime, event.Message})
+	}
+
+	for _, section := range sections {
+		events = append(events, timedEvent{section.Tick, smf.Message(smf.MetaMarker(section.Name))})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time < events[j].time
+	})
+
+	var result smf.Track
+	var lastTime uint32
+	for _, event := range events {
+		result = append(result, smf.Event{Delta: event.time - lastTime, Message: event.message})
+		lastTime = event.time
+	}
+	result = append(result, smf.Event{Delta: 0, Message: smf.EOT})
+
+	return result
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// SongSlice is a range of whole measures cut out of a song, such as a solo
+// used as a practice loop. Sliced songs start at tick 0 of their first measure.
+type SongSlice struct {
+	StartMeasure int     `json:"start_measure"` // 1-based, inclusive
+	EndMeasure   int     `json:"end_measure"`   // 1-based, inclusive
+	StartSeconds float64 `json:"start_seconds"` // Position of the slice start in the song audio
+	EndSeconds   float64 `json:"end_seconds"`
+}
+
+// ResolveSongSlice turns the -from and -to options into a range of measures of
+// the timeline. Each value is a measure number ("12"), a time in the audio
+// ("45.5s") or a section name ("verse_1"). Times are rounded out to the
+// measures containing them and a section runs until the next one starts. An
+// empty from starts at the first measure, an empty to ends at the last.
+func ResolveSongSlice(timeline *Timeline, from, to string) (*SongSlice, error) {
+	if timeline == nil || len(timeline.Measures) == 0 {
+		return nil, fmt.Errorf("song has no measures to slice")
+	}
+
+	startIdx := 0
+	if from != "" {
+		idx, err := resolveSliceBound(timeline, from, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -from: %w", err)
+		}
+		startIdx = idx
+	}
+
+	endIdx := len(timeline.Measures) - 1
+	if to != "" {
+		idx, err := resolveSliceBound(timeline, to, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -to: %w", err)
+		}
+		endIdx = idx
+	}
+
+	if endIdx < startIdx {
+		return nil, fmt.Errorf("slice ends at measure %d before it starts at measure %d", endIdx+1, startIdx+1)
+	}
+
+	return &SongSlice{
+		StartMeasure: startIdx + 1,
+		EndMeasure:   endIdx + 1,
+		StartSeconds: timeline.Measures[startIdx].StartTimeSeconds,
+		EndSeconds:   timeline.Measures[endIdx].EndTimeSeconds,
+	}, nil
+}
+
+// resolveSliceBound returns the 0-based index of the first (or last, when isEnd
+// is set) measure selected by a -from or -to value
+func resolveSliceBound(timeline *Timeline, value string, isEnd bool) (int, error) {
+	measures := timeline.Measures
+
+	if number, err := strconv.Atoi(value); err == nil {
+		if number < 1 || number > len(measures) {
+			return 0, fmt.Errorf("measure %d out of range (song has %d measures)", number, len(measures))
+		}
+		return number - 1, nil
+	}
+
+	if strings.HasSuffix(value, "s") {
+		if seconds, err := strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64); err == nil {
+			for i, measure := range measures {
+				if isEnd && seconds > measure.StartTimeSeconds && seconds <= measure.EndTimeSeconds {
+					return i, nil
+				}
+				if !isEnd && seconds >= measure.StartTimeSeconds && seconds < measure.EndTimeSeconds {
+					return i, nil
+				}
+			}
+			if seconds < measures[0].StartTimeSeconds {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("%.3fs is past the end of the song (%.3fs)", seconds, measures[len(measures)-1].EndTimeSeconds)
+		}
+	}
+
+	for i, section := range timeline.Sections {
+		if !strings.EqualFold(section.Name, value) {
+			continue
+		}
+
+		if !isEnd {
+			return measureIndexAtTick(timeline, section.Tick), nil
+		}
+		if i+1 < len(timeline.Sections) && timeline.Sections[i+1].Tick > 0 {
+			return measureIndexAtTick(timeline, timeline.Sections[i+1].Tick-1), nil
+		}
+		return len(measures) - 1, nil
+	}
+
+	return 0, fmt.Errorf("%q is not a measure number, time in seconds (such as 45.5s) or section name", value)
+}
+
+// measureIndexAtTick returns the index of the measure containing the tick,
+// clamped to the measures of the timeline
+func measureIndexAtTick(timeline *Timeline, tick uint32) int {
+	for i, measure := range timeline.Measures {
+		if tick < measure.EndTime {
+			return i
+		}
+	}
+	return len(timeline.Measures) - 1
+}
+
+// AudioTrimStart returns where the audio of the slice should be cut. Audio can't
+// be cut before it begins, so slices starting in the silence before a late audio
+// start keep it.
+func (s *SongSlice) AudioTrimStart() float64 {
+	return math.Max(s.StartSeconds, 0)
+}
+
+// AudioOffset returns the audio offset of the sliced song once its audio is cut
+// at AudioTrimStart
+func (s *SongSlice) AudioOffset() float64 {
+	return s.StartSeconds - s.AudioTrimStart()
+}
+
+// tickRange returns the ticks covered by the slice in the given timeline
+func (s *SongSlice) tickRange(timeline *Timeline) (uint32, uint32, error) {
+	if s.StartMeasure < 1 || s.EndMeasure > len(timeline.Measures) || s.EndMeasure < s.StartMeasure {
+		return 0, 0, fmt.Errorf("measures %d-%d out of range (song has %d measures)", s.StartMeasure, s.EndMeasure, len(timeline.Measures))
+	}
+	return timeline.Measures[s.StartMeasure-1].StartTime, timeline.Measures[s.EndMeasure-1].EndTime, nil
+}
+
+// SliceMidi cuts the measures of the slice out of the MIDI file. Each track
+// starts with the tempo, time signature, key signature and section in effect at
+// the start of the slice, notes held over the start are restarted at tick 0 and
+// notes held past the end are cut off there.
+func SliceMidi(smfData *smf.SMF, slice *SongSlice) (*smf.SMF, error) {
+	timeline, err := (&MidiFile{SMF: smfData}).GetTimeline()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeline: %w", err)
+	}
+
+	start, end, err := slice.tickRange(timeline)
+	if err != nil {
+		return nil, err
+	}
+
+	result := smf.NewSMF1()
+	result.TimeFormat = smfData.TimeFormat
+	for _, track := range smfData.Tracks {
+		result.Add(sliceMidiTrack(track, start, end))
+	}
+
+	return result, nil
+}
+
+// sliceMidiTrack keeps the events of a single track between start and end
+func sliceMidiTrack(track smf.Track, start, end uint32) smf.Track {
+	type timedMessage struct {
+		time uint32
+		msg  smf.Message
+	}
+	type noteKey struct {
+		channel uint8
+		key     uint8
+	}
+
+	var header []smf.Message
+	state := make(map[string]smf.Message) // Latest state events before the start, by kind
+	stateOrder := []string{"tempo", "time_sig", "key_sig", "section"}
+
+	held := make(map[noteKey]smf.Message) // Notes sounding at the start
+	var heldOrder []noteKey
+	active := make(map[noteKey]bool) // Notes sounding inside the slice
+	var activeOrder []noteKey
+
+	var events []timedMessage
+	var currentTime uint32
+
+	// Notes held over the start are restarted at tick 0 and sound in the slice
+	var restarts []smf.Message
+	entered := false
+	enterSlice := func() {
+		entered = true
+		for _, note := range heldOrder {
+			if msg, exists := held[note]; exists {
+				restarts = append(restarts, msg)
+				activeOrder = append(activeOrder, note)
+				active[note] = true
+			}
+		}
+	}
+
+	for _, event := range track {
+		currentTime += event.Delta
+		msg := event.Message
+		if msg.Is(smf.MetaEndOfTrackMsg) {
+			continue
+		}
+
+		var channel, key, velocity uint8
+		isNoteOn := msg.GetNoteOn(&channel, &key, &velocity) && velocity > 0
+		isNoteOff := !isNoteOn && (msg.GetNoteOff(&channel, &key, &velocity) || msg.GetNoteOn(&channel, &key, &velocity))
+		note := noteKey{channel, key}
+
+		stateKind := ""
+		var text string
+		switch {
+		case msg.Is(smf.MetaTempoMsg):
+			stateKind = "tempo"
+		case msg.Is(smf.MetaTimeSigMsg):
+			stateKind = "time_sig"
+		case msg.Is(smf.MetaKeySigMsg):
+			stateKind = "key_sig"
+		case msg.GetMetaText(&text):
+			if eventType, _ := ParseChartEvent(text); eventType == ChartEventSection {
+				stateKind = "section"
+			}
+		}
+
+		if currentTime < start {
+			switch {
+			case isNoteOn:
+				if _, exists := held[note]; !exists {
+					heldOrder = append(heldOrder, note)
+				}
+				held[note] = msg
+			case isNoteOff:
+				if _, exists := held[note]; exists {
+					delete(held, note)
+					for i, heldNote := range heldOrder {
+						if heldNote == note {
+							heldOrder = append(heldOrder[:i], heldOrder[i+1:]...)
+							break
+						}
+					}
+				}
+			case msg.Is(smf.MetaTrackNameMsg):
+				header = append(header, msg)
+			case stateKind != "":
+				state[stateKind] = msg
+			}
+			continue
+		}
+		if !entered {
+			enterSlice()
+		}
+
+		if currentTime > end || (currentTime == end && !(isNoteOff && active[note])) {
+			continue
+		}
+
+		// Events at the start replace the state carried over from before it
+		if currentTime == start && stateKind != "" {
+			delete(state, stateKind)
+		}
+
+		if isNoteOn {
+			if !active[note] {
+				activeOrder = append(activeOrder, note)
+			}
+			active[note] = true
+		} else if isNoteOff {
+			delete(active, note)
+		}
+
+		events = append(events, timedMessage{currentTime - start, msg})
+	}
+
+	if !entered {
+		enterSlice()
+	}
+
+	var result smf.Track
+	for _, msg := range header {
+		result = append(result, smf.Event{Delta: 0, Message: msg})
+	}
+	for _, kind := range stateOrder {
+		if msg, exists := state[kind]; exists {
+			result = append(result, smf.Event{Delta: 0, Message: msg})
+		}
+	}
+
+	for _, msg := range restarts {
+		result = append(result, smf.Event{Delta: 0, Message: msg})
+	}
+
+	var lastTime uint32
+	for _, event := range events {
+		result = append(result, smf.Event{Delta: event.time - lastTime, Message: event.msg})
+		lastTime = event.time
+	}
+
+	// Cut off the notes still sounding at the end of the slice
+	length := end - start
+	for _, note := range activeOrder {
+		if active[note] {
+			result = append(result, smf.Event{Delta: length - lastTime, Message: smf.Message(midi.NoteOff(note.channel, note.key))})
+			lastTime = length
+			active[note] = false
+		}
+	}
+
+	result = append(result, smf.Event{Delta: length - lastTime, Message: smf.EOT})
+	return result
+}
+
+// SliceChart cuts the measures of the slice out of the chart. The sync track
+// starts with the tempo and time signature in effect at the start of the slice,
+// sustains and star power phrases are cut at the end and anchors are dropped.
+func SliceChart(chart *ChartFile, slice *SongSlice) (*ChartFile, error) {
+	timeline, err := chart.GetTimeline()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeline: %w", err)
+	}
+
+	start, end, err := slice.tickRange(timeline)
+	if err != nil {
+		return nil, err
+	}
+	inSlice := func(tick uint32) bool {
+		return tick >= start && tick < end
+	}
+
+	result := &ChartFile{
+		Song:     chart.Song,
+		Filename: chart.Filename,
+		Tracks:   make(map[string]TrackSection),
+	}
+	result.Song.Offset = slice.AudioOffset()
+
+	for i, event := range chart.SyncTrack.BPMEvents {
+		if event.Tick <= start && (i+1 >= len(chart.SyncTrack.BPMEvents) || chart.SyncTrack.BPMEvents[i+1].Tick > start) {
+			event.Tick = 0
+			result.SyncTrack.BPMEvents = append(result.SyncTrack.BPMEvents, event)
+		} else if event.Tick > start && event.Tick < end {
+			event.Tick -= start
+			result.SyncTrack.BPMEvents = append(result.SyncTrack.BPMEvents, event)
+		}
+	}
+
+	for i, event := range chart.SyncTrack.TimeSigEvents {
+		if event.Tick <= start && (i+1 >= len(chart.SyncTrack.TimeSigEvents) || chart.SyncTrack.TimeSigEvents[i+1].Tick > start) {
+			event.Tick = 0
+			result.SyncTrack.TimeSigEvents = append(result.SyncTrack.TimeSigEvents, event)
+		} else if event.Tick > start && event.Tick < end {
+			event.Tick -= start
+			result.SyncTrack.TimeSigEvents = append(result.SyncTrack.TimeSigEvents, event)
+		}
+	}
+
+	// Carry the section playing at the start over to tick 0
+	var currentSection *GlobalEvent
+	for _, event := range chart.Events.GlobalEvents {
+		if event.Tick < start && event.Type == ChartEventSection {
+			section := event
+			currentSection = &section
+		}
+		if event.Tick == start && event.Type == ChartEventSection {
+			currentSection = nil
+		}
+	}
+	if currentSection != nil {
+		currentSection.Tick = 0
+		result.Events.GlobalEvents = append(result.Events.GlobalEvents, *currentSection)
+	}
+
+	for _, event := range chart.Events.GlobalEvents {
+		if inSlice(event.Tick) {
+			event.Tick -= start
+			result.Events.GlobalEvents = append(result.Events.GlobalEvents, event)
+		}
+	}
+
+	for name, track := range chart.Tracks {
+		sliced := TrackSection{Name: track.Name}
+
+		for _, note := range track.Notes {
+			if !inSlice(note.Tick) {
+				continue
+			}
+			if note.Tick+note.Sustain > end {
+				note.Sustain = end - note.Tick
+			}
+			note.Tick -= start
+			sliced.Notes = append(sliced.Notes, note)
+		}
+
+		for _, special := range track.Specials {
+			specialEnd := special.Tick + special.Length
+			if specialEnd <= start || special.Tick >= end {
+				continue
+			}
+			if special.Tick < start {
+				special.Tick = start
+			}
+			if specialEnd > end {
+				specialEnd = end
+			}
+			special.Length = specialEnd - special.Tick
+			special.Tick -= start
+			sliced.Specials = append(sliced.Specials, special)
+		}
+
+		for _, event := range track.TrackEvents {
+			if inSlice(event.Tick) {
+				event.Tick -= start
+				sliced.TrackEvents = append(sliced.TrackEvents, event)
+			}
+		}
+
+		result.Tracks[name] = sliced
+	}
+
+	return result, nil
+}
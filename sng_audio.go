@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Audio formats supported by ExportAudio
+const (
+	AudioFormatOgg  = "ogg"
+	AudioFormatWav  = "wav"
+	AudioFormatFlac = "flac"
+	AudioFormatMp3  = "mp3"
+)
+
+// AudioFormatEncodings holds the encoding used for each export format
+var AudioFormatEncodings = map[string]AudioEncoding{
+	AudioFormatOgg:  {Codec: "libvorbis", Quality: "6", SampleRate: 44100, Channels: 2},
+	AudioFormatWav:  {Codec: "pcm_s16le", SampleRate: 44100, Channels: 2},
+	AudioFormatFlac: {Codec: "flac", SampleRate: 44100, Channels: 2},
+	AudioFormatMp3:  {Codec: "libmp3lame", Quality: "2", SampleRate: 44100, Channels: 2},
+}
+
+// StreamingLoudnessTarget is the integrated loudness in LUFS most streaming
+// services play at, a common target for normalizing merged audio
+const StreamingLoudnessTarget = -14.0
+
+// loudnessPeakCeiling is the true peak in dBTP normalization may raise a mix to
+// before it would clip
+const loudnessPeakCeiling = -1.0
+
+// loudnessSilence is the integrated loudness in LUFS below which a mix is
+// considered silent and left at its level
+const loudnessSilence = -70.0
+
+// AudioMixOptions controls the levels when stems are mixed
+type AudioMixOptions struct {
+	LoudnessTarget float64            // Integrated loudness of the mix in LUFS, 0 keeps the original level
+	StemGains      map[string]float64 // Decibels added to stems before mixing, by stem name or group
+}
+
+// stemGain returns the gain for the stem, preferring its name over its group
+func (o AudioMixOptions) stemGain(stem AudioStem) float64 {
+	if gain, ok := o.StemGains[stem.Name]; ok {
+		return gain
+	}
+	return o.StemGains[stem.Group()]
+}
+
+// ParseStemGains reads a comma separated list of stem gains in decibels, such as
+// "drums=-3,vocals=+2"
+func ParseStemGains(value string) (map[string]float64, error) {
+	gains := make(map[string]float64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, db, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid stem gain %q (expected name=dB)", entry)
+		}
+		gain, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(db)), "db"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gain for stem %q: %w", name, err)
+		}
+		gains[strings.ToLower(strings.TrimSpace(name))] = gain
+	}
+	return gains, nil
+}
+
+// audioStemExtensions lists the file extensions of audio stems in SNG packages
+var audioStemExtensions = []string{".opus", ".ogg", ".mp3", ".wav", ".flac"}
+
+// AudioStem is a single audio file of an SNG package, such as the drums or the
+// backing track
+type AudioStem struct {
+	Name     string `json:"name"`     // Stem name, such as "guitar" or "drums_2"
+	Filename string `json:"filename"` // File in the SNG package
+}
+
+// Group returns the instrument of the stem with any numbered suffix removed,
+// so "drums_2" belongs to "drums"
+func (a AudioStem) Group() string {
+	if idx := strings.LastIndex(a.Name, "_"); idx > 0 {
+		suffix := a.Name[idx+1:]
+		if suffix != "" && strings.Trim(suffix, "0123456789") == "" {
+			return a.Name[:idx]
+		}
+	}
+	return a.Name
+}
+
+// chartStemNames maps the audio file names of the chart *Stream fields, without
+// extension, to stem names
+func chartStemNames(song SongSection) map[string]string {
+	names := make(map[string]string)
+	streams := []struct {
+		file string
+		name string
+	}{
+		{song.MusicStream, "song"},
+		{song.GuitarStream, "guitar"},
+		{song.RhythmStream, "rhythm"},
+		{song.BassStream, "bass"},
+		{song.DrumStream, "drums"},
+		{song.Drum2Stream, "drums_2"},
+		{song.Drum3Stream, "drums_3"},
+		{song.Drum4Stream, "drums_4"},
+		{song.VocalStream, "vocals"},
+		{song.KeysStream, "keys"},
+		{song.CrowdStream, "crowd"},
+	}
+	for _, stream := range streams {
+		if stream.file != "" {
+			base := filepath.Base(stream.file)
+			names[strings.TrimSuffix(base, filepath.Ext(base))] = stream.name
+		}
+	}
+	return names
+}
+
+// AudioStems returns the audio files of the package, excluding the preview. Stems
+// are named after the chart *Stream field pointing at them when the package has a
+// notes.chart, otherwise after the file name ("drums_1.opus" is "drums_1").
+func (s *SngFile) AudioStems() []AudioStem {
+	var streamNames map[string]string
+	if chartData, err := s.ReadFile("notes.chart"); err == nil {
+		if chartFile, err := ParseChartFile(bytes.NewReader(chartData)); err == nil {
+			streamNames = chartStemNames(chartFile.Song)
+		}
+	}
+
+	var stems []AudioStem
+	for _, filename := range s.ListFiles() {
+		ext := strings.ToLower(filepath.Ext(filename))
+		isAudio := false
+		for _, audioExt := range audioStemExtensions {
+			if ext == audioExt {
+				isAudio = true
+			}
+		}
+		if !isAudio || strings.Contains(strings.ToLower(filename), "preview") {
+			continue
+		}
+
+		base := strings.TrimSuffix(filename, filepath.Ext(filename))
+		name, ok := streamNames[base]
+		if !ok {
+			name = strings.ToLower(base)
+		}
+		stems = append(stems, AudioStem{Name: name, Filename: filename})
+	}
+
+	return stems
+}
+
+// SelectAudioStems picks stems by a comma separated list of stem names or
+// groups ("guitar,bass" or "drums"). Names prefixed with - are excluded instead,
+// so "-drums" selects everything except the drums. An empty selection keeps all
+// stems.
+func SelectAudioStems(stems []AudioStem, selection string) ([]AudioStem, error) {
+	var include, exclude []string
+	for _, name := range strings.Split(selection, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if strings.HasPrefix(name, "-") {
+			exclude = append(exclude, strings.TrimPrefix(name, "-"))
+		} else if name != "" {
+			include = append(include, name)
+		}
+	}
+
+	matches := func(stem AudioStem, name string) bool {
+		return stem.Name == name || stem.Group() == name
+	}
+
+	for _, name := range append(append([]string{}, include...), exclude...) {
+		found := false
+		for _, stem := range stems {
+			if matches(stem, name) {
+				found = true
+			}
+		}
+		if !found {
+			var available []string
+			for _, stem := range stems {
+				available = append(available, stem.Name)
+			}
+			return nil, fmt.Errorf("unknown stem %q (available: %s)", name, strings.Join(available, ", "))
+		}
+	}
+
+	var selected []AudioStem
+	for _, stem := range stems {
+		keep := len(include) == 0
+		for _, name := range include {
+			if matches(stem, name) {
+				keep = true
+			}
+		}
+		for _, name := range exclude {
+			if matches(stem, name) {
+				keep = false
+			}
+		}
+		if keep {
+			selected = append(selected, stem)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no stems left in selection %q", selection)
+	}
+
+	return selected, nil
+}
+
+// ExportAudio mixes the stems into a single file with the encoding of one of
+// the AudioFormatEncodings. The audio is trimmed to the slice when one is set.
+func (s *SngFile) ExportAudio(stems []AudioStem, encoding AudioEncoding, outputPath string) error {
+	if err := s.AudioProcessor().Validate(); err != nil {
+		return fmt.Errorf("audio export failed: %w", err)
+	}
+
+	return s.mixAudioStems(stems, outputPath, encoding)
+}
+
+// DecodeAudio mixes the stems down to mono samples for analysis, trimmed to the
+// slice when one is set. Returns the samples and their sample rate.
+func (s *SngFile) DecodeAudio(stems []AudioStem) ([]float64, int, error) {
+	if err := s.AudioProcessor().Validate(); err != nil {
+		return nil, 0, fmt.Errorf("audio decode failed: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "sng-audio-decode-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "decoded.wav")
+	if err := s.mixAudioStems(stems, outputPath, AnalysisAudioEncoding); err != nil {
+		return nil, 0, fmt.Errorf("audio decode failed: %w", err)
+	}
+
+	file, err := os.Open(outputPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open decoded audio: %w", err)
+	}
+	defer file.Close()
+
+	return readWav(bufio.NewReader(file))
+}
+
+// MeasureLoudness extracts the stem and measures its loudness with the audio
+// backend
+func (s *SngFile) MeasureLoudness(stem AudioStem) (*LoudnessStats, error) {
+	tempDir, err := os.MkdirTemp("", "sng-audio-loudness-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	inputPath, err := s.extractAudioStem(stem, tempDir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := s.AudioProcessor().Loudness(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure loudness of %s: %w", stem.Filename, err)
+	}
+	return stats, nil
+}
+
+// extractAudioStem writes the stem to a numbered file in dir and returns its path
+func (s *SngFile) extractAudioStem(stem AudioStem, dir string, index int) (string, error) {
+	audioData, err := s.ReadFile(stem.Filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", stem.Filename, err)
+	}
+
+	// Validate that we actually got some audio data
+	if len(audioData) == 0 {
+		return "", fmt.Errorf("audio file %s is empty", stem.Filename)
+	}
+
+	inputPath := filepath.Join(dir, fmt.Sprintf("input_%d%s", index, filepath.Ext(stem.Filename)))
+	if err := os.WriteFile(inputPath, audioData, 0644); err != nil {
+		return "", fmt.Errorf("failed to write temp file for %s: %w", stem.Filename, err)
+	}
+
+	log.Printf("Extracted %s (%d bytes) to %s", stem.Filename, len(audioData), inputPath)
+	return inputPath, nil
+}
+
+// mixAudioStems extracts the stems to a temporary directory and mixes them down
+// to outputPath, applying the gains of the mix options to each stem. Slices,
+// normalized mixes and mixes of several stems go through intermediate lossless
+// files, trimmed and then brought to the loudness target or below the peak
+// ceiling.
+func (s *SngFile) mixAudioStems(stems []AudioStem, outputPath string, encoding AudioEncoding) error {
+	if len(stems) == 0 {
+		return fmt.Errorf("no audio stems to mix")
+	}
+
+	tempDir, err := os.MkdirTemp("", "sng-audio-stems-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	processor := s.AudioProcessor()
+
+	// Extract all stems to temp directory
+	var inputPaths []string
+	for i, stem := range stems {
+		inputPath, err := s.extractAudioStem(stem, tempDir, i)
+		if err != nil {
+			return err
+		}
+
+		if gain := s.mix.stemGain(stem); gain != 0 {
+			gainPath := filepath.Join(tempDir, fmt.Sprintf("gain_%d.wav", i))
+			log.Printf("Applying %+.2f dB to %s", gain, stem.Name)
+			if err := processor.Gain(inputPath, gainPath, gain, MixAudioEncoding); err != nil {
+				return fmt.Errorf("failed to change the level of %s: %w", stem.Name, err)
+			}
+			inputPath = gainPath
+		}
+		inputPaths = append(inputPaths, inputPath)
+	}
+
+	// Stems summed at their full levels can clip, so without a loudness target
+	// the peak of a merged mix is still checked
+	normalize := s.mix.LoudnessTarget != 0
+	checkPeak := !normalize && len(inputPaths) > 1
+	if s.slice == nil && !normalize && !checkPeak {
+		return processor.Merge(inputPaths, outputPath, encoding)
+	}
+
+	mixPath := filepath.Join(tempDir, "mix.wav")
+	if err := processor.Merge(inputPaths, mixPath, MixAudioEncoding); err != nil {
+		return err
+	}
+
+	if s.slice != nil {
+		trimStart := s.slice.AudioTrimStart()
+		duration := s.slice.EndSeconds - trimStart
+		if duration <= 0 {
+			return fmt.Errorf("slice ends before the audio starts")
+		}
+		if !normalize && !checkPeak {
+			return processor.Trim(mixPath, outputPath, trimStart, duration, encoding)
+		}
+
+		trimPath := filepath.Join(tempDir, "trim.wav")
+		if err := processor.Trim(mixPath, trimPath, trimStart, duration, MixAudioEncoding); err != nil {
+			return err
+		}
+		mixPath = trimPath
+	}
+
+	if !normalize {
+		return s.limitMixPeak(mixPath, outputPath, encoding)
+	}
+	return s.normalizeLoudness(mixPath, outputPath, encoding)
+}
+
+// limitMixPeak keeps the level of the input unless its true peak is above
+// loudnessPeakCeiling, in which case it is turned down to the ceiling
+func (s *SngFile) limitMixPeak(inputPath, outputPath string, encoding AudioEncoding) error {
+	processor := s.AudioProcessor()
+	stats, err := processor.Loudness(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to measure mix peak: %w", err)
+	}
+
+	if stats.TruePeak <= loudnessPeakCeiling {
+		return processor.Transcode(inputPath, outputPath, encoding)
+	}
+
+	gain := loudnessPeakCeiling - stats.TruePeak
+	log.Printf("Warning: mix peaks at %.1f dBTP, lowering it %.2f dB to keep the peak below %.1f dBTP", stats.TruePeak, -gain, loudnessPeakCeiling)
+	return processor.Gain(inputPath, outputPath, gain, encoding)
+}
+
+// normalizeLoudness brings the input to the loudness target of the mix options,
+// limiting the gain so the true peak stays below loudnessPeakCeiling
+func (s *SngFile) normalizeLoudness(inputPath, outputPath string, encoding AudioEncoding) error {
+	processor := s.AudioProcessor()
+	stats, err := processor.Loudness(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to measure mix loudness: %w", err)
+	}
+
+	if stats.Integrated <= loudnessSilence {
+		log.Printf("Mix is silent (%.1f LUFS), keeping its level", stats.Integrated)
+		return processor.Transcode(inputPath, outputPath, encoding)
+	}
+
+	gain := s.mix.LoudnessTarget - stats.Integrated
+	if stats.TruePeak+gain > loudnessPeakCeiling {
+		limited := loudnessPeakCeiling - stats.TruePeak
+		log.Printf("Warning: limiting gain to %+.2f dB to keep the peak below %.1f dBTP, the mix will be quieter than %.1f LUFS", limited, loudnessPeakCeiling, s.mix.LoudnessTarget)
+		gain = limited
+	}
+
+	log.Printf("Normalizing mix from %.1f LUFS to %.1f LUFS (%+.2f dB)", stats.Integrated, stats.Integrated+gain, gain)
+	return processor.Gain(inputPath, outputPath, gain, encoding)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChartStemNames(t *testing.T) {
+	names := chartStemNames(SongSection{
+		MusicStream: "song.ogg",
+		DrumStream:  "audio/drums_1.ogg",
+		Drum2Stream: "drums_2.ogg",
+		CrowdStream: "",
+	})
+
+	expected := map[string]string{
+		"song":    "song",
+		"drums_1": "drums",
+		"drums_2": "drums_2",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for file, name := range expected {
+		if names[file] != name {
+			t.Errorf("expected %s to be named %q, got %q", file, name, names[file])
+		}
+	}
+}
+
+func TestSelectAudioStems(t *testing.T) {
+	stems := []AudioStem{
+		{Name: "song", Filename: "song.opus"},
+		{Name: "guitar", Filename: "guitar.opus"},
+		{Name: "bass", Filename: "bass.opus"},
+		{Name: "drums_1", Filename: "drums_1.opus"},
+		{Name: "drums_2", Filename: "drums_2.opus"},
+		{Name: "vocals", Filename: "vocals.opus"},
+	}
+
+	tests := []struct {
+		selection string
+		expected  string
+	}{
+		{"", "song,guitar,bass,drums_1,drums_2,vocals"},
+		{"-drums", "song,guitar,bass,vocals"},
+		{"guitar, Bass", "guitar,bass"},
+		{"drums,-drums_2", "drums_1"},
+		{"-vocals,-drums_1", "song,guitar,bass,drums_2"},
+	}
+	for _, tt := range tests {
+		selected, err := SelectAudioStems(stems, tt.selection)
+		if err != nil {
+			t.Errorf("SelectAudioStems(%q) failed: %v", tt.selection, err)
+			continue
+		}
+		var names []string
+		for _, stem := range selected {
+			names = append(names, stem.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.expected {
+			t.Errorf("SelectAudioStems(%q): expected %s, got %s", tt.selection, tt.expected, got)
+		}
+	}
+
+	if _, err := SelectAudioStems(stems, "keys"); err == nil || !strings.Contains(err.Error(), "drums_1") {
+		t.Errorf("expected an unknown stem error listing the available stems, got %v", err)
+	}
+	if _, err := SelectAudioStems(stems, "guitar,-guitar"); err == nil {
+		t.Errorf("expected an error for an empty selection")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register GIF for image.DecodeConfig
+	_ "image/jpeg" // Register JPEG for image.DecodeConfig
+	_ "image/png"  // Register PNG for image.DecodeConfig
+	"path/filepath"
+	"strings"
+)
+
+// Image kinds, from the file name in the package
+const (
+	ImageKindAlbum      = "album"      // album.jpg, cover art shown in song lists
+	ImageKindBackground = "background" // background.*, shown behind the highway
+	ImageKindHighway    = "highway"    // highway.*, texture of the note highway
+	ImageKindOther      = "other"      // Any other image
+)
+
+// Image formats detected from the file contents
+const (
+	ImageFormatJPEG = "jpeg"
+	ImageFormatPNG  = "png"
+	ImageFormatGIF  = "gif"
+	ImageFormatWebP = "webp"
+	ImageFormatBMP  = "bmp"
+)
+
+// imageExtensions lists the file extensions of images in SNG packages
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp"}
+
+// SngImage describes an image file of an SNG package
+type SngImage struct {
+	Kind     string `json:"kind"` // One of the ImageKind values
+	Filename string `json:"filename"`
+	Format   string `json:"format"` // One of the ImageFormat values, empty when unknown
+	Width    int    `json:"width"`  // Zero when the format can't be decoded
+	Height   int    `json:"height"`
+	Size     uint64 `json:"size"` // Bytes
+}
+
+// detectImageFormat identifies the image format from the leading bytes of the
+// file, ignoring its extension
+func detectImageFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return ImageFormatJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ImageFormatPNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return ImageFormatGIF
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return ImageFormatWebP
+	case bytes.HasPrefix(data, []byte("BM")):
+		return ImageFormatBMP
+	}
+	return ""
+}
+
+// imageKind returns the kind of image from the file name
+func imageKind(filename string) string {
+	base := strings.ToLower(strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)))
+	switch {
+	case base == "album":
+		return ImageKindAlbum
+	case strings.HasPrefix(base, "background"):
+		return ImageKindBackground
+	case base == "highway":
+		return ImageKindHighway
+	}
+	return ImageKindOther
+}
+
+// Images returns the image files of the package with their format and
+// dimensions
+func (s *SngFile) Images() []SngImage {
+	var images []SngImage
+	for _, entry := range s.Files {
+		ext := strings.ToLower(filepath.Ext(entry.Filename))
+		isImage := false
+		for _, imageExt := range imageExtensions {
+			if ext == imageExt {
+				isImage = true
+			}
+		}
+		if !isImage {
+			continue
+		}
+
+		image, _, err := s.readImage(entry.Filename)
+		if err != nil {
+			image = &SngImage{Kind: imageKind(entry.Filename), Filename: entry.Filename, Size: entry.Size}
+		}
+		images = append(images, *image)
+	}
+	return images
+}
+
+// AlbumArt returns the album art of the package and its data. Returns an error
+// when the package has none.
+func (s *SngFile) AlbumArt() (*SngImage, []byte, error) {
+	return s.imageOfKind(ImageKindAlbum)
+}
+
+// Background returns the background image of the package and its data. Returns
+// an error when the package has none.
+func (s *SngFile) Background() (*SngImage, []byte, error) {
+	return s.imageOfKind(ImageKindBackground)
+}
+
+// imageOfKind reads the first image of the given kind
+func (s *SngFile) imageOfKind(kind string) (*SngImage, []byte, error) {
+	for _, image := range s.Images() {
+		if image.Kind == kind {
+			return s.readImage(image.Filename)
+		}
+	}
+	return nil, nil, fmt.Errorf("no %s image found in SNG package", kind)
+}
+
+// readImage reads an image file of the package and decodes its dimensions
+// when the format is supported
+func (s *SngFile) readImage(filename string) (*SngImage, []byte, error) {
+	data, err := s.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &SngImage{
+		Kind:     imageKind(filename),
+		Filename: filename,
+		Format:   detectImageFormat(data),
+		Size:     uint64(len(data)),
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		info.Width = config.Width
+		info.Height = config.Height
+	}
+
+	return info, data, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodeTestImage encodes a blank image of the given size as PNG or JPEG
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	var err error
+	if format == ImageFormatPNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s image: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestSngFileImages(t *testing.T) {
+	sngFile := writeTestSngFile(t, map[string]string{"name": "Test Song"}, []sngTestFile{
+		{"notes.chart", []byte(validChartData)},
+		{"song.opus", []byte("song audio")},
+		// The extension is wrong, the format comes from the contents
+		{"album.jpg", encodeTestImage(t, ImageFormatPNG, 64, 48)},
+		{"background.jpg", encodeTestImage(t, ImageFormatJPEG, 320, 180)},
+		{"highway.webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")},
+	})
+
+	images := sngFile.Images()
+	expected := []SngImage{
+		{Kind: ImageKindAlbum, Filename: "album.jpg", Format: ImageFormatPNG, Width: 64, Height: 48},
+		{Kind: ImageKindBackground, Filename: "background.jpg", Format: ImageFormatJPEG, Width: 320, Height: 180},
+		{Kind: ImageKindHighway, Filename: "highway.webp", Format: ImageFormatWebP},
+	}
+	if len(images) != len(expected) {
+		t.Fatalf("expected %d images, got %+v", len(expected), images)
+	}
+	for i, want := range expected {
+		got := images[i]
+		want.Size = got.Size
+		if got != want || got.Size == 0 {
+			t.Errorf("image %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	albumArt, data, err := sngFile.AlbumArt()
+	if err != nil || albumArt.Width != 64 || detectImageFormat(data) != ImageFormatPNG {
+		t.Errorf("expected the PNG album art, got %+v (%v)", albumArt, err)
+	}
+	if background, _, err := sngFile.Background(); err != nil || background.Filename != "background.jpg" {
+		t.Errorf("expected the background image, got %+v (%v)", background, err)
+	}
+
+	// ToneLib songs have no known entry for album art, none is written
+	sngFile.SetAudioProcessor(&recordingAudioProcessor{})
+	var buf bytes.Buffer
+	if err := WriteToneLibSongTo(&buf, sngFile); err != nil {
+		t.Fatalf("WriteToneLibSongTo failed: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to read song archive: %v", err)
+	}
+	for _, file := range archive.File {
+		switch file.Name {
+		case "version.info", "the_song.dat", ToneLibAudioDataFile:
+		default:
+			t.Errorf("expected only the score and audio in the song archive, found %s", file.Name)
+		}
+	}
+}
+
+func TestSngFileWithoutImages(t *testing.T) {
+	sngFile := createTestSngFile(t)
+
+	if images := sngFile.Images(); len(images) != 0 {
+		t.Errorf("expected no images, got %+v", images)
+	}
+	if _, _, err := sngFile.AlbumArt(); err == nil {
+		t.Errorf("expected an error without album art")
+	}
+}
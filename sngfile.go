@@ -50,9 +50,9 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
 )
 
 const (
@@ -99,6 +99,12 @@ type SngFile struct {
 	Metadata SngMetadata    // Song metadata key-value pairs
 	Files    []SngFileEntry // Index of contained files
 	reader   *os.File       // File reader for accessing file data
+	dir      string         // Song folder the files are read from instead, see OpenSongFolder
+
+	audioOffset *float64        // Overrides the offset derived from delay and chart Offset
+	slice       *SongSlice      // Measures to keep, set with SetSlice
+	audio       AudioProcessor  // Backend for audio exports, DefaultAudioProcessor when nil
+	mix         AudioMixOptions // Levels for mixed audio, set with SetMixOptions
 }
 
 // OpenSngFile opens an SNG file for reading and parses its header, metadata, and file index.
@@ -283,6 +289,10 @@ func (s *SngFile) ReadFile(filename string) ([]byte, error) {
 		return nil, fmt.Errorf("file not found: %s", filename)
 	}
 
+	if s.dir != "" {
+		return os.ReadFile(filepath.Join(s.dir, entry.Filename))
+	}
+
 	if _, err := s.reader.Seek(int64(entry.Offset), io.SeekStart); err != nil {
 		return nil, err
 	}
@@ -333,145 +343,141 @@ func (s *SngFile) GetMetadata() map[string]string {
 	return result
 }
 
-// validateFFmpeg checks if ffmpeg is available and has required codecs
-func validateFFmpeg() error {
-	// Check if ffmpeg is available in PATH
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		return fmt.Errorf("ffmpeg is not available in PATH: %w", err)
+// GetAudioOffset returns how many seconds of audio play before tick 0 of the chart.
+// It combines the song.ini "delay" metadata (milliseconds) with the Offset field of
+// notes.chart when the package has no notes.mid, unless overridden with SetAudioOffset.
+func (s *SngFile) GetAudioOffset() float64 {
+	if s.audioOffset != nil {
+		return *s.audioOffset
 	}
 
-	// Test ffmpeg with a simple command to verify it's working
-	cmd := exec.Command("ffmpeg", "-version")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	var offset float64
+	if delay, ok := songIniDelay(s.Metadata); ok {
+		offset += delay
+	}
 
-	if err := cmd.Run(); err != nil {
-		stderrStr := strings.TrimSpace(stderr.String())
-		if stderrStr != "" {
-			return fmt.Errorf("ffmpeg validation failed: %w\nFFmpeg stderr: %s", err, stderrStr)
+	if !s.hasFile("notes.mid") {
+		if chartData, err := s.ReadFile("notes.chart"); err == nil {
+			if chartFile, err := ParseChartFile(bytes.NewReader(chartData)); err == nil {
+				offset += chartFile.Song.Offset
+			}
 		}
-		return fmt.Errorf("ffmpeg validation failed: %w", err)
 	}
 
-	return nil
+	return offset
 }
 
-// GetMergedAudio processes all opus files in the SNG and returns a merged audio file.
-// Returns error if no opus files found or if merge fails - no fallback.
-func (s *SngFile) GetMergedAudio() (*MergedAudio, error) {
-	// Validate ffmpeg availability before processing
-	if err := validateFFmpeg(); err != nil {
-		return nil, fmt.Errorf("audio merge requires ffmpeg: %w", err)
-	}
-	// Find all opus files in the SNG
-	var opusFiles []string
-	files := s.ListFiles()
-	for _, filename := range files {
-		if strings.HasSuffix(filename, ".opus") && !strings.Contains(strings.ToLower(filename), "preview") {
-			opusFiles = append(opusFiles, filename)
-		}
-	}
+// SetAudioOffset overrides the audio offset derived from the package metadata
+func (s *SngFile) SetAudioOffset(seconds float64) {
+	s.audioOffset = &seconds
+}
 
-	if len(opusFiles) == 0 {
-		return nil, fmt.Errorf("no opus files found in SNG")
+// AudioProcessor returns the backend used to process the audio of the package
+func (s *SngFile) AudioProcessor() AudioProcessor {
+	if s.audio != nil {
+		return s.audio
 	}
+	return DefaultAudioProcessor
+}
 
-	log.Printf("Found %d opus files to merge: %v", len(opusFiles), opusFiles)
+// SetAudioProcessor replaces the backend used to process the audio of the package
+func (s *SngFile) SetAudioProcessor(processor AudioProcessor) {
+	s.audio = processor
+}
 
-	// Create temporary directory for conversion
-	tempDir, err := os.MkdirTemp("", "sng-audio-merge-*")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp directory: %w", err)
-	}
+// MixOptions returns the levels used when stems are mixed
+func (s *SngFile) MixOptions() AudioMixOptions {
+	return s.mix
+}
 
-	// Extract all opus files to temp directory
-	var inputPaths []string
-	for i, filename := range opusFiles {
-		audioData, err := s.ReadFile(filename)
-		if err != nil {
-			os.RemoveAll(tempDir)
-			return nil, fmt.Errorf("failed to read %s: %w", filename, err)
-		}
+// SetMixOptions sets the stem gains and loudness target used when stems are
+// mixed for exports and ToneLib backing tracks
+func (s *SngFile) SetMixOptions(options AudioMixOptions) {
+	s.mix = options
+}
+
+// SetSlice limits the song to the measures of the slice. The notes are cut when
+// they are loaded and the merged audio is trimmed to match.
+func (s *SngFile) SetSlice(slice *SongSlice) {
+	s.slice = slice
+	s.SetAudioOffset(slice.AudioOffset())
+}
 
-		// Validate that we actually got some audio data
-		if len(audioData) == 0 {
-			os.RemoveAll(tempDir)
-			return nil, fmt.Errorf("audio file %s is empty", filename)
+// loadNotes parses notes.mid from the package, falling back to notes.chart, with
+// the audio offset and slice applied. Only one of the returned files is set.
+func (s *SngFile) loadNotes() (*MidiFile, *ChartFile, error) {
+	audioOffset := s.GetAudioOffset()
+
+	midiData, midiErr := s.ReadFile("notes.mid")
+	if midiErr == nil {
+		if smfData, err := smf.ReadFrom(bytes.NewReader(midiData)); err == nil {
+			if s.slice != nil {
+				if smfData, err = SliceMidi(smfData, s.slice); err != nil {
+					return nil, nil, fmt.Errorf("failed to slice notes.mid: %w", err)
+				}
+			}
+			midiFile := &MidiFile{SMF: smfData}
+			midiFile.SetAudioOffset(audioOffset)
+			return midiFile, nil, nil
 		}
+	}
 
-		inputPath := filepath.Join(tempDir, fmt.Sprintf("input_%d.opus", i))
-		if err := os.WriteFile(inputPath, audioData, 0644); err != nil {
-			os.RemoveAll(tempDir)
-			return nil, fmt.Errorf("failed to write temp file for %s: %w", filename, err)
+	chartData, chartErr := s.ReadFile("notes.chart")
+	if chartErr == nil {
+		if chartFile, err := ParseChartFile(bytes.NewReader(chartData)); err == nil {
+			if s.slice != nil {
+				if chartFile, err = SliceChart(chartFile, s.slice); err != nil {
+					return nil, nil, fmt.Errorf("failed to slice notes.chart: %w", err)
+				}
+			}
+			chartFile.SetAudioOffset(audioOffset)
+			return nil, chartFile, nil
 		}
-		inputPaths = append(inputPaths, inputPath)
+	}
 
-		log.Printf("Extracted %s (%d bytes) to %s", filename, len(audioData), inputPath)
+	if midiErr != nil && chartErr != nil {
+		return nil, nil, fmt.Errorf("no MIDI or chart file found in SNG package")
 	}
 
-	// Create output path
-	outputPath := filepath.Join(tempDir, "output.ogg")
+	return nil, nil, fmt.Errorf("failed to parse notes from SNG file")
+}
 
-	// Build ffmpeg command to merge all audio files
-	args := []string{}
+// hasFile reports whether the package contains a file with the given name
+func (s *SngFile) hasFile(filename string) bool {
+	for _, entry := range s.Files {
+		if entry.Filename == filename {
+			return true
+		}
+	}
+	return false
+}
 
-	// Add all input files
-	for _, inputPath := range inputPaths {
-		args = append(args, "-i", inputPath)
+// GetMergedAudio mixes all audio stems in the SNG into a single ogg file for the
+// ToneLib backing track. Returns error if no stems are found or if the mix fails -
+// no fallback.
+func (s *SngFile) GetMergedAudio() (*MergedAudio, error) {
+	// Validate the audio backend before processing
+	if err := s.AudioProcessor().Validate(); err != nil {
+		return nil, fmt.Errorf("audio merge failed: %w", err)
 	}
 
-	// Build the filter complex string to handle mixed mono/stereo channels
-	if len(inputPaths) > 1 {
-		// Convert all inputs to stereo first, then merge
-		var filterInputs []string
-		for i := range inputPaths {
-			// Convert each input to stereo (mono inputs will be duplicated to both channels)
-			filterInputs = append(filterInputs, fmt.Sprintf("[%d:a]", i))
-		}
+	stems := s.AudioStems()
+	if len(stems) == 0 {
+		return nil, fmt.Errorf("no audio stems found in SNG")
+	}
 
-		// Use amix filter which handles different channel layouts better than amerge
-		filterComplex := fmt.Sprintf("%samix=inputs=%d:duration=longest[aout]", strings.Join(filterInputs, ""), len(inputPaths))
-
-		args = append(args,
-			"-filter_complex", filterComplex,
-			"-map", "[aout]",
-		)
-	} else {
-		// Single file, just map it directly
-		args = append(args, "-map", "0:a")
-	}
-
-	// Add output parameters
-	args = append(args,
-		"-ac", "2", // Stereo (2 channels)
-		"-ar", "44100", // 44100 Hz sample rate
-		"-c:a", "libvorbis", // Use Vorbis codec
-		"-b:a", "128k", // ~128000 bps bitrate
-		"-y", // Overwrite output file
-		outputPath,
-	)
-
-	// Run ffmpeg to merge and convert
-	log.Printf("Running ffmpeg to merge %d audio files", len(inputPaths))
-	cmd := exec.Command("ffmpeg", args...)
-
-	// Log the exact command for debugging
-	log.Printf("FFmpeg command: ffmpeg %s", strings.Join(args, " "))
-
-	// Capture stdout and stderr for better error reporting
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		os.RemoveAll(tempDir)
+	log.Printf("Found %d audio stems to merge: %v", len(stems), stems)
 
-		// Include ffmpeg's actual error output in the error message
-		stderrStr := strings.TrimSpace(stderr.String())
-		if stderrStr != "" {
-			return nil, fmt.Errorf("ffmpeg merge failed: %w\nFFmpeg stderr: %s", err, stderrStr)
-		}
-		return nil, fmt.Errorf("ffmpeg merge failed: %w", err)
+	// Create temporary directory for the merged file
+	tempDir, err := os.MkdirTemp("", "sng-audio-merge-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	outputPath := filepath.Join(tempDir, "output.ogg")
+	if err := s.mixAudioStems(stems, outputPath, ToneLibAudioEncoding); err != nil {
+		os.RemoveAll(tempDir)
+		return nil, fmt.Errorf("audio merge failed: %w", err)
 	}
 
 	log.Printf("Audio merge completed successfully")
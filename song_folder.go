@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// songFolderNotesFiles are the notes files that make a directory a song folder,
+// in order of preference
+var songFolderNotesFiles = []string{"notes.mid", "notes.chart"}
+
+// IsSongFolder reports whether the directory holds the notes file of a song
+func IsSongFolder(dir string) bool {
+	for _, notesFile := range songFolderNotesFiles {
+		if info, err := os.Stat(filepath.Join(dir, notesFile)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenSongFolder opens a song folder, the unpacked form of an SNG package with
+// the notes, audio stems, images and song.ini as files of a directory. The files
+// of the directory are the files of the package and the [song] section of its
+// song.ini is the metadata, so the folder works everywhere a package does.
+func OpenSongFolder(dir string) (*SngFile, error) {
+	if !IsSongFolder(dir) {
+		return nil, fmt.Errorf("no notes.mid or notes.chart in song folder %s", dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read song folder: %w", err)
+	}
+
+	sng := &SngFile{
+		Metadata: make(SngMetadata),
+		dir:      dir,
+	}
+
+	for _, entry := range entries {
+		// Stat follows links to files, which ReadDir leaves unresolved
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		sng.Files = append(sng.Files, SngFileEntry{
+			Filename: entry.Name(),
+			Size:     uint64(info.Size()),
+		})
+	}
+
+	metadata, err := ReadSiblingSongIni(filepath.Join(dir, SongIniName))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range metadata {
+		sng.Metadata[key] = value
+	}
+
+	return sng, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenSongFolder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"notes.chart": validChartData,
+		"song.ogg":    "audio",
+		"drums.ogg":   "audio",
+		"preview.ogg": "audio",
+		"song.ini":    "[song]\nname = Folder Song\ndelay = 250\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "extras"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	if !IsSongFolder(dir) || IsSongFolder(filepath.Join(dir, "extras")) {
+		t.Fatalf("expected only the folder with notes to be a song folder")
+	}
+
+	sngFile, err := OpenSongFolder(dir)
+	if err != nil {
+		t.Fatalf("OpenSongFolder failed: %v", err)
+	}
+	defer sngFile.Close()
+
+	if len(sngFile.Files) != len(files) {
+		t.Errorf("expected the %d files of the folder, got %v", len(files), sngFile.ListFiles())
+	}
+	if name := sngFile.GetMetadata()["name"]; name != "Folder Song" {
+		t.Errorf("expected the song.ini metadata, got name %q", name)
+	}
+
+	var names []string
+	for _, stem := range sngFile.AudioStems() {
+		names = append(names, stem.Name)
+	}
+	if len(names) != 2 || names[0] != "drums" || names[1] != "song" {
+		t.Errorf("expected drums and song stems, got %v", names)
+	}
+
+	// The chart has no Offset, the song.ini delay is the whole offset
+	if offset := sngFile.GetAudioOffset(); offset != 0.25 {
+		t.Errorf("expected a 0.25s audio offset, got %g", offset)
+	}
+
+	data, err := sngFile.ReadFile("song.ogg")
+	if err != nil || string(data) != "audio" {
+		t.Errorf("expected to read song.ogg from the folder, got %q (%v)", data, err)
+	}
+
+	if _, err := OpenSongFolder(filepath.Join(dir, "extras")); err == nil {
+		t.Errorf("expected an error for a folder without notes")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SongIniName is the metadata file of song folders
+const SongIniName = "song.ini"
+
+// ParseSongIni parses the [song] section of song.ini data into lowercase keys.
+// Keys before any section header are read too, as some charting tools write
+// them that way.
+func ParseSongIni(reader io.Reader) (map[string]string, error) {
+	metadata := make(map[string]string)
+	inSong := true
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSong = strings.EqualFold(strings.TrimSpace(line[1:len(line)-1]), "song")
+			continue
+		}
+		if !inSong {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		metadata[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// ReadSiblingSongIni reads the song.ini in the folder of a loose song file.
+// Returns nil without an error when the folder has none.
+func ReadSiblingSongIni(songPath string) (map[string]string, error) {
+	path := filepath.Join(filepath.Dir(songPath), SongIniName)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	metadata, err := ParseSongIni(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return metadata, nil
+}
+
+// songIniDelay returns the delay metadata, stored in milliseconds, in seconds
+func songIniDelay(metadata map[string]string) (float64, bool) {
+	delay, ok := metadata["delay"]
+	if !ok {
+		return 0, false
+	}
+	ms, err := strconv.ParseFloat(strings.TrimSpace(delay), 64)
+	if err != nil {
+		return 0, false
+	}
+	return ms / 1000.0, true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSongIni(t *testing.T) {
+	ini := "\ufeff[Song]\nname = Song A\nArtist=The Testers\n; comment\ndiff_drums = 4\n\n[other]\nname = ignored\n"
+	metadata, err := ParseSongIni(strings.NewReader(ini))
+	if err != nil {
+		t.Fatalf("ParseSongIni failed: %v", err)
+	}
+
+	expected := map[string]string{"name": "Song A", "artist": "The Testers", "diff_drums": "4"}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %v, got %v", expected, metadata)
+	}
+}
+
+func TestReadSiblingSongIni(t *testing.T) {
+	dir := t.TempDir()
+	chartPath := filepath.Join(dir, "notes.chart")
+
+	metadata, err := ReadSiblingSongIni(chartPath)
+	if err != nil || metadata != nil {
+		t.Fatalf("expected no metadata without a song.ini, got %v (%v)", metadata, err)
+	}
+	if _, ok := songIniDelay(metadata); ok {
+		t.Errorf("expected no delay without a song.ini")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, SongIniName), []byte("[song]\ndelay = 250\n"), 0644); err != nil {
+		t.Fatalf("failed to write song.ini: %v", err)
+	}
+	metadata, err = ReadSiblingSongIni(chartPath)
+	if err != nil {
+		t.Fatalf("ReadSiblingSongIni failed: %v", err)
+	}
+	if delay, ok := songIniDelay(metadata); !ok || delay != 0.25 {
+		t.Errorf("expected a delay of 0.25s, got %f", delay)
+	}
+}
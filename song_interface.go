@@ -9,11 +9,30 @@ type SongInterface interface {
 	GetTimeline() (*Timeline, error)
 	GetMetadata() map[string]string
 	GetLyricsByMeasure() ([]MeasureLyrics, error)
+	// GetVocalPhrases returns the lead vocals split into phrases
+	GetVocalPhrases() ([]VocalPhrase, error)
+	// GetVocalParts returns the lead vocals and any harmony parts
+	GetVocalParts() ([]VocalPart, error)
+
+	// GetAudioOffset returns how many seconds of audio play before tick 0
+	GetAudioOffset() float64
+	// SetAudioOffset overrides the audio offset read from the song data
+	SetAudioOffset(seconds float64)
 }
 
 // SMF wrapper so we can implement the interface
 type MidiFile struct {
 	*smf.SMF
+
+	audioOffset float64 // MIDI files carry no offset of their own
+}
+
+func (m *MidiFile) GetAudioOffset() float64 {
+	return m.audioOffset
+}
+
+func (m *MidiFile) SetAudioOffset(seconds float64) {
+	m.audioOffset = seconds
 }
 
 func (m *MidiFile) GetMetadata() map[string]string {
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// Onset detection works on the log energy of short overlapping frames
+const (
+	onsetHopSize         = 256   // Samples between frames, about 6ms at 44.1kHz
+	onsetWindowSize      = 512   // Samples in each frame
+	onsetEnergyFloor     = -60.0 // dBFS, quieter frames count as silence
+	onsetThreshold       = 6.0   // dB the energy must rise above the local average rise
+	onsetThresholdWindow = 0.5   // Seconds either side used for the local average
+	onsetMinInterval     = 0.05  // Seconds between two onsets
+)
+
+// Sync matching settings
+const (
+	syncSearchRange    = 0.5   // Largest offset in seconds searched for either way
+	syncSearchStep     = 0.002 // Resolution of the offset search in seconds
+	syncMatchWindow    = 0.03  // Seconds an onset may be from an offset note to match it
+	syncChordWindow    = 0.01  // Notes closer than this are one hit
+	syncTolerance      = 0.015 // Offsets below this many seconds are in sync
+	syncDriftTolerance = 0.02  // Seconds per minute of drift below which timing is steady
+	syncSectionSize    = 8     // Measures per section for songs without sections
+)
+
+// SyncSection is the sync of the notes in a section of the song
+type SyncSection struct {
+	Name         string  `json:"name"`
+	StartSeconds float64 `json:"start_seconds"`
+	Notes        int     `json:"notes"`   // Drum hits in the section
+	Matched      int     `json:"matched"` // Hits with an onset close to them
+	Offset       float64 `json:"offset"`  // Average seconds from note to onset, positive when the audio is late
+	Drift        float64 `json:"drift"`   // Offset of the section minus the song offset
+}
+
+// SyncReport compares the drum notes of a chart against onsets detected in the
+// audio
+type SyncReport struct {
+	Notes       int           `json:"notes"`        // Drum hits in the chart, notes of a chord count once
+	Onsets      int           `json:"onsets"`       // Onsets detected in the audio
+	Matched     int           `json:"matched"`      // Hits with an onset close to them
+	Offset      float64       `json:"offset"`       // Median seconds from note to onset, positive when the audio is late
+	MeanOffset  float64       `json:"mean_offset"`  // Average seconds from note to onset
+	DriftRate   float64       `json:"drift_rate"`   // Change of the offset in seconds per minute of song
+	AudioOffset float64       `json:"audio_offset"` // Audio offset of the timeline that was checked
+	Sections    []SyncSection `json:"sections"`
+}
+
+// String returns a human readable summary with the offset of every section
+func (r *SyncReport) String() string {
+	result := fmt.Sprintf("Drum hits: %d, audio onsets: %d, matched: %d (%.0f%%)\n", r.Notes, r.Onsets, r.Matched, r.matchRate()*100)
+	if r.Matched == 0 {
+		result += "No drum hits match the audio, check that the stems contain the drums\n"
+		return result
+	}
+
+	result += fmt.Sprintf("Offset: %+.1fms (mean %+.1fms), drift %+.1fms per minute\n", r.Offset*1000, r.MeanOffset*1000, r.DriftRate*1000)
+
+	result += "Sections:\n"
+	for _, section := range r.Sections {
+		if section.Matched == 0 {
+			result += fmt.Sprintf("  %-24s %8.3fs  %3d/%-3d hits  no matches\n", section.Name, section.StartSeconds, section.Matched, section.Notes)
+			continue
+		}
+		result += fmt.Sprintf("  %-24s %8.3fs  %3d/%-3d hits  offset %+7.1fms  drift %+7.1fms\n", section.Name, section.StartSeconds, section.Matched, section.Notes, section.Offset*1000, section.Drift*1000)
+	}
+
+	if math.Abs(r.Offset) > syncTolerance {
+		late := "late"
+		if r.Offset < 0 {
+			late = "early"
+		}
+		result += fmt.Sprintf("Audio is %.0fms %s against the chart, change the audio offset by %+.3fs\n", math.Abs(r.Offset)*1000, late, r.Offset)
+	} else {
+		result += "Audio is in sync with the chart\n"
+	}
+	if math.Abs(r.DriftRate) > syncDriftTolerance {
+		result += "Offset drifts over the song, the tempo map may not follow the audio\n"
+	}
+
+	return result
+}
+
+// SuggestedAudioOffset returns the audio offset that lines up the timeline with
+// the audio
+func (r *SyncReport) SuggestedAudioOffset() float64 {
+	return r.AudioOffset + r.Offset
+}
+
+// matchRate is the fraction of hits with an onset close to them
+func (r *SyncReport) matchRate() float64 {
+	if r.Notes == 0 {
+		return 0
+	}
+	return float64(r.Matched) / float64(r.Notes)
+}
+
+// DetectOnsets finds the times in seconds where new sounds start in the
+// samples, such as drum hits. Onsets are peaks in the rise of the frame energy,
+// refined to the attack within the frame.
+func DetectOnsets(samples []float64, sampleRate int) []float64 {
+	if len(samples) < onsetWindowSize || sampleRate <= 0 {
+		return nil
+	}
+
+	frames := (len(samples)-onsetWindowSize)/onsetHopSize + 1
+	energy := make([]float64, frames)
+	for i := range energy {
+		sum := 0.0
+		for _, sample := range samples[i*onsetHopSize : i*onsetHopSize+onsetWindowSize] {
+			sum += sample * sample
+		}
+		energy[i] = math.Max(10*math.Log10(sum/onsetWindowSize+1e-12), onsetEnergyFloor)
+	}
+
+	// The audio is silent before the first frame
+	rise := make([]float64, frames)
+	previous := onsetEnergyFloor
+	for i, level := range energy {
+		rise[i] = math.Max(level-previous, 0)
+		previous = level
+	}
+
+	// Running sums for the local average of the rise
+	sums := make([]float64, frames+1)
+	for i, value := range rise {
+		sums[i+1] = sums[i] + value
+	}
+	thresholdFrames := int(onsetThresholdWindow * float64(sampleRate) / onsetHopSize)
+
+	var onsets []float64
+	for i := 0; i < frames; i++ {
+		lo, hi := i-thresholdFrames, i+thresholdFrames+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > frames {
+			hi = frames
+		}
+		if rise[i] < (sums[hi]-sums[lo])/float64(hi-lo)+onsetThreshold {
+			continue
+		}
+
+		// Keep the largest rise of the attack, it may span a couple of frames
+		peak := true
+		for j := i - 2; j <= i+2; j++ {
+			if j >= 0 && j < frames && j != i && (rise[j] > rise[i] || (rise[j] == rise[i] && j < i)) {
+				peak = false
+			}
+		}
+		if !peak {
+			continue
+		}
+
+		onset := refineOnset(samples, sampleRate, i)
+		if len(onsets) > 0 && onset-onsets[len(onsets)-1] < onsetMinInterval {
+			continue
+		}
+		onsets = append(onsets, onset)
+	}
+
+	return onsets
+}
+
+// refineOnset finds the attack around the frame with the energy rise, where the
+// amplitude envelope first gets halfway from its level before the attack to the
+// attack peak
+func refineOnset(samples []float64, sampleRate int, frame int) float64 {
+	const block = 32
+
+	start := (frame - 1) * onsetHopSize
+	if start < 0 {
+		start = 0
+	}
+	end := frame*onsetHopSize + onsetWindowSize + onsetHopSize
+	if end > len(samples) {
+		end = len(samples)
+	}
+
+	var envelope []float64
+	for offset := start; offset+block <= end; offset += block {
+		sum := 0.0
+		for _, sample := range samples[offset : offset+block] {
+			sum += math.Abs(sample)
+		}
+		envelope = append(envelope, sum/block)
+	}
+	if len(envelope) == 0 {
+		return float64(start) / float64(sampleRate)
+	}
+
+	peakIdx := 0
+	for i, level := range envelope {
+		if level > envelope[peakIdx] {
+			peakIdx = i
+		}
+	}
+	floor := envelope[peakIdx]
+	for _, level := range envelope[:peakIdx+1] {
+		floor = math.Min(floor, level)
+	}
+
+	halfway := floor + (envelope[peakIdx]-floor)/2
+	for i, level := range envelope[:peakIdx+1] {
+		if level >= halfway {
+			return float64(start+i*block) / float64(sampleRate)
+		}
+	}
+	return float64(start+peakIdx*block) / float64(sampleRate)
+}
+
+// CheckSync matches the note times against the onsets and reports the offset
+// between them overall and for every section of the timeline. The song offset
+// is searched for first, so charts far out of sync still match their hits.
+func CheckSync(timeline *Timeline, noteTimes []float64, onsets []float64) (*SyncReport, error) {
+	if timeline == nil || len(timeline.Measures) == 0 {
+		return nil, fmt.Errorf("timeline has no measures to check")
+	}
+
+	hits := mergeChordTimes(noteTimes)
+	if len(hits) == 0 {
+		return nil, fmt.Errorf("no drum notes to check")
+	}
+
+	report := &SyncReport{
+		Notes:       len(hits),
+		Onsets:      len(onsets),
+		AudioOffset: timeline.AudioOffset,
+		Sections:    []SyncSection{},
+	}
+
+	onsets = append([]float64{}, onsets...)
+	sort.Float64s(onsets)
+
+	// Find the offset matching the most hits, then measure each hit against it
+	bestShift, bestCount := 0.0, 0
+	steps := int(syncSearchRange / syncSearchStep)
+	for step := -steps; step <= steps; step++ {
+		shift := float64(step) * syncSearchStep
+		count := 0
+		for _, hit := range hits {
+			if _, ok := nearestOnset(onsets, hit+shift, syncMatchWindow); ok {
+				count++
+			}
+		}
+		if count > bestCount || (count == bestCount && math.Abs(shift) < math.Abs(bestShift)) {
+			bestShift, bestCount = shift, count
+		}
+	}
+
+	offsets := make([]float64, len(hits))
+	matched := make([]bool, len(hits))
+	var allOffsets, matchedTimes []float64
+	for i, hit := range hits {
+		onset, ok := nearestOnset(onsets, hit+bestShift, syncMatchWindow)
+		if !ok {
+			continue
+		}
+		offsets[i] = onset - hit
+		matched[i] = true
+		allOffsets = append(allOffsets, offsets[i])
+		matchedTimes = append(matchedTimes, hit)
+	}
+
+	report.Matched = len(allOffsets)
+	if report.Matched == 0 {
+		return report, nil
+	}
+
+	report.MeanOffset = mean(allOffsets)
+	report.Offset = median(allOffsets)
+	report.DriftRate = slope(matchedTimes, allOffsets) * 60
+
+	for idx, section := range syncSections(timeline) {
+		var sectionOffsets []float64
+		for i, hit := range hits {
+			if (idx > 0 && hit < section.StartSeconds) || (section.end > 0 && hit >= section.end) {
+				continue
+			}
+			section.Notes++
+			if matched[i] {
+				sectionOffsets = append(sectionOffsets, offsets[i])
+			}
+		}
+		if section.Notes == 0 {
+			continue
+		}
+
+		section.Matched = len(sectionOffsets)
+		if section.Matched > 0 {
+			section.Offset = mean(sectionOffsets)
+			section.Drift = section.Offset - report.Offset
+		}
+		report.Sections = append(report.Sections, section.SyncSection)
+	}
+
+	return report, nil
+}
+
+// syncSection is a section of the report with the time it ends, zero for the
+// last section
+type syncSection struct {
+	SyncSection
+	end float64
+}
+
+// syncSections lists the practice sections of the timeline, or groups of
+// measures when it has none. Hits before the first section belong to an intro.
+func syncSections(timeline *Timeline) []syncSection {
+	songStart := timeline.Measures[0].StartTimeSeconds
+
+	var sections []syncSection
+	if len(timeline.Sections) > 0 {
+		if timeline.Sections[0].Seconds > songStart {
+			sections = append(sections, syncSection{SyncSection: SyncSection{Name: "(intro)", StartSeconds: songStart}})
+		}
+		for _, section := range timeline.Sections {
+			sections = append(sections, syncSection{SyncSection: SyncSection{Name: section.Name, StartSeconds: section.Seconds}})
+		}
+	} else {
+		for i := 0; i < len(timeline.Measures); i += syncSectionSize {
+			last := i + syncSectionSize
+			if last > len(timeline.Measures) {
+				last = len(timeline.Measures)
+			}
+			sections = append(sections, syncSection{SyncSection: SyncSection{
+				Name:         fmt.Sprintf("Measures %d-%d", i+1, last),
+				StartSeconds: timeline.Measures[i].StartTimeSeconds,
+			}})
+		}
+	}
+
+	for i := range sections {
+		if i+1 < len(sections) {
+			sections[i].end = sections[i+1].StartSeconds
+		}
+	}
+	return sections
+}
+
+// mergeChordTimes sorts the note times and keeps one time for notes played
+// together
+func mergeChordTimes(times []float64) []float64 {
+	sorted := append([]float64{}, times...)
+	sort.Float64s(sorted)
+
+	var hits []float64
+	for _, time := range sorted {
+		if len(hits) > 0 && time-hits[len(hits)-1] < syncChordWindow {
+			continue
+		}
+		hits = append(hits, time)
+	}
+	return hits
+}
+
+// nearestOnset returns the onset closest to time when it is within window
+// seconds. The onsets must be sorted.
+func nearestOnset(onsets []float64, time float64, window float64) (float64, bool) {
+	idx := sort.SearchFloat64s(onsets, time)
+
+	best, found := 0.0, false
+	for _, i := range []int{idx - 1, idx} {
+		if i < 0 || i >= len(onsets) {
+			continue
+		}
+		if distance := math.Abs(onsets[i] - time); distance <= window && (!found || distance < math.Abs(best-time)) {
+			best, found = onsets[i], true
+		}
+	}
+	return best, found
+}
+
+// mean returns the average of the values
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// median returns the middle of the values
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// slope returns the least squares slope of y over x, zero when x does not vary
+func slope(x, y []float64) float64 {
+	meanX, meanY := mean(x), mean(y)
+
+	var covariance, variance float64
+	for i := range x {
+		covariance += (x[i] - meanX) * (y[i] - meanY)
+		variance += (x[i] - meanX) * (x[i] - meanX)
+	}
+	if variance == 0 {
+		return 0
+	}
+	return covariance / variance
+}
+
+// DrumNoteTimes returns the times in seconds of the expert drum notes of the
+// PART DRUMS track, including the audio offset
+func (m *MidiFile) DrumNoteTimes() ([]float64, error) {
+	tempoMap, err := extractTempoMap(m.SMF, m.GetAudioOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	var drumTrack smf.Track
+	for _, track := range m.SMF.Tracks {
+		if getTrackName(track) == "PART DRUMS" {
+			drumTrack = track
+			break
+		}
+	}
+	if drumTrack == nil {
+		return nil, fmt.Errorf("no 'PART DRUMS' track found")
+	}
+
+	var times []float64
+	for _, note := range extractDrumNotes(drumTrack) {
+		times = append(times, tempoMap.SecondsAt(note.Time))
+	}
+	if len(times) == 0 {
+		return nil, fmt.Errorf("no expert drum notes found")
+	}
+	return times, nil
+}
+
+// DrumNoteTimes returns the times in seconds of the notes of the hardest drum
+// track, including the audio offset
+func (c *ChartFile) DrumNoteTimes() ([]float64, error) {
+	tempoMap := chartTempoMap(c)
+
+	for _, difficulty := range []string{"ExpertDrums", "HardDrums", "MediumDrums", "EasyDrums"} {
+		track, exists := c.Tracks[difficulty]
+		if !exists || len(track.Notes) == 0 {
+			continue
+		}
+
+		var times []float64
+		for _, note := range track.Notes {
+			times = append(times, tempoMap.SecondsAt(note.Tick))
+		}
+		return times, nil
+	}
+
+	return nil, fmt.Errorf("no drum tracks found in chart file")
+}
+
+// DrumNoteTimes returns the drum note times of notes.mid, or notes.chart when
+// the package has no MIDI file
+func (s *SngFile) DrumNoteTimes() ([]float64, error) {
+	midiFile, chartFile, err := s.loadNotes()
+	if err != nil {
+		return nil, err
+	}
+	if midiFile != nil {
+		return midiFile.DrumNoteTimes()
+	}
+	return chartFile.DrumNoteTimes()
+}
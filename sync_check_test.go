@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// createDrumSyncTracks adds a PART DRUMS track with a kick on every beat of the
+// tempo map MIDI file, and a General MIDI copy of it for the synthesizer
+func createDrumSyncTracks(smfData *smf.SMF) {
+	var partDrums, gmDrums smf.Track
+	partDrums.Add(0, smf.MetaTrackSequenceName("PART DRUMS"))
+	gmDrums.Add(0, smf.MetaTrackSequenceName("Drums"))
+
+	// 8 beats of 4/4 and 6 beats of 3/4, a quarter note apart
+	for beat := 0; beat < 14; beat++ {
+		delta := uint32(0)
+		if beat > 0 {
+			delta = 360
+		}
+		partDrums.Add(delta, midi.NoteOn(0, 96, 100))
+		partDrums.Add(120, midi.NoteOff(0, 96))
+		gmDrums.Add(delta, midi.NoteOn(gmDrumChannel, BassDrum1, 100))
+		gmDrums.Add(120, midi.NoteOff(gmDrumChannel, BassDrum1))
+	}
+	partDrums.Close(0)
+	gmDrums.Close(0)
+	smfData.Add(partDrums)
+	smfData.Add(gmDrums)
+}
+
+func TestCheckSync(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+	createDrumSyncTracks(smfData)
+
+	var wav bytes.Buffer
+	if err := RenderMidiToWav(&wav, smfData, SynthOptions{}); err != nil {
+		t.Fatalf("RenderMidiToWav failed: %v", err)
+	}
+	samples, sampleRate, err := readWav(&wav)
+	if err != nil {
+		t.Fatalf("readWav failed: %v", err)
+	}
+
+	onsets := DetectOnsets(samples, sampleRate)
+	if len(onsets) != 14 {
+		t.Fatalf("expected an onset for each of the 14 kicks, got %d: %v", len(onsets), onsets)
+	}
+
+	tests := []struct {
+		audioOffset float64
+		offset      float64
+	}{
+		{0, 0},
+		// The chart says the song starts 80ms into the audio, the kicks come early
+		{0.08, -0.08},
+	}
+
+	for _, test := range tests {
+		song := &MidiFile{SMF: smfData, audioOffset: test.audioOffset}
+		timeline, err := song.GetTimeline()
+		if err != nil {
+			t.Fatalf("GetTimeline failed: %v", err)
+		}
+		noteTimes, err := song.DrumNoteTimes()
+		if err != nil {
+			t.Fatalf("DrumNoteTimes failed: %v", err)
+		}
+
+		report, err := CheckSync(timeline, noteTimes, onsets)
+		if err != nil {
+			t.Fatalf("CheckSync failed: %v", err)
+		}
+
+		if report.Notes != 14 || report.Matched != 14 {
+			t.Errorf("offset %.2f: expected all 14 hits matched, got %d of %d", test.audioOffset, report.Matched, report.Notes)
+		}
+		if math.Abs(report.Offset-test.offset) > 0.003 {
+			t.Errorf("offset %.2f: expected a sync offset of %.3f, got %.4f", test.audioOffset, test.offset, report.Offset)
+		}
+		if math.Abs(report.SuggestedAudioOffset()) > 0.003 {
+			t.Errorf("offset %.2f: expected to suggest an offset of 0, got %.4f", test.audioOffset, report.SuggestedAudioOffset())
+		}
+		if math.Abs(report.DriftRate) > syncDriftTolerance {
+			t.Errorf("offset %.2f: expected no drift, got %.4f", test.audioOffset, report.DriftRate)
+		}
+		if len(report.Sections) != 1 || report.Sections[0].Name != "Measures 1-4" || report.Sections[0].Matched != 14 {
+			t.Errorf("offset %.2f: expected one section of four measures, got %+v", test.audioOffset, report.Sections)
+		}
+	}
+}
+
+func TestCheckSyncSections(t *testing.T) {
+	timeline, err := (&MidiFile{SMF: createTempoMapMidiFile()}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+	timeline.setSections([]Section{{Tick: 1920, Name: "Verse"}, {Tick: 3840, Name: "Chorus"}})
+
+	// The audio falls behind by 5ms for every hit
+	notes := []float64{0, 1, 2, 3, 4, 5, 6, 7}
+	var onsets []float64
+	for i, note := range notes {
+		onsets = append(onsets, note+float64(i)*0.005)
+	}
+
+	report, err := CheckSync(timeline, notes, onsets)
+	if err != nil {
+		t.Fatalf("CheckSync failed: %v", err)
+	}
+
+	expected := []struct {
+		name   string
+		notes  int
+		offset float64
+	}{
+		{"(intro)", 2, 0.0025},
+		{"Verse", 2, 0.0125},
+		{"Chorus", 4, 0.0275},
+	}
+	if len(report.Sections) != len(expected) {
+		t.Fatalf("expected %d sections, got %+v", len(expected), report.Sections)
+	}
+	for i, want := range expected {
+		got := report.Sections[i]
+		if got.Name != want.name || got.Notes != want.notes || math.Abs(got.Offset-want.offset) > 1e-6 {
+			t.Errorf("section %d: expected %s with %d notes at %.3f, got %+v", i, want.name, want.notes, want.offset, got)
+		}
+	}
+
+	// 5ms a second is 300ms a minute
+	if math.Abs(report.DriftRate-0.3) > 1e-6 {
+		t.Errorf("expected a drift of 0.3s per minute, got %.4f", report.DriftRate)
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// DefaultSynthSampleRate is the sample rate of rendered WAV files
+const DefaultSynthSampleRate = 44100
+
+// Levels of the rendered sounds before the mix is normalized
+const (
+	synthNoteGain  = 0.25 // Melodic notes at full velocity
+	synthDrumGain  = 0.5  // Drum hits at full velocity
+	synthClickGain = 0.4  // Click on regular beats, downbeats are accented
+	synthPeakLevel = 0.9  // Mixes peaking above this are scaled down to it
+	synthTailTime  = 1.0  // Seconds rendered after the last note ends, for drums to ring out
+)
+
+// SynthOptions configures the built-in synthesizer
+type SynthOptions struct {
+	SampleRate int       // Samples per second, DefaultSynthSampleRate when 0
+	Click      *Timeline // Mixes a click on the beats of the timeline when set
+}
+
+// synthNote is a note of the MIDI file with its times in seconds
+type synthNote struct {
+	start    float64
+	end      float64
+	channel  uint8
+	key      uint8
+	velocity uint8
+	program  uint8
+}
+
+// RenderMidiToWav renders the MIDI file to a mono 16-bit WAV file with a simple
+// synthesizer: basic oscillators for melodic channels and synthesized drum
+// sounds for the General MIDI drum channel. It is meant for quick previews, not
+// realistic playback. The output is deterministic for the same input.
+func RenderMidiToWav(writer io.Writer, smfData *smf.SMF, options SynthOptions) error {
+	if options.SampleRate <= 0 {
+		options.SampleRate = DefaultSynthSampleRate
+	}
+
+	tempoMap, err := extractTempoMap(smfData, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read tempo map: %w", err)
+	}
+
+	notes := collectSynthNotes(smfData, tempoMap)
+
+	// Render the whole file so the output lines up with the song audio
+	length := tempoMap.SecondsAt(getLastEventTime(smfData))
+	for _, note := range notes {
+		length = math.Max(length, note.end)
+	}
+	var clicks []Click
+	if options.Click != nil {
+		for _, beat := range options.Click.GetBeats() {
+			level := ClickBeat
+			if beat.IsDownbeat {
+				level = ClickAccent
+			}
+			clicks = append(clicks, Click{Seconds: beat.TimeSeconds, Level: level})
+			length = math.Max(length, beat.TimeSeconds)
+		}
+	}
+
+	samples := make([]float64, int((length+synthTailTime)*float64(options.SampleRate)))
+	noise := rand.New(rand.NewSource(1))
+
+	for _, note := range notes {
+		if note.channel == gmDrumChannel {
+			renderDrumHit(samples, options.SampleRate, note, noise)
+		} else {
+			renderTone(samples, options.SampleRate, note)
+		}
+	}
+
+	renderClick(samples, options.SampleRate, clicks)
+
+	return writeWav(writer, normalizeSamples(samples), options.SampleRate)
+}
+
+// collectSynthNotes pairs the note on and off events of every track, tracking
+// program changes per channel. Notes left on are stopped at the end of their track.
+func collectSynthNotes(smfData *smf.SMF, tempoMap *TempoMap) []synthNote {
+	type noteKey struct {
+		channel uint8
+		key     uint8
+	}
+
+	var notes []synthNote
+	var programs [16]uint8
+
+	for _, track := range smfData.Tracks {
+		active := make(map[noteKey]synthNote)
+		var currentTime uint32
+
+		for _, event := range track {
+			currentTime += event.Delta
+			seconds := tempoMap.SecondsAt(currentTime)
+
+			var channel, key, velocity, program uint8
+			switch {
+			case event.Message.GetProgramChange(&channel, &program):
+				programs[channel] = program
+			case event.Message.GetNoteOn(&channel, &key, &velocity) && velocity > 0:
+				note := noteKey{channel, key}
+				if previous, exists := active[note]; exists {
+					previous.end = seconds
+					notes = append(notes, previous)
+				}
+				active[note] = synthNote{
+					start:    seconds,
+					channel:  channel,
+					key:      key,
+					velocity: velocity,
+					program:  programs[channel],
+				}
+			case event.Message.GetNoteOff(&channel, &key, &velocity), event.Message.GetNoteOn(&channel, &key, &velocity):
+				note := noteKey{channel, key}
+				if started, exists := active[note]; exists {
+					started.end = seconds
+					notes = append(notes, started)
+					delete(active, note)
+				}
+			}
+		}
+
+		endSeconds := tempoMap.SecondsAt(currentTime)
+		for _, note := range active {
+			note.end = endSeconds
+			notes = append(notes, note)
+		}
+	}
+
+	// Map iteration above is random, keep the rendering order stable
+	sort.SliceStable(notes, func(i, j int) bool {
+		if notes[i].start != notes[j].start {
+			return notes[i].start < notes[j].start
+		}
+		if notes[i].channel != notes[j].channel {
+			return notes[i].channel < notes[j].channel
+		}
+		return notes[i].key < notes[j].key
+	})
+
+	return notes
+}
+
+// midiKeyFrequency returns the frequency of a MIDI key in Hz
+func midiKeyFrequency(key uint8) float64 {
+	return 440.0 * math.Pow(2, (float64(key)-69)/12.0)
+}
+
+// renderTone adds a melodic note. The waveform depends on the GM program family:
+// sawtooth for bass, sine with vibrato for voices and triangle for the rest.
+func renderTone(samples []float64, sampleRate int, note synthNote) {
+	const (
+		attack  = 0.005
+		decay   = 0.1
+		sustain = 0.7
+		release = 0.08
+	)
+
+	frequency := midiKeyFrequency(note.key)
+	gain := synthNoteGain * float64(note.velocity) / 127.0
+	duration := math.Max(note.end-note.start, attack)
+
+	isBass := note.program >= 32 && note.program <= 39
+	isVoice := note.program >= 52 && note.program <= 54
+
+	startSample := int(note.start * float64(sampleRate))
+	length := int((duration + release) * float64(sampleRate))
+
+	var phase, lowpass float64
+	for i := 0; i < length; i++ {
+		index := startSample + i
+		if index < 0 {
+			continue
+		}
+		if index >= len(samples) {
+			break
+		}
+
+		t := float64(i) / float64(sampleRate)
+
+		// ADSR envelope
+		var envelope float64
+		switch {
+		case t < attack:
+			envelope = t / attack
+		case t < attack+decay:
+			envelope = 1 - (1-sustain)*(t-attack)/decay
+		default:
+			envelope = sustain
+		}
+		if t > duration {
+			envelope *= math.Max(0, 1-(t-duration)/release)
+		}
+
+		step := frequency / float64(sampleRate)
+		if isVoice {
+			step *= 1 + 0.005*math.Sin(2*math.Pi*5*t)
+		}
+		phase = math.Mod(phase+step, 1)
+
+		var value float64
+		switch {
+		case isBass:
+			// Sawtooth through a one pole low pass to soften it
+			lowpass += 0.2 * ((2*phase - 1) - lowpass)
+			value = lowpass
+		case isVoice:
+			value = math.Sin(2 * math.Pi * phase)
+		default:
+			value = 1 - 4*math.Abs(phase-0.5)
+		}
+
+		samples[index] += value * envelope * gain
+	}
+}
+
+// drumSound describes a synthesized drum: a pitched body mixed with noise, both
+// decaying exponentially
+type drumSound struct {
+	startFrequency float64 // Body pitch at the hit, swept down to frequency
+	frequency      float64
+	tone           float64 // Level of the pitched body
+	noise          float64 // Level of the noise
+	highpass       bool    // Filter the noise for cymbals and hi-hats
+	decay          float64 // Seconds for the sound to fall to about a third
+}
+
+// drumSoundForKey picks the drum sound for a General MIDI percussion key
+func drumSoundForKey(key uint8) drumSound {
+	switch key {
+	case AcousticBassDrum, BassDrum1:
+		return drumSound{startFrequency: 150, frequency: 50, tone: 1, decay: 0.15}
+	case SideStick:
+		return drumSound{startFrequency: 800, frequency: 600, tone: 0.5, noise: 0.3, decay: 0.03}
+	case AcousticSnare, ElectricSnare, HandClap:
+		return drumSound{startFrequency: 250, frequency: 180, tone: 0.4, noise: 0.6, decay: 0.1}
+	case LowFloorTom, HighFloorTom, LowTom, LowMidTom, HiMidTom, HighTom:
+		frequency := 80 + float64(key-LowFloorTom)*15
+		return drumSound{startFrequency: frequency * 1.5, frequency: frequency, tone: 1, decay: 0.2}
+	case ClosedHiHat, PedalHiHat:
+		return drumSound{noise: 0.4, highpass: true, decay: 0.03}
+	case OpenHiHat:
+		return drumSound{noise: 0.4, highpass: true, decay: 0.2}
+	case RideCymbal1, RideCymbal2, RideBell:
+		return drumSound{startFrequency: 3000, frequency: 3000, tone: 0.1, noise: 0.3, highpass: true, decay: 0.4}
+	case CrashCymbal1, CrashCymbal2, ChineseCymbal, SplashCymbal:
+		return drumSound{noise: 0.5, highpass: true, decay: 0.6}
+	default:
+		// Hand percussion, pitched by key
+		frequency := midiKeyFrequency(key)
+		return drumSound{startFrequency: frequency, frequency: frequency, tone: 0.5, noise: 0.3, decay: 0.06}
+	}
+}
+
+// renderDrumHit adds a drum hit. Drums ignore the note length and ring out.
+func renderDrumHit(samples []float64, sampleRate int, note synthNote, noise *rand.Rand) {
+	sound := drumSoundForKey(note.key)
+	gain := synthDrumGain * float64(note.velocity) / 127.0
+
+	startSample := int(note.start * float64(sampleRate))
+	length := int(sound.decay * 6 * float64(sampleRate))
+
+	var phase, lastNoise float64
+	for i := 0; i < length; i++ {
+		index := startSample + i
+		if index >= len(samples) {
+			break
+		}
+
+		t := float64(i) / float64(sampleRate)
+		envelope := math.Exp(-t / sound.decay)
+
+		// Sweep the pitch down quickly, like a drum head settling
+		frequency := sound.frequency + (sound.startFrequency-sound.frequency)*math.Exp(-t/0.03)
+		phase = math.Mod(phase+frequency/float64(sampleRate), 1)
+
+		white := noise.Float64()*2 - 1
+		noiseValue := white
+		if sound.highpass {
+			noiseValue = white - lastNoise
+		}
+		lastNoise = white
+
+		if index >= 0 {
+			samples[index] += (sound.tone*math.Sin(2*math.Pi*phase) + sound.noise*noiseValue) * envelope * gain
+		}
+	}
+}
+
+// renderClick adds a short blip for every click, higher and louder on accents
+// and quieter on subdivisions
+func renderClick(samples []float64, sampleRate int, clicks []Click) {
+	const duration = 0.03
+
+	for _, click := range clicks {
+		frequency, gain := 1000.0, synthClickGain
+		switch click.Level {
+		case ClickAccent:
+			frequency, gain = 1500.0, synthClickGain*1.5
+		case ClickSubdivision:
+			frequency, gain = 800.0, synthClickGain*0.5
+		}
+
+		startSample := int(click.Seconds * float64(sampleRate))
+		length := int(duration * float64(sampleRate))
+		for i := 0; i < length; i++ {
+			index := startSample + i
+			if index < 0 {
+				continue
+			}
+			if index >= len(samples) {
+				break
+			}
+
+			t := float64(i) / float64(sampleRate)
+			samples[index] += math.Sin(2*math.Pi*frequency*t) * math.Exp(-t/(duration/4)) * gain
+		}
+	}
+}
+
+// normalizeSamples scales the mix down when it peaks above synthPeakLevel
+func normalizeSamples(samples []float64) []float64 {
+	peak := 0.0
+	for _, sample := range samples {
+		peak = math.Max(peak, math.Abs(sample))
+	}
+
+	if peak > synthPeakLevel {
+		scale := synthPeakLevel / peak
+		for i := range samples {
+			samples[i] *= scale
+		}
+	}
+
+	return samples
+}
+
+// writeWav writes the samples as a mono 16-bit PCM WAV file
+func writeWav(writer io.Writer, samples []float64, sampleRate int) error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+	)
+	blockAlign := channels * bitsPerSample / 8
+	dataSize := len(samples) * blockAlign
+
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),                      // Size of the fmt chunk
+		uint16(1),                       // PCM
+		uint16(channels),                // Channels
+		uint32(sampleRate),              // Sample rate
+		uint32(sampleRate * blockAlign), // Byte rate
+		uint16(blockAlign),              // Block align
+		uint16(bitsPerSample),           // Bits per sample
+		[4]byte{'d', 'a', 't', 'a'},
+		uint32(dataSize),
+	}
+	for _, field := range header {
+		if err := binary.Write(writer, binary.LittleEndian, field); err != nil {
+			return fmt.Errorf("failed to write WAV header: %w", err)
+		}
+	}
+
+	data := make([]byte, dataSize)
+	for i, sample := range samples {
+		value := int16(math.Max(-1, math.Min(1, sample)) * math.MaxInt16)
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(value))
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write WAV data: %w", err)
+	}
+
+	return nil
+}
+
+// readWav reads a 16-bit PCM WAV file, mixing the channels down to mono samples
+// between -1 and 1. Chunks other than fmt and data are skipped.
+func readWav(reader io.Reader) ([]float64, int, error) {
+	var riff struct {
+		ID     [4]byte
+		Size   uint32
+		Format [4]byte
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &riff); err != nil {
+		return nil, 0, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(riff.ID[:]) != "RIFF" || string(riff.Format[:]) != "WAVE" {
+		return nil, 0, fmt.Errorf("not a WAV file")
+	}
+
+	var format struct {
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}
+	hasFormat := false
+
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &chunk); err != nil {
+			return nil, 0, fmt.Errorf("WAV file has no data chunk: %w", err)
+		}
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			data := make([]byte, chunk.Size+chunk.Size%2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, 0, fmt.Errorf("failed to read WAV format: %w", err)
+			}
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &format); err != nil {
+				return nil, 0, fmt.Errorf("failed to read WAV format: %w", err)
+			}
+			hasFormat = true
+		case "data":
+			if !hasFormat {
+				return nil, 0, fmt.Errorf("WAV data before format chunk")
+			}
+			// 0xFFFE is WAVE_FORMAT_EXTENSIBLE, used by some encoders for plain PCM
+			if (format.AudioFormat != 1 && format.AudioFormat != 0xFFFE) || format.BitsPerSample != 16 || format.Channels == 0 {
+				return nil, 0, fmt.Errorf("unsupported WAV encoding (format %d, %d bits), expected 16-bit PCM", format.AudioFormat, format.BitsPerSample)
+			}
+
+			data, err := io.ReadAll(io.LimitReader(reader, int64(chunk.Size)))
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to read WAV data: %w", err)
+			}
+
+			channels := int(format.Channels)
+			samples := make([]float64, len(data)/2/channels)
+			for i := range samples {
+				sum := 0.0
+				for c := 0; c < channels; c++ {
+					sum += float64(int16(binary.LittleEndian.Uint16(data[(i*channels+c)*2:])))
+				}
+				samples[i] = sum / float64(channels) / math.MaxInt16
+			}
+			return samples, int(format.SampleRate), nil
+		default:
+			if _, err := io.CopyN(io.Discard, reader, int64(chunk.Size+chunk.Size%2)); err != nil {
+				return nil, 0, fmt.Errorf("failed to skip WAV chunk: %w", err)
+			}
+		}
+	}
+}
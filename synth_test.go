@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// readTestWav checks the header of a rendered WAV file and returns its samples
+func readTestWav(t *testing.T, data []byte) []int16 {
+	t.Helper()
+
+	if len(data) < 44 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[36:40]) != "data" {
+		t.Fatalf("invalid WAV header")
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:28]); rate != DefaultSynthSampleRate {
+		t.Errorf("expected sample rate %d, got %d", DefaultSynthSampleRate, rate)
+	}
+
+	dataSize := binary.LittleEndian.Uint32(data[40:44])
+	if int(dataSize) != len(data)-44 {
+		t.Fatalf("data chunk size %d does not match file size %d", dataSize, len(data))
+	}
+
+	samples := make([]int16, dataSize/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[44+i*2:]))
+	}
+	return samples
+}
+
+// peakBetween returns the loudest sample between the two times
+func peakBetween(samples []int16, start, end float64) float64 {
+	peak := 0.0
+	for i := int(start * DefaultSynthSampleRate); i < int(end*DefaultSynthSampleRate) && i < len(samples); i++ {
+		peak = math.Max(peak, math.Abs(float64(samples[i])))
+	}
+	return peak
+}
+
+func TestRenderMidiToWav(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+
+	// A kick on the first beat and a bass note on the third, at 120 BPM
+	var track smf.Track
+	track.Add(0, midi.ProgramChange(0, 33))
+	track.Add(0, midi.NoteOn(gmDrumChannel, BassDrum1, 100))
+	track.Add(120, midi.NoteOff(gmDrumChannel, BassDrum1))
+	track.Add(840, midi.NoteOn(0, 40, 100))
+	track.Add(480, midi.NoteOff(0, 40))
+	track.Close(0)
+	smfData.Add(track)
+
+	var buf bytes.Buffer
+	if err := RenderMidiToWav(&buf, smfData, SynthOptions{}); err != nil {
+		t.Fatalf("RenderMidiToWav failed: %v", err)
+	}
+	samples := readTestWav(t, buf.Bytes())
+
+	// The song ends with the conductor at 8 seconds, plus the tail
+	expectedLength := int((8 + synthTailTime) * DefaultSynthSampleRate)
+	if len(samples) != expectedLength {
+		t.Errorf("expected %d samples, got %d", expectedLength, len(samples))
+	}
+
+	if peakBetween(samples, 0, 0.1) == 0 {
+		t.Errorf("expected the kick at the start")
+	}
+	if peakBetween(samples, 0.9, 0.95) != 0 {
+		t.Errorf("expected silence before the bass note")
+	}
+	if peakBetween(samples, 1.0, 1.5) == 0 {
+		t.Errorf("expected the bass note from 1 to 1.5 seconds")
+	}
+	if peak := peakBetween(samples, 0, 9); peak > synthPeakLevel*math.MaxInt16+1 {
+		t.Errorf("expected the mix to peak at most at %.1f, got %.0f", synthPeakLevel, peak)
+	}
+
+	// Rendering is deterministic
+	var again bytes.Buffer
+	if err := RenderMidiToWav(&again, smfData, SynthOptions{}); err != nil {
+		t.Fatalf("RenderMidiToWav failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Errorf("expected identical output for the same input")
+	}
+}
+
+func TestRenderMidiToWavClick(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+	timeline, err := (&MidiFile{SMF: smfData}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderMidiToWav(&buf, smfData, SynthOptions{Click: timeline}); err != nil {
+		t.Fatalf("RenderMidiToWav failed: %v", err)
+	}
+	samples := readTestWav(t, buf.Bytes())
+
+	// Downbeats are accented over the other beats
+	downbeat := peakBetween(samples, 0, 0.03)
+	beat := peakBetween(samples, 0.5, 0.53)
+	if beat == 0 || downbeat <= beat {
+		t.Errorf("expected an accented downbeat click, got downbeat %.0f and beat %.0f", downbeat, beat)
+	}
+	if peakBetween(samples, 0.1, 0.45) != 0 {
+		t.Errorf("expected silence between clicks")
+	}
+}
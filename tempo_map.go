@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// TempoChange is a tempo meta event at an absolute tick
+type TempoChange struct {
+	Tick uint32  `json:"tick"`
+	BPM  float64 `json:"bpm"` // Quarter notes per minute
+}
+
+// TimeSigChange is a time signature meta event at an absolute tick
+type TimeSigChange struct {
+	Tick        uint32 `json:"tick"`
+	Numerator   uint8  `json:"numerator"`
+	Denominator uint8  `json:"denominator"` // Actual denominator value (4 for 4/4), not log2
+}
+
+// TempoMap holds the tempo and time signature changes of a song and converts
+// ticks into seconds
+type TempoMap struct {
+	TicksPerQuarter float64
+	Tempos          []TempoChange   // Sorted by tick, always starts at tick 0
+	TimeSigs        []TimeSigChange // Sorted by tick, always starts at tick 0
+	AudioOffset     float64         // Seconds of audio before tick 0
+}
+
+// extractTempoMap collects tempo and time signature meta events from every track
+// of a MIDI file. Missing values default to 120 BPM and 4/4.
+func extractTempoMap(smfData *smf.SMF, audioOffset float64) (*TempoMap, error) {
+	ticksPerQuarter, ok := smfData.TimeFormat.(smf.MetricTicks)
+	if !ok {
+		return nil, fmt.Errorf("unsupported time format, expected MetricTicks")
+	}
+
+	tempoMap := &TempoMap{
+		TicksPerQuarter: float64(ticksPerQuarter),
+		AudioOffset:     audioOffset,
+	}
+
+	for _, track := range smfData.Tracks {
+		var currentTime uint32
+		for _, event := range track {
+			currentTime += event.Delta
+
+			var bpm float64
+			var num, denom uint8
+			if event.Message.GetMetaTempo(&bpm) {
+				tempoMap.Tempos = append(tempoMap.Tempos, TempoChange{Tick: currentTime, BPM: bpm})
+			} else if event.Message.GetMetaTimeSig(&num, &denom, nil, nil) {
+				tempoMap.TimeSigs = append(tempoMap.TimeSigs, TimeSigChange{
+					Tick:        currentTime,
+					Numerator:   num,
+					Denominator: denom,
+				})
+			}
+		}
+	}
+
+	tempoMap.normalize()
+	return tempoMap, nil
+}
+
+// chartTempoMap builds a tempo map from the SyncTrack section of a chart file,
+// using the chart resolution as ticks per quarter note
+func chartTempoMap(chart *ChartFile) *TempoMap {
+	tempoMap := &TempoMap{
+		TicksPerQuarter: float64(chart.Song.Resolution),
+		AudioOffset:     chart.GetAudioOffset(),
+	}
+
+	if tempoMap.TicksPerQuarter <= 0 {
+		tempoMap.TicksPerQuarter = 192
+	}
+
+	for _, bpmEvent := range chart.SyncTrack.BPMEvents {
+		if bpmEvent.BPM == 0 {
+			continue
+		}
+		tempoMap.Tempos = append(tempoMap.Tempos, TempoChange{
+			Tick: bpmEvent.Tick,
+			BPM:  float64(bpmEvent.BPM) / 1000.0,
+		})
+	}
+
+	for _, tsEvent := range chart.SyncTrack.TimeSigEvents {
+		tempoMap.TimeSigs = append(tempoMap.TimeSigs, TimeSigChange{
+			Tick:        tsEvent.Tick,
+			Numerator:   tsEvent.Numerator,
+			Denominator: 1 << tsEvent.Denominator, // Charts store log2 of the denominator
+		})
+	}
+
+	tempoMap.normalize()
+	return tempoMap
+}
+
+// normalize sorts the changes and makes sure both lists cover tick 0
+func (tm *TempoMap) normalize() {
+	sort.SliceStable(tm.Tempos, func(i, j int) bool {
+		return tm.Tempos[i].Tick < tm.Tempos[j].Tick
+	})
+	sort.SliceStable(tm.TimeSigs, func(i, j int) bool {
+		return tm.TimeSigs[i].Tick < tm.TimeSigs[j].Tick
+	})
+
+	if len(tm.Tempos) == 0 {
+		log.Printf("Warning: No tempo events found, using default 120 BPM for timing calculations\n")
+		tm.Tempos = []TempoChange{{Tick: 0, BPM: 120.0}}
+	} else if tm.Tempos[0].Tick > 0 {
+		tm.Tempos = append([]TempoChange{{Tick: 0, BPM: 120.0}}, tm.Tempos...)
+	}
+
+	if len(tm.TimeSigs) == 0 || tm.TimeSigs[0].Tick > 0 {
+		tm.TimeSigs = append([]TimeSigChange{{Tick: 0, Numerator: 4, Denominator: 4}}, tm.TimeSigs...)
+	}
+}
+
+// SecondsAt converts an absolute tick to seconds, including the audio offset
+func (tm *TempoMap) SecondsAt(tick uint32) float64 {
+	seconds := tm.AudioOffset
+
+	for i, tempo := range tm.Tempos {
+		if tempo.Tick >= tick {
+			break
+		}
+
+		segmentEnd := tick
+		if i+1 < len(tm.Tempos) && tm.Tempos[i+1].Tick < tick {
+			segmentEnd = tm.Tempos[i+1].Tick
+		}
+
+		seconds += float64(segmentEnd-tempo.Tick) / tm.TicksPerQuarter * 60.0 / tempo.BPM
+	}
+
+	return seconds
+}
+
+// TimeSigAt returns the time signature in effect at the given tick
+func (tm *TempoMap) TimeSigAt(tick uint32) TimeSigChange {
+	current := TimeSigChange{Numerator: 4, Denominator: 4}
+	for _, timeSig := range tm.TimeSigs {
+		if timeSig.Tick > tick {
+			break
+		}
+		current = timeSig
+	}
+	return current
+}
+
+// CreateMeasures lays out measures and beats from the time signature changes up to
+// endTick. A time signature change that does not fall on a bar line ends the current
+// measure early, the same way createMeasuresFromChart handles it.
+func (tm *TempoMap) CreateMeasures(endTick uint32) ([]Measure, []BeatNote) {
+	var measures []Measure
+	var beatNotes []BeatNote
+
+	measureStart := uint32(0)
+	for len(measures) == 0 || measureStart < endTick {
+		timeSig := tm.TimeSigAt(measureStart)
+		numerator := int(timeSig.Numerator)
+		if numerator <= 0 {
+			numerator = 4
+		}
+		denominator := int(timeSig.Denominator)
+		if denominator <= 0 {
+			denominator = 4
+		}
+
+		ticksPerBeat := uint32(tm.TicksPerQuarter * 4 / float64(denominator))
+		if ticksPerBeat == 0 {
+			ticksPerBeat = 1
+		}
+		measureEnd := measureStart + ticksPerBeat*uint32(numerator)
+
+		// End the measure early if the time signature changes inside it
+		for _, change := range tm.TimeSigs {
+			if change.Tick > measureStart && change.Tick < measureEnd {
+				measureEnd = change.Tick
+				break
+			}
+		}
+
+		var measureBeats []BeatNote
+		for tick := measureStart; tick < measureEnd; tick += ticksPerBeat {
+			measureBeats = append(measureBeats, BeatNote{
+				Time:        tick,
+				TimeSeconds: tm.SecondsAt(tick),
+				IsDownbeat:  tick == measureStart,
+			})
+		}
+
+		startSeconds := tm.SecondsAt(measureStart)
+		endSeconds := tm.SecondsAt(measureEnd)
+
+		measures = append(measures, Measure{
+			StartTime:        measureStart,
+			EndTime:          measureEnd,
+			StartTimeSeconds: startSeconds,
+			EndTimeSeconds:   endSeconds,
+			BeatsPerMeasure:  len(measureBeats),
+			BeatsPerMinute:   float64(len(measureBeats)) * 60.0 / (endSeconds - startSeconds),
+			BeatNotes:        measureBeats,
+		})
+		beatNotes = append(beatNotes, measureBeats...)
+
+		measureStart = measureEnd
+	}
+
+	return measures, beatNotes
+}
+
+// getLastEventTime returns the absolute tick of the last event in any track
+func getLastEventTime(smfData *smf.SMF) uint32 {
+	var lastTime uint32
+	for _, track := range smfData.Tracks {
+		var currentTime uint32
+		for _, event := range track {
+			currentTime += event.Delta
+		}
+		if currentTime > lastTime {
+			lastTime = currentTime
+		}
+	}
+	return lastTime
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
+	"log"
 	"math"
 	"sort"
-	"strings"
 
 	"gitlab.com/gomidi/midi/v2/smf"
 )
@@ -26,6 +25,7 @@ type Measure struct {
 	BeatsPerMeasure  int        `json:"beats_per_measure"`  // Number of beats in this measure
 	BeatsPerMinute   float64    `json:"beats_per_minute"`   // Original BPM from MIDI tempo events
 	BeatNotes        []BeatNote `json:"beat_notes"`         // Beat notes contained in this measure
+	Section          string     `json:"section,omitempty"`  // Name of the section starting in this measure
 }
 
 // Timeline represents the complete beat timeline of a song
@@ -33,10 +33,21 @@ type Timeline struct {
 	Measures     []Measure  `json:"measures"`
 	BeatNotes    []BeatNote `json:"beat_notes"`
 	TicksPerBeat float64    `json:"ticks_per_beat"` // Derived from time signature and tempo
+	AudioOffset  float64    `json:"audio_offset"`   // Seconds of audio before tick 0, already applied to all times
+	Source       string     `json:"source"`         // Where the measures came from, one of the TimelineSource values
+	Sections     []Section  `json:"sections"`       // Practice sections in tick order
 }
 
-// extractBeatNotesWithTiming processes all MIDI events chronologically to extract beats with accurate timing
-func extractBeatNotesWithTiming(smfData *smf.SMF, beatTrack smf.Track) ([]BeatNote, error) {
+// Timeline sources
+const (
+	TimelineSourceBeatTrack = "beat_track" // BEAT track of a Rock Band MIDI file
+	TimelineSourceTempoMap  = "tempo_map"  // Synthesized from MIDI tempo and time signature events
+	TimelineSourceChart     = "chart"      // SyncTrack section of a chart file
+)
+
+// extractBeatNotesWithTiming processes all MIDI events chronologically to extract beats with accurate timing.
+// audioOffset is the time in seconds where tick 0 occurs in the audio.
+func extractBeatNotesWithTiming(smfData *smf.SMF, beatTrack smf.Track, audioOffset float64) ([]BeatNote, error) {
 	// Get ticks per quarter note
 	ticksPerQuarter, ok := smfData.TimeFormat.(smf.MetricTicks)
 	if !ok {
@@ -85,7 +96,7 @@ func extractBeatNotesWithTiming(smfData *smf.SMF, beatTrack smf.Track) ([]BeatNo
 						})
 					} else {
 						// Warning for unexpected notes in beat track
-						fmt.Printf("Warning: Unexpected note detected in BEAT track at time %d with key %d\n", currentTime, key)
+						log.Printf("Warning: Unexpected note detected in BEAT track at time %d with key %d\n", currentTime, key)
 					}
 				}
 			}
@@ -99,7 +110,7 @@ func extractBeatNotesWithTiming(smfData *smf.SMF, beatTrack smf.Track) ([]BeatNo
 
 	// Process events chronologically to build beat notes with accurate timing
 	var beatNotes []BeatNote
-	var currentSeconds float64 = 0.0
+	var currentSeconds float64 = audioOffset
 	var lastTick uint32 = 0
 	var currentBPM float64 = 120.0 // Default BPM
 	var hasTempoEvents bool = false
@@ -148,7 +159,7 @@ func extractBeatNotesWithTiming(smfData *smf.SMF, beatTrack smf.Track) ([]BeatNo
 
 	// Warn if we used default tempo
 	if usedDefaultTempo {
-		fmt.Printf("Warning: No tempo events found, using default 120 BPM for timing calculations\n")
+		log.Printf("Warning: No tempo events found, using default 120 BPM for timing calculations\n")
 	}
 
 	return beatNotes, nil
@@ -280,7 +291,7 @@ func createMeasuresFromChart(chart *ChartFile) []Measure {
 
 	currentTick := uint32(0)
 	measureStartTick := uint32(0)
-	measureStartSeconds := 0.0
+	measureStartSeconds := chart.GetAudioOffset()
 
 	// Calculate ticks per measure for current settings
 	ticksPerBeat := float64(chart.Song.Resolution)
@@ -399,6 +410,34 @@ func (t *Timeline) GetMeasureAtTime(time uint32) *Measure {
 	return nil
 }
 
+// GetBeats returns the beat notes of the timeline. Timelines built without a
+// beat track (such as charts) get evenly spaced beats derived from their measures.
+func (t *Timeline) GetBeats() []BeatNote {
+	if len(t.BeatNotes) > 0 {
+		return t.BeatNotes
+	}
+
+	var beats []BeatNote
+	for _, measure := range t.Measures {
+		if measure.BeatsPerMeasure <= 0 {
+			continue
+		}
+
+		tickStep := float64(measure.EndTime-measure.StartTime) / float64(measure.BeatsPerMeasure)
+		secondStep := (measure.EndTimeSeconds - measure.StartTimeSeconds) / float64(measure.BeatsPerMeasure)
+
+		for i := 0; i < measure.BeatsPerMeasure; i++ {
+			beats = append(beats, BeatNote{
+				Time:        measure.StartTime + uint32(float64(i)*tickStep),
+				TimeSeconds: measure.StartTimeSeconds + float64(i)*secondStep,
+				IsDownbeat:  i == 0,
+			})
+		}
+	}
+
+	return beats
+}
+
 // GetTotalDuration returns the total duration of the timeline in ticks
 func (t *Timeline) GetTotalDuration() uint32 {
 	if len(t.Measures) == 0 {
@@ -410,6 +449,9 @@ func (t *Timeline) GetTotalDuration() uint32 {
 // String returns a string representation of the timeline
 func (t *Timeline) String() string {
 	result := fmt.Sprintf("Timeline: %d measures, %d beat notes\n", len(t.Measures), len(t.BeatNotes))
+	if t.Source != "" {
+		result += fmt.Sprintf("Source: %s\n", t.Source)
+	}
 
 	for i, measure := range t.Measures {
 		result += fmt.Sprintf("Measure %d: %d/%d time, %.1f BPM, ticks %d-%d, %.3fs-%.3fs\n",
@@ -423,6 +465,10 @@ func (t *Timeline) String() string {
 			measure.EndTimeSeconds,
 		)
 
+		if measure.Section != "" {
+			result += fmt.Sprintf("  Section: %s\n", measure.Section)
+		}
+
 		// Print beats from this measure's BeatNotes
 		for j, beat := range measure.BeatNotes {
 			result += fmt.Sprintf("  * Beat %d: %.6f\n", j+1, beat.TimeSeconds)
@@ -435,6 +481,12 @@ func (t *Timeline) String() string {
 // QuantizeBPMs takes a timeline with floating-point BPMs and returns a new timeline
 // with integer BPMs selected to minimize cumulative timing drift
 func QuantizeBPMs(timeline *Timeline) *Timeline {
+	return QuantizeBPMsWithWindow(timeline, DefaultQuantizeWindow)
+}
+
+// QuantizeBPMsWithWindow quantizes like QuantizeBPMs, trying every integer BPM
+// within window of the rounded BPM of each measure
+func QuantizeBPMsWithWindow(timeline *Timeline, window int) *Timeline {
 	if len(timeline.Measures) == 0 {
 		return timeline
 	}
@@ -442,10 +494,12 @@ func QuantizeBPMs(timeline *Timeline) *Timeline {
 	quantizedTimeline := &Timeline{
 		BeatNotes:    timeline.BeatNotes, // Keep original beat notes unchanged
 		TicksPerBeat: timeline.TicksPerBeat,
+		AudioOffset:  timeline.AudioOffset,
+		Source:       timeline.Source,
 	}
 
 	quantizedMeasures := make([]Measure, len(timeline.Measures))
-	quantizedCurrentTime := 0.0 // Track cumulative time with quantized BPMs
+	quantizedCurrentTime := timeline.AudioOffset // Track cumulative time with quantized BPMs
 
 	for i, measure := range timeline.Measures {
 		// Copy the original measure
@@ -454,7 +508,7 @@ func QuantizeBPMs(timeline *Timeline) *Timeline {
 		originalBPM := measure.BeatsPerMinute
 
 		// Search range: try BPMs around the original value
-		searchRange := 2                  // Try ±2 BPM from the rounded value
+		searchRange := window             // Try ±window BPM from the rounded value
 		baseBPM := int(originalBPM + 0.5) // Start with simple rounding
 
 		bestBPM := -1
@@ -513,7 +567,8 @@ func abs(x float64) float64 {
 
 // SongInterface implementations
 
-// GetTimeline extracts timeline from MIDI BEAT track
+// GetTimeline extracts timeline from MIDI BEAT track. MIDI files without a usable
+// BEAT track get a timeline synthesized from their tempo and time signature events.
 func (m *MidiFile) GetTimeline() (*Timeline, error) {
 	// Find the BEAT track
 	var beatTrack smf.Track
@@ -529,17 +584,18 @@ func (m *MidiFile) GetTimeline() (*Timeline, error) {
 	}
 
 	if !found {
-		return nil, fmt.Errorf("BEAT track not found")
+		return m.getTimelineFromTempoMap()
 	}
 
 	// Extract beat notes with accurate timing from all tracks
-	beatNotes, err := extractBeatNotesWithTiming(m.SMF, beatTrack)
+	beatNotes, err := extractBeatNotesWithTiming(m.SMF, beatTrack, m.GetAudioOffset())
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract beat notes: %w", err)
 	}
 
 	if len(beatNotes) == 0 {
-		return nil, fmt.Errorf("no beat notes found in BEAT track")
+		log.Printf("Warning: No beat notes found in BEAT track, using tempo map instead\n")
+		return m.getTimelineFromTempoMap()
 	}
 
 	// Get ticks per quarter note for BPM calculations
@@ -555,7 +611,32 @@ func (m *MidiFile) GetTimeline() (*Timeline, error) {
 		Measures:     measures,
 		BeatNotes:    beatNotes,
 		TicksPerBeat: float64(ticksPerQuarter),
+		AudioOffset:  m.GetAudioOffset(),
+		Source:       TimelineSourceBeatTrack,
+	}
+	timeline.setSections(extractMidiSections(m.SMF))
+
+	return timeline, nil
+}
+
+// getTimelineFromTempoMap builds measures and beats from the tempo and time
+// signature meta events, covering the song up to its last event
+func (m *MidiFile) getTimelineFromTempoMap() (*Timeline, error) {
+	tempoMap, err := extractTempoMap(m.SMF, m.GetAudioOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	measures, beatNotes := tempoMap.CreateMeasures(getLastEventTime(m.SMF))
+
+	timeline := &Timeline{
+		Measures:     measures,
+		BeatNotes:    beatNotes,
+		TicksPerBeat: tempoMap.TicksPerQuarter,
+		AudioOffset:  m.GetAudioOffset(),
+		Source:       TimelineSourceTempoMap,
 	}
+	timeline.setSections(extractMidiSections(m.SMF))
 
 	return timeline, nil
 }
@@ -578,7 +659,10 @@ func (c *ChartFile) GetTimeline() (*Timeline, error) {
 		Measures:     measures,
 		BeatNotes:    []BeatNote{}, // Empty for chart-based timelines
 		TicksPerBeat: float64(c.Song.Resolution),
+		AudioOffset:  c.GetAudioOffset(),
+		Source:       TimelineSourceChart,
 	}
+	timeline.setSections(extractChartSections(c))
 
 	return timeline, nil
 }
@@ -597,19 +681,12 @@ func (c *ChartFile) GetLyricsByMeasure() ([]MeasureLyrics, error) {
 
 	// Extract lyric events with timing from Chart file GlobalEvents
 	var lyricEvents []LyricEvent
-	for _, event := range c.Events.GlobalEvents {
-		// Look for events that start with "lyric "
-		if strings.HasPrefix(event.Text, "lyric ") {
-			// Extract the lyric text after "lyric " prefix
-			lyricText := strings.TrimPrefix(event.Text, "lyric ")
-			lyricText = strings.TrimSpace(lyricText)
-
-			if lyricText != "" {
-				lyricEvents = append(lyricEvents, LyricEvent{
-					Time:  event.Tick,
-					Lyric: lyricText,
-				})
-			}
+	for _, event := range c.GlobalEventsOfType(ChartEventLyric) {
+		if event.Value != "" {
+			lyricEvents = append(lyricEvents, LyricEvent{
+				Time:  event.Tick,
+				Lyric: event.Value,
+			})
 		}
 	}
 
@@ -624,54 +701,25 @@ func (c *ChartFile) GetLyricsByMeasure() ([]MeasureLyrics, error) {
 
 // GetTimeline extracts timeline information from SNG file
 func (s *SngFile) GetTimeline() (*Timeline, error) {
-	// Try to extract from MIDI file first
-	midiData, midiErr := s.ReadFile("notes.mid")
-	if midiErr == nil {
-		smfData, err := smf.ReadFrom(bytes.NewReader(midiData))
-		if err == nil {
-			midiFile := &MidiFile{SMF: smfData}
-			return midiFile.GetTimeline()
-		}
-	}
-
-	// Fall back to chart file
-	chartData, chartErr := s.ReadFile("notes.chart")
-	if chartErr == nil {
-		chartFile, err := ParseChartFile(bytes.NewReader(chartData))
-		if err == nil {
-			return chartFile.GetTimeline()
-		}
+	midiFile, chartFile, err := s.loadNotes()
+	if err != nil {
+		return nil, err
 	}
-
-	// Return error if neither worked
-	if midiErr != nil && chartErr != nil {
-		return nil, fmt.Errorf("no MIDI or chart file found in SNG package")
+	if midiFile != nil {
+		return midiFile.GetTimeline()
 	}
-
-	return nil, fmt.Errorf("failed to parse timeline from SNG file")
+	return chartFile.GetTimeline()
 }
 
 // GetLyricsByMeasure extracts lyrics from SNG file and groups them by measure
 func (s *SngFile) GetLyricsByMeasure() ([]MeasureLyrics, error) {
-	// Try to extract from MIDI file first
-	midiData, midiErr := s.ReadFile("notes.mid")
-	if midiErr == nil {
-		smfData, err := smf.ReadFrom(bytes.NewReader(midiData))
-		if err == nil {
-			midiFile := &MidiFile{SMF: smfData}
-			return midiFile.GetLyricsByMeasure()
-		}
+	midiFile, chartFile, err := s.loadNotes()
+	if err != nil {
+		// Return empty if neither file could be read
+		return []MeasureLyrics{}, nil
 	}
-
-	// Fall back to chart file
-	chartData, chartErr := s.ReadFile("notes.chart")
-	if chartErr == nil {
-		chartFile, err := ParseChartFile(bytes.NewReader(chartData))
-		if err == nil {
-			return chartFile.GetLyricsByMeasure()
-		}
+	if midiFile != nil {
+		return midiFile.GetLyricsByMeasure()
 	}
-
-	// Return empty if neither worked
-	return []MeasureLyrics{}, nil
+	return chartFile.GetLyricsByMeasure()
 }
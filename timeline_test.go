@@ -0,0 +1,578 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// createTempoMapMidiFile creates a MIDI file without a BEAT track: two bars of
+// 4/4 at 120 BPM followed by two bars of 3/4 at 90 BPM
+func createTempoMapMidiFile() *smf.SMF {
+	smfData := smf.NewSMF1()
+	smfData.TimeFormat = smf.MetricTicks(480)
+
+	var conductor smf.Track
+	conductor.Add(0, smf.MetaTrackSequenceName("Tempo"))
+	conductor.Add(0, smf.MetaTempo(120))
+	conductor.Add(0, smf.MetaTimeSig(4, 4, 24, 8))
+	conductor.Add(3840, smf.MetaTempo(90))
+	conductor.Add(0, smf.MetaTimeSig(3, 4, 24, 8))
+	conductor.Add(2880, smf.MetaText("end"))
+	conductor.Close(0)
+	smfData.Add(conductor)
+
+	return smfData
+}
+
+func TestGetTimeline_SynthesizesFromTempoMap(t *testing.T) {
+	song := &MidiFile{SMF: createTempoMapMidiFile()}
+
+	timeline, err := song.GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+
+	if timeline.Source != TimelineSourceTempoMap {
+		t.Errorf("expected source %q, got %q", TimelineSourceTempoMap, timeline.Source)
+	}
+
+	if len(timeline.Measures) != 4 {
+		t.Fatalf("expected 4 measures, got %d", len(timeline.Measures))
+	}
+
+	expected := []struct {
+		beats int
+		start float64
+		bpm   float64
+	}{
+		{4, 0, 120},
+		{4, 2, 120},
+		{3, 4, 90},
+		{3, 6, 90},
+	}
+
+	for i, want := range expected {
+		measure := timeline.Measures[i]
+		if measure.BeatsPerMeasure != want.beats {
+			t.Errorf("measure %d: expected %d beats, got %d", i+1, want.beats, measure.BeatsPerMeasure)
+		}
+		if math.Abs(measure.StartTimeSeconds-want.start) > 1e-3 {
+			t.Errorf("measure %d: expected start %.3fs, got %.3fs", i+1, want.start, measure.StartTimeSeconds)
+		}
+		if math.Abs(measure.BeatsPerMinute-want.bpm) > 1e-3 {
+			t.Errorf("measure %d: expected %.1f BPM, got %.1f", i+1, want.bpm, measure.BeatsPerMinute)
+		}
+	}
+
+	if len(timeline.BeatNotes) != 14 {
+		t.Errorf("expected 14 beat notes, got %d", len(timeline.BeatNotes))
+	}
+	if !timeline.BeatNotes[8].IsDownbeat || timeline.BeatNotes[9].IsDownbeat {
+		t.Errorf("expected beat 9 to be the downbeat of measure 3")
+	}
+}
+
+func TestTempoMapSecondsAt(t *testing.T) {
+	tempoMap := &TempoMap{
+		TicksPerQuarter: 480,
+		Tempos:          []TempoChange{{Tick: 0, BPM: 120}, {Tick: 960, BPM: 60}},
+		AudioOffset:     0.5,
+	}
+
+	testCases := []struct {
+		tick     uint32
+		expected float64
+	}{
+		{0, 0.5},
+		{480, 1.0},
+		{960, 1.5},
+		{1440, 2.5},
+	}
+
+	for _, tc := range testCases {
+		if actual := tempoMap.SecondsAt(tc.tick); math.Abs(actual-tc.expected) > 1e-9 {
+			t.Errorf("SecondsAt(%d): expected %.3f, got %.3f", tc.tick, tc.expected, actual)
+		}
+	}
+}
+
+func TestCheckBeatTrack(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+
+	// Beats for the first two 4/4 bars at 480 ticks each, with the second downbeat
+	// marked as a regular beat and the last beat of the first bar missing
+	beats := []BeatNote{
+		{Time: 0, IsDownbeat: true},
+		{Time: 480},
+		{Time: 960},
+		{Time: 1920},
+		{Time: 2400},
+		{Time: 2880},
+		{Time: 3360},
+	}
+	smfData.Add(createBeatTrack(beats, 480))
+
+	song := &MidiFile{SMF: smfData}
+	report, err := song.CheckBeatTrack()
+	if err != nil {
+		t.Fatalf("CheckBeatTrack failed: %v", err)
+	}
+
+	if report.Measures != 4 || report.ExpectedBeats != 14 {
+		t.Errorf("expected 4 measures and 14 beats, got %d and %d", report.Measures, report.ExpectedBeats)
+	}
+
+	expectedKinds := []string{BeatIssueMissingBeat, BeatIssueDownbeatAsBeat}
+	// The 3/4 bars have no beat notes at all
+	for i := 0; i < 2; i++ {
+		expectedKinds = append(expectedKinds, BeatIssueMissingDownbeat, BeatIssueMissingBeat, BeatIssueMissingBeat)
+	}
+
+	if len(report.Issues) != len(expectedKinds) {
+		t.Fatalf("expected %d issues, got %d: %+v", len(expectedKinds), len(report.Issues), report.Issues)
+	}
+	for i, kind := range expectedKinds {
+		if report.Issues[i].Kind != kind {
+			t.Errorf("issue %d: expected %s, got %s", i, kind, report.Issues[i].Kind)
+		}
+	}
+	if report.Issues[0].Measure != 1 || report.Issues[0].Tick != 1440 {
+		t.Errorf("expected missing beat in measure 1 at tick 1440, got measure %d tick %d", report.Issues[0].Measure, report.Issues[0].Tick)
+	}
+
+	repaired, err := song.RepairBeatTrack()
+	if err != nil {
+		t.Fatalf("RepairBeatTrack failed: %v", err)
+	}
+
+	repairedReport, err := (&MidiFile{SMF: repaired}).CheckBeatTrack()
+	if err != nil {
+		t.Fatalf("CheckBeatTrack on repaired file failed: %v", err)
+	}
+	if len(repairedReport.Issues) != 0 {
+		t.Errorf("expected no issues after repair, got %+v", repairedReport.Issues)
+	}
+}
+
+func TestRepairBeatTrackKeepsAuthorNotes(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+
+	// Author notes with their own velocity and length: a note off the grid at
+	// tick 240, a downbeat note inside the first bar and nothing past bar 2
+	var beat smf.Track
+	beat.Add(0, smf.MetaTrackSequenceName("BEAT"))
+	notes := []struct {
+		tick uint32
+		key  uint8
+	}{{0, beatNoteDownbeat}, {240, beatNoteBeat}, {480, beatNoteBeat}, {960, beatNoteBeat}, {1440, beatNoteDownbeat}}
+	var lastTime uint32
+	for _, note := range notes {
+		beat.Add(note.tick-lastTime, midi.NoteOn(1, note.key, 64))
+		beat.Add(60, midi.NoteOff(1, note.key))
+		lastTime = note.tick + 60
+	}
+	beat.Close(0)
+	smfData.Add(beat)
+
+	repaired, err := (&MidiFile{SMF: smfData}).RepairBeatTrack()
+	if err != nil {
+		t.Fatalf("RepairBeatTrack failed: %v", err)
+	}
+
+	report, err := (&MidiFile{SMF: repaired}).CheckBeatTrack()
+	if err != nil {
+		t.Fatalf("CheckBeatTrack on repaired file failed: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("expected no issues after repair, got %+v", report.Issues)
+	}
+
+	type noteOn struct {
+		tick uint32
+		key  uint8
+		vel  uint8
+	}
+	var found []noteOn
+	var tick uint32
+	for _, event := range repaired.Tracks[len(repaired.Tracks)-1] {
+		tick += event.Delta
+		var ch, key, vel uint8
+		if event.Message.GetNoteStart(&ch, &key, &vel) && tick < 1920 {
+			found = append(found, noteOn{tick, key, vel})
+		}
+	}
+
+	// The off-grid note is gone, the inner downbeat is a beat and the author
+	// notes keep their velocity
+	expected := []noteOn{{0, beatNoteDownbeat, 64}, {480, beatNoteBeat, 64}, {960, beatNoteBeat, 64}, {1440, beatNoteBeat, 64}}
+	if len(found) != len(expected) {
+		t.Fatalf("expected notes %v in the first bar, got %v", expected, found)
+	}
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Errorf("note %d: expected %v, got %v", i, expected[i], found[i])
+		}
+	}
+}
+
+func TestQuantizeBPMsWithWindow(t *testing.T) {
+	timeline := &Timeline{
+		Measures: []Measure{
+			{StartTimeSeconds: 0, EndTimeSeconds: 2.05, BeatsPerMeasure: 4, BeatsPerMinute: 117.07},
+			{StartTimeSeconds: 2.05, EndTimeSeconds: 4.10, BeatsPerMeasure: 4, BeatsPerMinute: 117.07},
+		},
+	}
+
+	// With no window the BPM is simply rounded
+	rounded := QuantizeBPMsWithWindow(timeline, 0)
+	for i, measure := range rounded.Measures {
+		if measure.BeatsPerMinute != 117 {
+			t.Errorf("measure %d: expected 117 BPM with window 0, got %.1f", i+1, measure.BeatsPerMinute)
+		}
+	}
+
+	quantized := QuantizeBPMsWithWindow(timeline, 2)
+	report := CalculateDriftReport(timeline, quantized, QuantizeMethodGreedy, 2)
+
+	if len(report.Measures) != 2 {
+		t.Fatalf("expected 2 measures in drift report, got %d", len(report.Measures))
+	}
+
+	for _, measure := range report.Measures {
+		expected := measure.QuantizedEndSeconds - measure.OriginalEndSeconds
+		if math.Abs(measure.DriftSeconds-expected) > 1e-9 {
+			t.Errorf("measure %d: drift %.6f does not match end times", measure.Measure, measure.DriftSeconds)
+		}
+		if math.Abs(measure.DriftSeconds) > 0.01 {
+			t.Errorf("measure %d: expected drift under 10ms, got %.1fms", measure.Measure, measure.DriftSeconds*1000)
+		}
+	}
+
+	if report.FinalDriftSeconds != report.Measures[1].DriftSeconds {
+		t.Errorf("expected final drift to match last measure")
+	}
+	if report.MaxDriftMeasure < 1 || report.MaxDriftMeasure > 2 {
+		t.Errorf("unexpected max drift measure %d", report.MaxDriftMeasure)
+	}
+}
+
+func TestQuantizeBPMsGlobal(t *testing.T) {
+	// A steady 120 BPM song whose measures wobble slightly around the true tempo,
+	// the way beat tracks snapped to a coarse grid do
+	bpms := []float64{120.4, 119.6, 120.4, 119.6, 120.4, 119.6, 120.4, 119.6}
+	timeline := &Timeline{}
+	currentTime := 0.0
+	for _, bpm := range bpms {
+		duration := 4 * 60.0 / bpm
+		timeline.Measures = append(timeline.Measures, Measure{
+			StartTimeSeconds: currentTime,
+			EndTimeSeconds:   currentTime + duration,
+			BeatsPerMeasure:  4,
+			BeatsPerMinute:   bpm,
+		})
+		currentTime += duration
+	}
+
+	quantized := QuantizeBPMsGlobal(timeline, DefaultQuantizeWindow, DefaultBPMChangePenalty)
+	for i, measure := range quantized.Measures {
+		if measure.BeatsPerMinute != 120 {
+			t.Errorf("measure %d: expected steady 120 BPM, got %.0f", i+1, measure.BeatsPerMinute)
+		}
+	}
+
+	report := CalculateDriftReport(timeline, quantized, QuantizeMethodGlobal, DefaultQuantizeWindow)
+	if report.BPMChanges != 0 {
+		t.Errorf("expected no BPM changes, got %d", report.BPMChanges)
+	}
+
+	// A song that speeds up steadily must follow the audio instead of holding one BPM
+	timeline = &Timeline{}
+	currentTime = 0.0
+	for i := 0; i < 16; i++ {
+		bpm := 100.0 + float64(i)*2
+		duration := 4 * 60.0 / bpm
+		timeline.Measures = append(timeline.Measures, Measure{
+			StartTimeSeconds: currentTime,
+			EndTimeSeconds:   currentTime + duration,
+			BeatsPerMeasure:  4,
+			BeatsPerMinute:   bpm,
+		})
+		currentTime += duration
+	}
+
+	quantized = QuantizeBPMsGlobal(timeline, DefaultQuantizeWindow, DefaultBPMChangePenalty)
+	report = CalculateDriftReport(timeline, quantized, QuantizeMethodGlobal, DefaultQuantizeWindow)
+	if report.MaxDriftSeconds > 0.1 {
+		t.Errorf("expected accelerating song to stay within 100ms, max drift %.1fms", report.MaxDriftSeconds*1000)
+	}
+	if report.BPMChanges == 0 {
+		t.Errorf("expected accelerating song to change BPM")
+	}
+
+	// The search must find the cheapest tempo map, compare against trying every
+	// combination of candidate BPMs on short songs with uneven tempos
+	songs := [][]float64{
+		{97.3, 98.8, 96.6, 99.4, 97.9, 98.2},
+		{140.45, 139.55, 141.3, 138.7, 140.5, 139.5},
+		{88.2, 91.7, 90.1, 86.4, 92.6, 89.5},
+	}
+	for _, bpms := range songs {
+		timeline = &Timeline{}
+		currentTime = 0.0
+		for _, bpm := range bpms {
+			duration := 4 * 60.0 / bpm
+			timeline.Measures = append(timeline.Measures, Measure{
+				StartTimeSeconds: currentTime,
+				EndTimeSeconds:   currentTime + duration,
+				BeatsPerMeasure:  4,
+				BeatsPerMinute:   bpm,
+			})
+			currentTime += duration
+		}
+
+		window := 1
+		quantized = QuantizeBPMsGlobal(timeline, window, DefaultBPMChangePenalty)
+		cost := quantizeCost(timeline, quantized, DefaultBPMChangePenalty)
+
+		best := math.Inf(1)
+		choice := make([]int, len(bpms))
+		var search func(i int)
+		search = func(i int) {
+			if i == len(bpms) {
+				best = math.Min(best, quantizeCost(timeline, applyQuantizedBPMs(timeline, choice), DefaultBPMChangePenalty))
+				return
+			}
+			candidates := bpmCandidates(bpms[i], window)
+			if i > 0 {
+				for _, bpm := range bpmCandidates(bpms[i-1], window) {
+					if !containsInt(candidates, bpm) {
+						candidates = append(candidates, bpm)
+					}
+				}
+			}
+			for _, bpm := range candidates {
+				choice[i] = bpm
+				search(i + 1)
+			}
+		}
+		search(0)
+
+		if cost > best+float64(len(bpms))*quantizeTimeBucket {
+			t.Errorf("%v: expected cost %.4f, got %.4f", bpms, best, cost)
+		}
+	}
+}
+
+func TestTimelineSections(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+
+	var events smf.Track
+	events.Add(0, smf.MetaTrackSequenceName("EVENTS"))
+	events.Add(0, smf.MetaText("[section intro]"))
+	events.Add(0, smf.MetaText("[music_start]"))
+	events.Add(3840, smf.MetaText("[prc_verse_1]"))
+	events.Add(1440, smf.MetaText("[prc_chorus]"))
+	events.Close(0)
+	smfData.Add(events)
+
+	timeline, err := (&MidiFile{SMF: smfData}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+
+	if len(timeline.Sections) != 3 {
+		t.Fatalf("expected 3 sections, got %+v", timeline.Sections)
+	}
+
+	// Two bars of 4/4 at 120 BPM, then a bar of 3/4 at 90 BPM
+	expected := []Section{
+		{Tick: 0, Seconds: 0, Name: "intro"},
+		{Tick: 3840, Seconds: 4, Name: "verse_1"},
+		{Tick: 5280, Seconds: 6, Name: "chorus"},
+	}
+	for i, want := range expected {
+		got := timeline.Sections[i]
+		if got.Tick != want.Tick || got.Name != want.Name || math.Abs(got.Seconds-want.Seconds) > 1e-3 {
+			t.Errorf("section %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+
+	if timeline.Measures[0].Section != "intro" || timeline.Measures[1].Section != "" || timeline.Measures[2].Section != "verse_1" {
+		t.Errorf("unexpected measure sections: %q, %q, %q", timeline.Measures[0].Section, timeline.Measures[1].Section, timeline.Measures[2].Section)
+	}
+
+	bars := createBarIndexFromTimeline(timeline).Bars
+	if bars[2].Label == nil || bars[2].Label.Text != "verse_1" || bars[1].Label != nil {
+		t.Errorf("expected a ToneLib label on bar 3 only, got %+v", bars)
+	}
+
+	track := addSectionMarkers(smfData.Tracks[0], timeline.Sections)
+	var markers []string
+	var currentTime uint32
+	for _, event := range track {
+		currentTime += event.Delta
+		var text string
+		if event.Message.GetMetaMarker(&text) {
+			markers = append(markers, text)
+			if text == "chorus" && currentTime != 5280 {
+				t.Errorf("expected chorus marker at 5280, got %d", currentTime)
+			}
+		}
+	}
+	if len(markers) != 3 || !track[len(track)-1].Message.Is(smf.MetaEndOfTrackMsg) {
+		t.Errorf("expected 3 markers before the end of track, got %v", markers)
+	}
+}
+
+func TestSongSlice(t *testing.T) {
+	smfData := createTempoMapMidiFile()
+
+	var events smf.Track
+	events.Add(0, smf.MetaTrackSequenceName("EVENTS"))
+	events.Add(0, smf.MetaText("[section intro]"))
+	events.Add(3840, smf.MetaText("[prc_verse_1]"))
+	events.Add(1440, smf.MetaText("[prc_chorus]"))
+	events.Close(0)
+	smfData.Add(events)
+
+	// A note held over the bar line between the 4/4 and 3/4 sections
+	var drums smf.Track
+	drums.Add(0, smf.MetaTrackSequenceName("PART DRUMS"))
+	drums.Add(3600, midi.NoteOn(0, 96, 100))
+	drums.Add(600, midi.NoteOff(0, 96))
+	drums.Close(0)
+	smfData.Add(drums)
+
+	timeline, err := (&MidiFile{SMF: smfData}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline failed: %v", err)
+	}
+
+	tests := []struct {
+		from, to   string
+		start, end int
+	}{
+		{"", "", 1, 4},
+		{"2", "3.5s", 2, 2},
+		{"verse_1", "VERSE_1", 3, 3},
+		{"4.5s", "chorus", 3, 4},
+	}
+	for _, tt := range tests {
+		slice, err := ResolveSongSlice(timeline, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("ResolveSongSlice(%q, %q) failed: %v", tt.from, tt.to, err)
+			continue
+		}
+		if slice.StartMeasure != tt.start || slice.EndMeasure != tt.end {
+			t.Errorf("ResolveSongSlice(%q, %q): expected measures %d-%d, got %d-%d", tt.from, tt.to, tt.start, tt.end, slice.StartMeasure, slice.EndMeasure)
+		}
+	}
+
+	for _, bad := range [][2]string{{"4", "2"}, {"5", ""}, {"bridge", ""}, {"", "20s"}} {
+		if _, err := ResolveSongSlice(timeline, bad[0], bad[1]); err == nil {
+			t.Errorf("ResolveSongSlice(%q, %q): expected an error", bad[0], bad[1])
+		}
+	}
+
+	// Measure 3 starts on the tempo change, which must survive at tick 0
+	slice, _ := ResolveSongSlice(timeline, "verse_1", "verse_1")
+	if math.Abs(slice.StartSeconds-4) > 1e-3 || math.Abs(slice.EndSeconds-6) > 1e-3 || slice.AudioOffset() != 0 {
+		t.Errorf("unexpected slice timing %+v", slice)
+	}
+
+	sliced, err := SliceMidi(smfData, slice)
+	if err != nil {
+		t.Fatalf("SliceMidi failed: %v", err)
+	}
+	slicedTimeline, err := (&MidiFile{SMF: sliced}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline of slice failed: %v", err)
+	}
+	if len(slicedTimeline.Measures) != 1 || slicedTimeline.Measures[0].BeatsPerMeasure != 3 || math.Abs(slicedTimeline.Measures[0].BeatsPerMinute-90) > 1e-3 {
+		t.Errorf("expected a single 3/4 measure at 90 BPM, got %+v", slicedTimeline.Measures)
+	}
+	if len(slicedTimeline.Sections) != 1 || slicedTimeline.Sections[0].Name != "verse_1" || slicedTimeline.Sections[0].Tick != 0 {
+		t.Errorf("expected verse_1 at tick 0, got %+v", slicedTimeline.Sections)
+	}
+
+	// The held note restarts at tick 0 and stops where it did in the song
+	var noteOns, noteOffs []uint32
+	var currentTime uint32
+	for _, event := range sliced.Tracks[2] {
+		currentTime += event.Delta
+		var channel, key, velocity uint8
+		if event.Message.GetNoteOn(&channel, &key, &velocity) {
+			noteOns = append(noteOns, currentTime)
+		} else if event.Message.GetNoteOff(&channel, &key, &velocity) {
+			noteOffs = append(noteOffs, currentTime)
+		}
+	}
+	if len(noteOns) != 1 || noteOns[0] != 0 || len(noteOffs) != 1 || noteOffs[0] != 360 {
+		t.Errorf("expected the held note from 0 to 360, got on %v off %v", noteOns, noteOffs)
+	}
+
+	// Measure 2 carries the 4/4 tempo and cuts the note off at the end
+	slice, _ = ResolveSongSlice(timeline, "2", "2")
+	sliced, err = SliceMidi(smfData, slice)
+	if err != nil {
+		t.Fatalf("SliceMidi failed: %v", err)
+	}
+	slicedTimeline, err = (&MidiFile{SMF: sliced}).GetTimeline()
+	if err != nil {
+		t.Fatalf("GetTimeline of slice failed: %v", err)
+	}
+	if len(slicedTimeline.Measures) != 1 || slicedTimeline.Measures[0].BeatsPerMeasure != 4 || math.Abs(slicedTimeline.Measures[0].BeatsPerMinute-120) > 1e-3 {
+		t.Errorf("expected a single 4/4 measure at 120 BPM, got %+v", slicedTimeline.Measures)
+	}
+	if len(slicedTimeline.Sections) != 1 || slicedTimeline.Sections[0].Name != "intro" {
+		t.Errorf("expected intro carried over to tick 0, got %+v", slicedTimeline.Sections)
+	}
+
+	currentTime = 0
+	for _, event := range sliced.Tracks[2] {
+		currentTime += event.Delta
+		var channel, key, velocity uint8
+		if event.Message.GetNoteOff(&channel, &key, &velocity) && currentTime != 1920 {
+			t.Errorf("expected the note to be cut off at 1920, got %d", currentTime)
+		}
+	}
+}
+
+func TestQuantizeBPMsGlobalLongSong(t *testing.T) {
+	// A live recording whose tempo wanders and wobbles measure to measure. The
+	// search must stay fast on long songs at a wide window and still beat greedy.
+	random := rand.New(rand.NewSource(1))
+	timeline := &Timeline{}
+	currentTime := 0.0
+	tempo := 120.0
+	for i := 0; i < 400; i++ {
+		tempo += random.Float64() - 0.5
+		bpm := tempo + (random.Float64()-0.5)*1.5
+		duration := 4 * 60.0 / bpm
+		timeline.Measures = append(timeline.Measures, Measure{
+			StartTimeSeconds: currentTime,
+			EndTimeSeconds:   currentTime + duration,
+			BeatsPerMeasure:  4,
+			BeatsPerMinute:   bpm,
+		})
+		currentTime += duration
+	}
+
+	window := 5
+	start := time.Now()
+	quantized := QuantizeBPMsGlobal(timeline, window, DefaultBPMChangePenalty)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected 400 measures to quantize within 2s, took %s", elapsed)
+	}
+
+	greedy := QuantizeBPMsWithWindow(timeline, window)
+	cost := quantizeCost(timeline, quantized, DefaultBPMChangePenalty)
+	if greedyCost := quantizeCost(timeline, greedy, DefaultBPMChangePenalty); cost > greedyCost {
+		t.Errorf("expected cost at most greedy's %.4f, got %.4f", greedyCost, cost)
+	}
+}
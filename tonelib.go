@@ -135,6 +135,7 @@ const (
 	ToneLibDrumColor    = "fffad11c" // Orange
 	ToneLibBassColor    = "ff0000ff" // Blue
 	ToneLibLyricsColor  = "ff00ff00" // Green
+	ToneLibVocalsColor  = "ffff00ff" // Magenta
 	ToneLibBackingColor = "ff40a0a0" // Teal
 )
 
@@ -523,6 +524,7 @@ type ToneLibText struct {
 // Effects container
 type ToneLibEffects struct {
 	Ghost string        `xml:"ghost,attr,omitempty"`
+	Dead  string        `xml:"dead,attr,omitempty"` // Rendered with an x notehead
 	Grace *ToneLibGrace `xml:"Grace,omitempty"`
 }
 
@@ -640,6 +642,10 @@ func createBarIndexFromTimeline(timeline *Timeline) ToneLibBarIndex {
 			}
 		}
 
+		if measure.Section != "" {
+			bar.Label = &ToneLibLabel{Text: measure.Section}
+		}
+
 		bars[i] = bar
 	}
 
@@ -658,13 +664,16 @@ func createTracksFromMidi(midiFile *smf.SMF, numBars int, timeline *Timeline) To
 		TrackID:  &trackID,
 	}
 
-	// Create tracks in order: lyrics, drums, bass
+	// Create tracks in order: lyrics, harmony lyrics, vocal melodies, bass, drums
 	midiFileWrapper := &MidiFile{SMF: midiFile}
 	if lyricsTrack := createLyricsTrack(midiFileWrapper, numBars, trackID, timeline); lyricsTrack != nil {
 		tracks = append(tracks, *lyricsTrack)
 		trackID++
 	}
 
+	tracks = append(tracks, createHarmonyLyricsTracks(ctx)...)
+	tracks = append(tracks, createVocalMelodyTracks(ctx)...)
+
 	if bassTrack := createBassTrackFromMidi(ctx); bassTrack != nil {
 		tracks = append(tracks, *bassTrack)
 	}
@@ -873,8 +882,36 @@ func createLyricsTrack(song SongInterface, numBars int, trackID int, timeline *T
 		return nil
 	}
 
+	return newLyricsTrack("Lyrics", measureLyrics, numBars, trackID, timeline)
+}
+
+// createHarmonyLyricsTracks creates a lyrics track for each harmony part that has lyrics
+func createHarmonyLyricsTracks(ctx *TrackCreationContext) []ToneLibTrack {
+	var tracks []ToneLibTrack
+
+	for i, name := range harmonyPartNames {
+		lyricEvents := extractTrackLyricsWithTiming(ctx.MidiFile, name)
+		if len(lyricEvents) == 0 {
+			continue
+		}
+
+		measureLyrics := groupLyricsByMeasure(lyricEvents, ctx.Timeline)
+		if len(measureLyrics) == 0 {
+			continue
+		}
+
+		trackName := fmt.Sprintf("Harmony %d Lyrics", i+1)
+		tracks = append(tracks, *newLyricsTrack(trackName, measureLyrics, ctx.NumBars, *ctx.TrackID, ctx.Timeline))
+		*ctx.TrackID++
+	}
+
+	return tracks
+}
+
+// newLyricsTrack builds a ToneLib track that only carries lyrics
+func newLyricsTrack(name string, measureLyrics []MeasureLyrics, numBars int, trackID int, timeline *Timeline) *ToneLibTrack {
 	toneLibTrack := ToneLibTrack{
-		Name:     "Lyrics",
+		Name:     name,
 		Color:    ToneLibLyricsColor,
 		Visible:  1,
 		Collapse: 0,
@@ -966,6 +1003,7 @@ var (
 	DrumTuning   = []int{0, 0, 0, 0, 0, 0}       // All drums use tuning 0
 	BassTuning   = []int{43, 38, 33, 28}         // G, D, A, E (high to low)
 	GuitarTuning = []int{64, 59, 55, 50, 45, 40} // E, B, G, D, A, E (high to low)
+	VocalTuning  = []int{64, 59, 55, 50, 45, 36} // Guitar tuning with the low string dropped to C to reach the bottom of the vocal range
 )
 
 func createStringsWithTuning(tunings []int) ToneLibStrings {
@@ -1085,7 +1123,8 @@ func createZipEntryWithCurrentTime(w *zip.Writer, name string) (io.Writer, error
 	return w.CreateHeader(header)
 }
 
-// Generate and write a complete ToneLib .song ZIP archive to the writer
+// Generate and write a complete ToneLib .song ZIP archive to the writer. The
+// archive has no album art, the format has no known entry for images.
 func WriteToneLibSongTo(writer io.Writer, song SongInterface) error {
 	zipWriter := zip.NewWriter(writer)
 	defer zipWriter.Close()
@@ -1172,23 +1211,31 @@ func processAudioForZip(zipWriter *zip.Writer, sngFile *SngFile) (*AudioProcessi
 	}, nil
 }
 
-// generateBeatsFromTimeline generates beats from timeline data instead of audio analysis
-func generateBeatsFromTimeline(timeline *Timeline) *BeatMap {
-	if timeline == nil || len(timeline.BeatNotes) == 0 {
+// generateBeatsFromTimeline generates beats from timeline data instead of audio analysis.
+// Beat times are positions in the backing audio, so the audio offset is already included
+// through the timeline. audioDelay shifts every beat when the audio is placed later than
+// the start of the score.
+func generateBeatsFromTimeline(timeline *Timeline, audioDelay float64) *BeatMap {
+	if timeline == nil {
+		return nil
+	}
+
+	beatNotes := timeline.GetBeats()
+	if len(beatNotes) == 0 {
 		return nil
 	}
 
-	beats := make([]ToneLibBackingBeat, len(timeline.BeatNotes))
+	beats := make([]ToneLibBackingBeat, len(beatNotes))
 	beatInMeasure := 0
 
-	for i, beatNote := range timeline.BeatNotes {
+	for i, beatNote := range beatNotes {
 		if beatNote.IsDownbeat {
 			beatInMeasure = 0
 		}
 
 		beats[i] = ToneLibBackingBeat{
 			N: beatInMeasure,
-			T: fmt.Sprintf("%.15f", beatNote.TimeSeconds), // High precision for timing
+			T: fmt.Sprintf("%.15f", beatNote.TimeSeconds+audioDelay), // High precision for timing
 		}
 
 		beatInMeasure++
@@ -1202,6 +1249,16 @@ func generateBeatsFromTimeline(timeline *Timeline) *BeatMap {
 	}
 }
 
+// backingAudioDelay returns how far the backing audio must be pushed back so that
+// tick 0 of the score lines up with it. Only negative offsets (audio that starts
+// after tick 0) need a delay; positive offsets are handled by the beat map.
+func backingAudioDelay(timeline *Timeline) float64 {
+	if timeline == nil || timeline.AudioOffset >= 0 {
+		return 0
+	}
+	return -timeline.AudioOffset
+}
+
 // writeToneLibXMLToZip creates and writes the_song.dat XML file to the ZIP
 func writeToneLibXMLToZip(zipWriter *zip.Writer, song SongInterface,
 	audioResult *AudioProcessingResult) error {
@@ -1257,25 +1314,18 @@ func createBackingTrack(sngFile *SngFile) *ToneLibBackingTrack {
 		return nil
 	}
 
-	// Check for any opus files in SNG
-	files := sngFile.ListFiles()
-	hasOpusFiles := false
-	for _, filename := range files {
-		if strings.HasSuffix(filename, ".opus") {
-			hasOpusFiles = true
-			break
-		}
-	}
-
-	if !hasOpusFiles {
+	// Check for any audio stems in SNG
+	if len(sngFile.AudioStems()) == 0 {
 		return nil
 	}
 
 	// Generate beatMap from SNG file's timeline
 	timeline, err := sngFile.GetTimeline()
 	var beatMap *BeatMap
+	var audioDelay float64
 	if err == nil {
-		beatMap = generateBeatsFromTimeline(timeline)
+		audioDelay = backingAudioDelay(timeline)
+		beatMap = generateBeatsFromTimeline(timeline, audioDelay)
 	}
 
 	// Create bars structure with beat map data if available
@@ -1304,7 +1354,7 @@ func createBackingTrack(sngFile *SngFile) *ToneLibBackingTrack {
 			Name:        ToneLibAudioName,
 			DataFile:    ToneLibAudioDataFile,
 			DataLen:     0, // Will be updated with actual converted size
-			TimeOffset:  "0",
+			TimeOffset:  fmt.Sprintf("%g", audioDelay),
 			Gain:        "1",
 			ChannelMode: 0,
 			Bars:        bars,
@@ -1330,19 +1380,12 @@ func createToneLibScore(song SongInterface) *ToneLibScore {
 	case *MidiFile:
 		score.Tracks = createTracksFromMidi(s.SMF, numBars, timeline)
 	case *SngFile:
-		// For SNG files, extract MIDI and create tracks
-		midiData, err := s.ReadFile("notes.mid")
-		if err == nil {
-			if smfData, err := smf.ReadFrom(bytes.NewReader(midiData)); err == nil {
-				score.Tracks = createTracksFromMidi(smfData, numBars, timeline)
-			}
-		} else {
-			// Try chart file if MIDI is not available
-			chartData, chartErr := s.ReadFile("notes.chart")
-			if chartErr == nil {
-				if chartFile, err := ParseChartFile(bytes.NewReader(chartData)); err == nil {
-					score.Tracks = createTracksFromChart(chartFile, numBars, timeline)
-				}
+		// For SNG files, create tracks from the MIDI or chart file in the package
+		if midiFile, chartFile, err := s.loadNotes(); err == nil {
+			if midiFile != nil {
+				score.Tracks = createTracksFromMidi(midiFile.SMF, numBars, timeline)
+			} else {
+				score.Tracks = createTracksFromChart(chartFile, numBars, timeline)
 			}
 		}
 	case *ChartFile:
@@ -1376,22 +1419,15 @@ func createToneLibScore(song SongInterface) *ToneLibScore {
 
 // extractLyricsWithTiming extracts lyric events with timing from PART VOCALS track
 func extractLyricsWithTiming(midiFile *smf.SMF) []LyricEvent {
-	var lyricEvents []LyricEvent
-
-	// Find the PART VOCALS track
-	var vocalTrack smf.Track
-	var found bool
+	return extractTrackLyricsWithTiming(midiFile, VocalPartLead)
+}
 
-	for _, track := range midiFile.Tracks {
-		trackName := getTrackName(track)
-		if trackName == "PART VOCALS" {
-			vocalTrack = track
-			found = true
-			break
-		}
-	}
+// extractTrackLyricsWithTiming extracts lyric events with timing from the named vocal track
+func extractTrackLyricsWithTiming(midiFile *smf.SMF, trackName string) []LyricEvent {
+	var lyricEvents []LyricEvent
 
-	if !found {
+	vocalTrack := findTrackByName(midiFile, trackName)
+	if vocalTrack == nil {
 		return lyricEvents
 	}
 
@@ -1418,7 +1454,7 @@ func extractLyricsWithTiming(midiFile *smf.SMF) []LyricEvent {
 		}
 	}
 
-	log.Printf("Extracted %d lyric events from PART VOCALS", len(lyricEvents))
+	log.Printf("Extracted %d lyric events from %s", len(lyricEvents), trackName)
 	return lyricEvents
 }
 
@@ -474,6 +474,101 @@ func TestWriteToneLibXMLTo_MidiFileWithVocals(t *testing.T) {
 	}
 }
 
+func TestWriteToneLibXMLTo_MidiFileWithHarmonies(t *testing.T) {
+	midiFile := createTempoMapMidiFile()
+	addVocalTrack(midiFile, VocalPartHarm1, []string{"one", "two"}, [][2]uint32{{0, 2}})
+	addVocalTrack(midiFile, VocalPartHarm2, []string{"$one", "three"}, nil)
+	song := &MidiFile{SMF: midiFile}
+
+	var buf bytes.Buffer
+	err := WriteToneLibXMLTo(&buf, song)
+	if err != nil {
+		t.Fatalf("WriteToneLibXMLTo failed: %v", err)
+	}
+
+	xmlOutput := buf.String()
+
+	for _, name := range []string{"Harmony 1 Lyrics", "Harmony 2 Lyrics"} {
+		if !strings.Contains(xmlOutput, name) {
+			t.Errorf("Expected %s track", name)
+		}
+	}
+
+	if strings.Contains(xmlOutput, "Harmony 3 Lyrics") {
+		t.Error("Did not expect a Harmony 3 Lyrics track")
+	}
+}
+
+func TestBuildVocalBeatsForMeasure(t *testing.T) {
+	measure := Measure{StartTime: 0, EndTime: 1920, BeatsPerMeasure: 4}
+	syllables := []VocalSyllable{
+		{StartTick: 0, EndTick: 240, Text: "Hel-", Pitch: 60, Pitched: true},
+		{StartTick: 480, EndTick: 1200, Text: "lo#", Pitch: 62},
+		{StartTick: 1440, EndTick: 2400, Text: "world", Pitch: 84, Pitched: true},
+	}
+
+	beats := buildVocalBeatsForMeasure(syllables, measure)
+
+	expected := []struct {
+		duration int
+		dotted   int
+		text     string
+		hasNote  bool
+	}{
+		{ToneLibEighthNoteDuration, 0, "Hel-", true},
+		{ToneLibEighthNoteDuration, 0, "", false},
+		{ToneLibQuarterNoteDuration, 1, "lo", true},
+		{ToneLibEighthNoteDuration, 0, "", false},
+		{ToneLibQuarterNoteDuration, 0, "world", true},
+	}
+
+	if len(beats) != len(expected) {
+		t.Fatalf("expected %d beats, got %d: %+v", len(expected), len(beats), beats)
+	}
+	for i, want := range expected {
+		beat := beats[i]
+		text := ""
+		if beat.Text != nil {
+			text = beat.Text.Value
+		}
+		if beat.Duration != want.duration || beat.Dotted != want.dotted || text != want.text || (len(beat.Notes) > 0) != want.hasNote {
+			t.Errorf("beat %d: expected %+v, got %+v", i, want, beat)
+		}
+	}
+
+	if beats[2].Notes[0].Effects == nil || beats[2].Notes[0].Effects.Dead != "yes" {
+		t.Errorf("expected non-pitched note to be dead, got %+v", beats[2].Notes[0])
+	}
+	if note := beats[4].Notes[0]; note.String != 1 || note.Fret != 20 {
+		t.Errorf("expected C5 on string 1 fret 20, got %+v", note)
+	}
+
+	// The rest of the last note is tied over into the next measure
+	next := buildVocalBeatsForMeasure(syllables, Measure{StartTime: 1920, EndTime: 3840, BeatsPerMeasure: 4})
+	if len(next) != 2 || next[0].Duration != ToneLibQuarterNoteDuration || next[0].Notes[0].Tied != "yes" || next[0].Text != nil {
+		t.Errorf("expected a tied quarter note followed by rests, got %+v", next)
+	}
+}
+
+func TestWriteToneLibXMLTo_MidiFileWithVocalMelody(t *testing.T) {
+	midiFile := createTempoMapMidiFile()
+	addVocalTrack(midiFile, VocalPartLead, []string{"one", "two"}, [][2]uint32{{0, 2}})
+	addVocalTrack(midiFile, VocalPartHarm1, []string{"one", "two"}, [][2]uint32{{0, 2}})
+	song := &MidiFile{SMF: midiFile}
+
+	var buf bytes.Buffer
+	if err := WriteToneLibXMLTo(&buf, song); err != nil {
+		t.Fatalf("WriteToneLibXMLTo failed: %v", err)
+	}
+
+	xmlOutput := buf.String()
+	for _, name := range []string{`name="Vocals"`, `name="Harmony 1"`, `<Text value="two"`} {
+		if !strings.Contains(xmlOutput, name) {
+			t.Errorf("Expected %s in output", name)
+		}
+	}
+}
+
 func TestWriteToneLibXMLTo_ComplexMidiFile(t *testing.T) {
 	midiFile := createComplexMidiFile()
 	song := &MidiFile{SMF: midiFile}
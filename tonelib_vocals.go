@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// noteLength is a ToneLib duration along with its length in sixteenth notes
+type noteLength struct {
+	sixteenths int
+	duration   int
+	dotted     bool
+}
+
+// vocalDurations lists the note lengths used for vocals, longest first so spans
+// are split into as few tied notes as possible
+var vocalDurations = []noteLength{
+	{16, ToneLibWholeNoteDuration, false},
+	{12, ToneLibHalfNoteDuration, true},
+	{8, ToneLibHalfNoteDuration, false},
+	{6, ToneLibQuarterNoteDuration, true},
+	{4, ToneLibQuarterNoteDuration, false},
+	{3, ToneLibEighthNoteDuration, true},
+	{2, ToneLibEighthNoteDuration, false},
+	{1, ToneLibSixteenthNoteDuration, false},
+}
+
+// vocalSpan is a syllable placed on the sixteenth note grid of a single measure
+type vocalSpan struct {
+	start     int // Sixteenths from the start of the measure
+	end       int
+	syllable  VocalSyllable
+	continued bool // The syllable started in an earlier measure
+}
+
+// createVocalMelodyTracks creates a melody track with lyrics attached to the notes
+// for the lead vocals and every harmony part
+func createVocalMelodyTracks(ctx *TrackCreationContext) []ToneLibTrack {
+	parts, err := (&MidiFile{SMF: ctx.MidiFile}).GetVocalParts()
+	if err != nil {
+		return nil
+	}
+
+	var tracks []ToneLibTrack
+	for _, part := range parts {
+		var syllables []VocalSyllable
+		for _, phrase := range part.Phrases {
+			syllables = append(syllables, phrase.Syllables...)
+		}
+		if len(syllables) == 0 {
+			continue
+		}
+
+		trackName := "Vocals"
+		for i, name := range harmonyPartNames {
+			if part.Name == name {
+				trackName = fmt.Sprintf("Harmony %d", i+1)
+			}
+		}
+
+		tracks = append(tracks, ToneLibTrack{
+			Name:     trackName,
+			Color:    ToneLibVocalsColor,
+			Visible:  1,
+			Collapse: 0,
+			Lock:     0,
+			Solo:     0,
+			Mute:     0,
+			Opt:      0,
+			VolDB:    ToneLibDefaultVolDB,
+			Bank:     0,  // Standard bank
+			Program:  53, // Voice Oohs
+			Chorus:   0,
+			Reverb:   0,
+			Phaser:   0,
+			Tremolo:  0,
+			ID:       *ctx.TrackID,
+			Offset:   ToneLibDefaultOffset,
+			Strings:  createStringsWithTuning(VocalTuning),
+			Bars:     createVocalBarsFromSyllables(syllables, ctx.NumBars, ctx.Timeline),
+		})
+		*ctx.TrackID++
+	}
+
+	return tracks
+}
+
+// createVocalBarsFromSyllables lays the syllables out on the treble clef, one bar
+// per timeline measure
+func createVocalBarsFromSyllables(syllables []VocalSyllable, numBars int, timeline *Timeline) ToneLibTrackBars {
+	var bars []ToneLibTrackBar
+	emptyBeats := ""
+
+	for barID := 1; barID <= numBars; barID++ {
+		bar := ToneLibTrackBar{
+			ID:       barID,
+			Beats:    []ToneLibBeat{},
+			BeatsEnd: &emptyBeats, // Required empty closing tag for each bar
+		}
+
+		// Add clef and key signature to first bar only
+		if barID == 1 {
+			bar.Clef = &ToneLibClef{Value: ToneLibTrebleClef}
+			bar.KeySign = &ToneLibKeySign{Value: 0}
+		}
+
+		if timeline != nil && barID <= len(timeline.Measures) {
+			bar.Beats = buildVocalBeatsForMeasure(syllables, timeline.Measures[barID-1])
+		} else {
+			// No timing for this measure - whole rest
+			bar.Beats = []ToneLibBeat{{Duration: ToneLibWholeNoteDuration, Dyn: ToneLibDefaultDynamic}}
+		}
+
+		bars = append(bars, bar)
+	}
+
+	return ToneLibTrackBars{Bars: bars}
+}
+
+// buildVocalBeatsForMeasure quantizes the syllables sounding in the measure to
+// sixteenth notes. Overlapping notes are shortened, notes crossing the bar line
+// are tied into the next measure and the gaps are filled with rests.
+func buildVocalBeatsForMeasure(syllables []VocalSyllable, measure Measure) []ToneLibBeat {
+	wholeRest := []ToneLibBeat{{Duration: ToneLibWholeNoteDuration, Dyn: ToneLibDefaultDynamic}}
+
+	beatsPerMeasure := measure.BeatsPerMeasure
+	if beatsPerMeasure <= 0 {
+		beatsPerMeasure = ToneLibDefaultBeatsPerMeasure
+	}
+	if measure.EndTime <= measure.StartTime {
+		return wholeRest
+	}
+
+	length := beatsPerMeasure * 4
+	ticksPerSixteenth := float64(measure.EndTime-measure.StartTime) / float64(length)
+	toSixteenths := func(tick uint32) int {
+		position := int(math.Round((float64(tick) - float64(measure.StartTime)) / ticksPerSixteenth))
+		if position < 0 {
+			return 0
+		}
+		if position > length {
+			return length
+		}
+		return position
+	}
+
+	var spans []vocalSpan
+	for _, syllable := range syllables {
+		if syllable.EndTick <= measure.StartTime || syllable.StartTick >= measure.EndTime {
+			continue
+		}
+
+		span := vocalSpan{
+			start:     toSixteenths(syllable.StartTick),
+			end:       toSixteenths(syllable.EndTick),
+			syllable:  syllable,
+			continued: syllable.StartTick < measure.StartTime,
+		}
+		if span.start >= length {
+			continue
+		}
+		// Very short notes still get a sixteenth
+		if span.end <= span.start {
+			span.end = span.start + 1
+		}
+		spans = append(spans, span)
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	var beats []ToneLibBeat
+	position := 0
+	for _, span := range spans {
+		// The staff is monophonic, later notes cut earlier ones short
+		if span.start < position {
+			span.start = position
+		}
+		if span.end <= span.start {
+			continue
+		}
+
+		if span.start > position {
+			beats = append(beats, vocalRestBeats(span.start-position)...)
+		}
+		beats = append(beats, vocalNoteBeats(span)...)
+		position = span.end
+	}
+
+	if len(beats) == 0 {
+		return wholeRest
+	}
+	if position < length {
+		beats = append(beats, vocalRestBeats(length-position)...)
+	}
+
+	return beats
+}
+
+// vocalRestBeats returns rests filling the given number of sixteenths
+func vocalRestBeats(sixteenths int) []ToneLibBeat {
+	var beats []ToneLibBeat
+	for _, piece := range splitSixteenths(sixteenths) {
+		beat := ToneLibBeat{Duration: piece.duration, Dyn: ToneLibDefaultDynamic}
+		if piece.dotted {
+			beat.Dotted = 1
+		}
+		beats = append(beats, beat)
+	}
+	return beats
+}
+
+// vocalNoteBeats returns the tied notes for a span, with the lyric on the first one
+func vocalNoteBeats(span vocalSpan) []ToneLibBeat {
+	var beats []ToneLibBeat
+	for i, piece := range splitSixteenths(span.end - span.start) {
+		note := vocalPitchToNote(span.syllable.Pitch)
+		if i > 0 || span.continued {
+			note.Tied = "yes"
+		}
+		if !span.syllable.Pitched {
+			note.Effects = &ToneLibEffects{Dead: "yes"}
+		}
+
+		beat := ToneLibBeat{
+			Duration: piece.duration,
+			Dyn:      ToneLibDefaultDynamic,
+			Notes:    []ToneLibNote{note},
+		}
+		if piece.dotted {
+			beat.Dotted = 1
+		}
+		if i == 0 && !span.continued {
+			if text := vocalSyllableText(span.syllable.Text); text != "" {
+				beat.Text = &ToneLibText{Value: text}
+			}
+		}
+		beats = append(beats, beat)
+	}
+	return beats
+}
+
+// splitSixteenths breaks a length in sixteenths into standard note durations
+func splitSixteenths(sixteenths int) []noteLength {
+	var pieces []noteLength
+	for _, candidate := range vocalDurations {
+		for sixteenths >= candidate.sixteenths {
+			pieces = append(pieces, candidate)
+			sixteenths -= candidate.sixteenths
+		}
+	}
+	return pieces
+}
+
+// vocalPitchToNote places a MIDI pitch on the highest vocal string that can reach it
+func vocalPitchToNote(pitch uint8) ToneLibNote {
+	for i, tuning := range VocalTuning {
+		if int(pitch) >= tuning {
+			return ToneLibNote{Fret: int(pitch) - tuning, String: i + 1}
+		}
+	}
+	return ToneLibNote{Fret: 0, String: len(VocalTuning)}
+}
+
+// vocalSyllableText returns the lyric to print under a note, with a trailing
+// hyphen when the word continues on the next note. Slides carry no text.
+func vocalSyllableText(raw string) string {
+	text, joinNext, ok := cleanLyricSyllable(raw)
+	if !ok {
+		return ""
+	}
+
+	isSlide := strings.HasSuffix(strings.TrimRight(raw, "#^%"), "+")
+	if joinNext && !isSlide && !strings.HasSuffix(text, "-") && !strings.HasSuffix(text, lyricTie) {
+		text += "-"
+	}
+	return text
+}
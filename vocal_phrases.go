@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// RB phrase marker notes in PART VOCALS
+const (
+	vocalPhraseNote       = 105 // Phrase marker
+	vocalPhraseNotePlayer = 106 // Player 2 phrase marker, used by older charts
+)
+
+// Vocal part track names
+const (
+	VocalPartLead  = "PART VOCALS"
+	VocalPartHarm1 = "HARM1"
+	VocalPartHarm2 = "HARM2"
+	VocalPartHarm3 = "HARM3"
+)
+
+// harmonyPartNames lists the harmony tracks in order, each inherits the phrase
+// markers of the one before it when it has none of its own
+var harmonyPartNames = []string{VocalPartHarm1, VocalPartHarm2, VocalPartHarm3}
+
+// VocalPart is a single vocal track split into phrases
+type VocalPart struct {
+	Name    string        `json:"name"` // Track name, one of the VocalPart values
+	Phrases []VocalPhrase `json:"phrases"`
+}
+
+// VocalSyllable is a single sung note with its lyric
+type VocalSyllable struct {
+	StartTick    uint32  `json:"start_tick"`
+	EndTick      uint32  `json:"end_tick"`
+	StartSeconds float64 `json:"start_seconds"`
+	EndSeconds   float64 `json:"end_seconds"`
+	Text         string  `json:"text"`    // Raw lyric text, keeps Rock Band formatting
+	Pitch        uint8   `json:"pitch"`   // MIDI note number, 0 when the source has no pitch data (charts)
+	Pitched      bool    `json:"pitched"` // False for non-pitched (talky) syllables marked with # or ^
+	Shared       bool    `json:"shared"`  // Harmony lyric marked with $, sung with the part above and hidden in game
+}
+
+// VocalPhrase is a line of vocals as marked by the phrase markers of the chart
+type VocalPhrase struct {
+	StartTick    uint32          `json:"start_tick"`
+	EndTick      uint32          `json:"end_tick"`
+	StartSeconds float64         `json:"start_seconds"`
+	EndSeconds   float64         `json:"end_seconds"`
+	Syllables    []VocalSyllable `json:"syllables"`
+}
+
+// VocalWord is a word joined from one or more syllables, see NormalizeLyrics
+type VocalWord struct {
+	StartTick    uint32  `json:"start_tick"`
+	EndTick      uint32  `json:"end_tick"`
+	StartSeconds float64 `json:"start_seconds"`
+	EndSeconds   float64 `json:"end_seconds"`
+	Text         string  `json:"text"`
+	Spoken       bool    `json:"spoken"` // Every syllable of the word is non-pitched
+}
+
+// Text returns the lyrics of the phrase with the Rock Band formatting removed
+func (p VocalPhrase) Text() string {
+	rawLyrics := make([]string, len(p.Syllables))
+	for i, syllable := range p.Syllables {
+		rawLyrics[i] = syllable.Text
+	}
+	return parseRockBandLyrics(rawLyrics)
+}
+
+// Words joins the syllables of the phrase into display words, each timed from
+// the start of its first syllable to the end of its last
+func (p VocalPhrase) Words() []VocalWord {
+	return normalizeLyricWords(p.Syllables, LyricModeDisplay)
+}
+
+// isNonPitchedLyric reports whether the raw lyric marks a non-pitched syllable
+func isNonPitchedLyric(lyric string) bool {
+	lyric = strings.TrimSuffix(lyric, "%")
+	return strings.HasSuffix(lyric, "#") || strings.HasSuffix(lyric, "^")
+}
+
+// tickRange is a span of ticks, such as a vocal phrase
+type tickRange struct {
+	Start uint32
+	End   uint32
+}
+
+// GetVocalPhrases extracts the phrases of PART VOCALS using the phrase marker notes
+func (m *MidiFile) GetVocalPhrases() ([]VocalPhrase, error) {
+	tempoMap, err := extractTempoMap(m.SMF, m.GetAudioOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	vocalTrack := findTrackByName(m.SMF, VocalPartLead)
+	if vocalTrack == nil {
+		return []VocalPhrase{}, nil
+	}
+
+	return vocalTrackPhrases(vocalTrack, extractPhraseMarkers(vocalTrack), tempoMap), nil
+}
+
+// GetVocalParts extracts PART VOCALS and the HARM1-HARM3 harmony parts. HARM2
+// carries the phrase markers for HARM2 and HARM3 in Rock Band charts, so a
+// harmony without markers of its own uses the markers of the part before it.
+func (m *MidiFile) GetVocalParts() ([]VocalPart, error) {
+	tempoMap, err := extractTempoMap(m.SMF, m.GetAudioOffset())
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []VocalPart{}
+
+	if leadTrack := findTrackByName(m.SMF, VocalPartLead); leadTrack != nil {
+		parts = append(parts, VocalPart{
+			Name:    VocalPartLead,
+			Phrases: vocalTrackPhrases(leadTrack, extractPhraseMarkers(leadTrack), tempoMap),
+		})
+	}
+
+	var inheritedMarkers []tickRange
+	for _, name := range harmonyPartNames {
+		track := findTrackByName(m.SMF, name)
+		if track == nil {
+			continue
+		}
+
+		markers := extractPhraseMarkers(track)
+		if len(markers) == 0 {
+			markers = inheritedMarkers
+		}
+		inheritedMarkers = markers
+
+		parts = append(parts, VocalPart{
+			Name:    name,
+			Phrases: vocalTrackPhrases(track, markers, tempoMap),
+		})
+	}
+
+	return parts, nil
+}
+
+// vocalTrackPhrases turns the notes and lyrics of a vocal track into phrases
+func vocalTrackPhrases(track smf.Track, phraseRanges []tickRange, tempoMap *TempoMap) []VocalPhrase {
+	var syllables []VocalSyllable
+	for _, note := range extractVocalNotes(track) {
+		if note.isPercussion() {
+			continue
+		}
+
+		syllables = append(syllables, VocalSyllable{
+			StartTick: note.Time,
+			EndTick:   note.Time + note.Duration,
+			Text:      note.Lyric,
+			Pitch:     note.Key,
+			Pitched:   note.Type == VocalNotePitched,
+			Shared:    strings.HasPrefix(note.Lyric, "$"),
+		})
+	}
+
+	return buildVocalPhrases(syllables, phraseRanges, tempoMap)
+}
+
+// findTrackByName returns the first track with the given name, or nil
+func findTrackByName(smfData *smf.SMF, name string) smf.Track {
+	for _, track := range smfData.Tracks {
+		if getTrackName(track) == name {
+			return track
+		}
+	}
+	return nil
+}
+
+// GetVocalPhrases extracts phrases from the lyric global events, using the
+// phrase_start and phrase_end events to split them. Charts have no vocal notes,
+// so each syllable lasts until the next one or the end of the phrase.
+func (c *ChartFile) GetVocalPhrases() ([]VocalPhrase, error) {
+	if c == nil {
+		return []VocalPhrase{}, nil
+	}
+
+	var syllables []VocalSyllable
+	var phrases []tickRange
+
+	phraseOpen := false
+	var phraseStart uint32
+
+	for _, event := range c.Events.GlobalEvents {
+		switch event.Type {
+		case ChartEventLyric:
+			if event.Value != "" {
+				syllables = append(syllables, VocalSyllable{
+					StartTick: event.Tick,
+					Text:      event.Value,
+					Pitched:   !isNonPitchedLyric(event.Value),
+				})
+			}
+		case ChartEventPhraseStart:
+			// A new phrase implicitly ends the previous one
+			if phraseOpen && event.Tick > phraseStart {
+				phrases = append(phrases, tickRange{Start: phraseStart, End: event.Tick})
+			}
+			phraseStart = event.Tick
+			phraseOpen = true
+		case ChartEventPhraseEnd:
+			if phraseOpen {
+				phrases = append(phrases, tickRange{Start: phraseStart, End: event.Tick})
+				phraseOpen = false
+			}
+		}
+	}
+
+	resolution := uint32(c.Song.Resolution)
+
+	// Close a phrase left open at the end of the chart after its last lyric
+	if phraseOpen {
+		end := phraseStart + resolution
+		for _, syllable := range syllables {
+			if syllable.StartTick >= end {
+				end = syllable.StartTick + resolution
+			}
+		}
+		phrases = append(phrases, tickRange{Start: phraseStart, End: end})
+	}
+
+	// Each syllable lasts until the next one, a quarter note at most, and never
+	// past the end of its phrase
+	for i := range syllables {
+		end := syllables[i].StartTick + resolution
+		if i+1 < len(syllables) && syllables[i+1].StartTick < end {
+			end = syllables[i+1].StartTick
+		}
+		for _, phrase := range phrases {
+			if syllables[i].StartTick >= phrase.Start && syllables[i].StartTick < phrase.End && phrase.End < end {
+				end = phrase.End
+			}
+		}
+		syllables[i].EndTick = end
+	}
+
+	return buildVocalPhrases(syllables, phrases, chartTempoMap(c)), nil
+}
+
+// GetVocalParts returns the lead vocals of the chart, charts have no harmonies
+func (c *ChartFile) GetVocalParts() ([]VocalPart, error) {
+	phrases, err := c.GetVocalPhrases()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(phrases) == 0 {
+		return []VocalPart{}, nil
+	}
+
+	return []VocalPart{{Name: VocalPartLead, Phrases: phrases}}, nil
+}
+
+// GetVocalParts extracts the vocal parts from the MIDI or chart file in the package
+func (s *SngFile) GetVocalParts() ([]VocalPart, error) {
+	midiFile, chartFile, err := s.loadNotes()
+	if err != nil {
+		// Return empty if neither file could be read
+		return []VocalPart{}, nil
+	}
+	if midiFile != nil {
+		return midiFile.GetVocalParts()
+	}
+	return chartFile.GetVocalParts()
+}
+
+// FindVocalPart returns the part with the given track name, or nil
+func FindVocalPart(parts []VocalPart, name string) *VocalPart {
+	for i := range parts {
+		if parts[i].Name == name {
+			return &parts[i]
+		}
+	}
+	return nil
+}
+
+// vocalPartForOption maps a command line part name (lead, harm1, harm2, harm3)
+// to the track name of the vocal part
+func vocalPartForOption(option string) (string, error) {
+	switch strings.ToLower(option) {
+	case "", "lead", "vocals":
+		return VocalPartLead, nil
+	case "harm1":
+		return VocalPartHarm1, nil
+	case "harm2":
+		return VocalPartHarm2, nil
+	case "harm3":
+		return VocalPartHarm3, nil
+	default:
+		return "", fmt.Errorf("unknown vocal part %q (expected lead, harm1, harm2 or harm3)", option)
+	}
+}
+
+// GetVocalPhrases extracts vocal phrases from the MIDI or chart file in the package
+func (s *SngFile) GetVocalPhrases() ([]VocalPhrase, error) {
+	midiFile, chartFile, err := s.loadNotes()
+	if err != nil {
+		// Return empty if neither file could be read
+		return []VocalPhrase{}, nil
+	}
+	if midiFile != nil {
+		return midiFile.GetVocalPhrases()
+	}
+	return chartFile.GetVocalPhrases()
+}
+
+// extractPhraseMarkers collects the phrase marker notes of a vocal track. The
+// player 2 markers usually duplicate the regular ones, so overlapping phrases
+// are merged.
+func extractPhraseMarkers(track smf.Track) []tickRange {
+	var phrases []tickRange
+	noteOnTimes := make(map[uint8]uint32)
+
+	var currentTime uint32
+	for _, event := range track {
+		currentTime += event.Delta
+
+		var ch, key, vel uint8
+		if event.Message.GetNoteOn(&ch, &key, &vel) && vel > 0 {
+			if key == vocalPhraseNote || key == vocalPhraseNotePlayer {
+				noteOnTimes[key] = currentTime
+			}
+		} else if event.Message.GetNoteOff(&ch, &key, &vel) || (event.Message.GetNoteOn(&ch, &key, &vel) && vel == 0) {
+			if start, exists := noteOnTimes[key]; exists {
+				if currentTime > start {
+					phrases = append(phrases, tickRange{Start: start, End: currentTime})
+				}
+				delete(noteOnTimes, key)
+			}
+		}
+	}
+
+	sort.Slice(phrases, func(i, j int) bool {
+		return phrases[i].Start < phrases[j].Start
+	})
+
+	var merged []tickRange
+	for _, phrase := range phrases {
+		if len(merged) > 0 && phrase.Start < merged[len(merged)-1].End {
+			if phrase.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = phrase.End
+			}
+			continue
+		}
+		merged = append(merged, phrase)
+	}
+
+	return merged
+}
+
+// buildVocalPhrases groups syllables into phrases and fills in their times in
+// seconds. Syllables outside of any phrase marker are grouped into phrases of
+// their own, split wherever there is a gap of a whole note or more.
+func buildVocalPhrases(syllables []VocalSyllable, phraseRanges []tickRange, tempoMap *TempoMap) []VocalPhrase {
+	sort.SliceStable(syllables, func(i, j int) bool {
+		return syllables[i].StartTick < syllables[j].StartTick
+	})
+
+	gapTicks := uint32(tempoMap.TicksPerQuarter * 4)
+
+	phrases := []VocalPhrase{}
+	var current *VocalPhrase
+	currentRange := -1
+
+	flushPhrase := func() {
+		if current != nil && len(current.Syllables) > 0 {
+			current.StartSeconds = tempoMap.SecondsAt(current.StartTick)
+			current.EndSeconds = tempoMap.SecondsAt(current.EndTick)
+			phrases = append(phrases, *current)
+		}
+		current = nil
+	}
+
+	rangeIdx := 0
+	for _, syllable := range syllables {
+		syllable.StartSeconds = tempoMap.SecondsAt(syllable.StartTick)
+		syllable.EndSeconds = tempoMap.SecondsAt(syllable.EndTick)
+
+		for rangeIdx < len(phraseRanges) && phraseRanges[rangeIdx].End <= syllable.StartTick {
+			rangeIdx++
+		}
+
+		inRange := -1
+		if rangeIdx < len(phraseRanges) && phraseRanges[rangeIdx].Start <= syllable.StartTick {
+			inRange = rangeIdx
+		}
+
+		if inRange >= 0 {
+			if inRange != currentRange {
+				flushPhrase()
+				currentRange = inRange
+				current = &VocalPhrase{
+					StartTick: phraseRanges[inRange].Start,
+					EndTick:   phraseRanges[inRange].End,
+				}
+			}
+		} else {
+			if currentRange >= 0 || (current != nil && syllable.StartTick >= current.EndTick+gapTicks) {
+				flushPhrase()
+			}
+			currentRange = -1
+			if current == nil {
+				current = &VocalPhrase{StartTick: syllable.StartTick}
+			}
+			if syllable.EndTick > current.EndTick {
+				current.EndTick = syllable.EndTick
+			}
+		}
+
+		current.Syllables = append(current.Syllables, syllable)
+	}
+	flushPhrase()
+
+	return phrases
+}
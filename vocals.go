@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"sort"
 
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/smf"
@@ -13,13 +14,40 @@ const (
 	gmOboe uint8 = 68 // Oboe - melodic instrument for vocals
 )
 
+// Vocal track note ranges
+const (
+	vocalNoteMin              uint8 = 36 // C1, lowest singable note
+	vocalNoteMax              uint8 = 84 // C5, highest singable note
+	vocalPercussionNote       uint8 = 96 // C6, playable percussion
+	vocalPercussionHiddenNote uint8 = 97 // C#6, non-playable percussion sample
+)
+
+// Vocal note types
+const (
+	VocalNotePitched          = "pitched"           // Sung note
+	VocalNoteNonPitched       = "non_pitched"       // Spoken or shouted note, lyric marked with # or ^
+	VocalNotePercussion       = "percussion"        // Percussion hit the singer plays
+	VocalNotePercussionHidden = "percussion_hidden" // Percussion sample played by the game
+)
+
+// vocalPercussionDefaultKind is used when the track has no percussion markers
+const vocalPercussionDefaultKind = "tambourine"
+
+// vocalPercussionGMKeys maps the percussion animation markers to GM percussion keys
+var vocalPercussionGMKeys = map[string]uint8{
+	"tambourine": Tambourine,
+	"cowbell":    Cowbell,
+	"clap":       HandClap,
+}
+
 // VocalNote represents a single vocal note with timing, pitch, and lyric
 type VocalNote struct {
 	Time     uint32
-	Key      uint8 // MIDI note number (C1=36 to C5=84)
+	Key      uint8 // MIDI note number (C1=36 to C5=84, or 96/97 for percussion)
 	Velocity uint8
 	Duration uint32 // Duration in ticks
-	Lyric    string // Associated lyric text
+	Lyric    string // Associated lyric text, empty for percussion
+	Type     string // One of the VocalNote type values
 }
 
 // AddVocalTracks extracts vocal melody and harmonies from a Rock Band MIDI file
@@ -47,18 +75,34 @@ func (e *GeneralMidiExporter) AddVocalTracks(sourceData *smf.SMF) error {
 
 	// Extract vocal notes from all tracks
 	allVocalNotes := make(map[string][]VocalNote)
+	allPercussionNotes := make(map[string][]VocalNote)
 	totalNotes := 0
 
 	for trackName, track := range vocalTracks {
-		notes := extractVocalNotes(track)
+		var notes, percussionNotes []VocalNote
+		for _, note := range extractVocalNotes(track) {
+			switch {
+			case note.Type == VocalNotePercussion:
+				percussionNotes = append(percussionNotes, note)
+			case note.isPercussion():
+				// Hidden percussion is a sample the game plays, not a hit the singer makes
+			default:
+				notes = append(notes, note)
+			}
+		}
+
 		if len(notes) > 0 {
 			allVocalNotes[trackName] = notes
 			totalNotes += len(notes)
 			log.Printf("Found %d vocal notes in %s", len(notes), trackName)
 		}
+		if len(percussionNotes) > 0 {
+			allPercussionNotes[trackName] = percussionNotes
+			log.Printf("Found %d vocal percussion notes in %s", len(percussionNotes), trackName)
+		}
 	}
 
-	if totalNotes == 0 {
+	if totalNotes == 0 && len(allPercussionNotes) == 0 {
 		return fmt.Errorf("no vocal notes found in any vocal tracks")
 	}
 
@@ -93,12 +137,6 @@ func (e *GeneralMidiExporter) AddVocalTracks(sourceData *smf.SMF) error {
 		var events []MidiEvent
 
 		for i, note := range vocalNotes {
-			// Skip notes outside valid range
-			if note.Key < 36 || note.Key > 84 {
-				log.Printf("Warning: skipping vocal note %d outside valid range (36-84)", note.Key)
-				continue
-			}
-
 			// Add lyric event if present (only for main vocals)
 			if note.Lyric != "" && trackName == "PART VOCALS" {
 				lyricMsg := smf.Message(smf.MetaLyric(note.Lyric))
@@ -141,11 +179,141 @@ func (e *GeneralMidiExporter) AddVocalTracks(sourceData *smf.SMF) error {
 		}
 	}
 
-	return nil
+	if len(allPercussionNotes) == 0 {
+		return nil
+	}
+
+	// Percussion hits of every part share one track. Harmony parts usually repeat
+	// the lead's hits, so a hit already played on the same key is dropped.
+	var hits []VocalNote
+	played := make(map[[2]uint32]bool)
+	for _, trackName := range trackOrder {
+		sections := vocalPercussionSections(vocalTracks[trackName])
+		for _, note := range allPercussionNotes[trackName] {
+			note.Key = vocalPercussionGMKeys[vocalPercussionKindAt(sections, note.Time)]
+			hit := [2]uint32{note.Time, uint32(note.Key)}
+			if played[hit] {
+				continue
+			}
+			played[hit] = true
+			hits = append(hits, note)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Time < hits[j].Time
+	})
+
+	return e.addVocalPercussionTrack(hits)
 }
 
-// extractVocalNotes finds all vocal notes (C1-C5: 36-84) in the vocal track
-// and associates them with lyric events
+// addVocalPercussionTrack adds the vocal percussion hits as a GM percussion track,
+// the notes' keys are already GM percussion keys
+func (e *GeneralMidiExporter) addVocalPercussionTrack(hits []VocalNote) error {
+	var events []MidiEvent
+	for i, note := range hits {
+		noteOnMsg := smf.Message(midi.NoteOn(gmDrumChannel, note.Key, note.Velocity))
+		events = append(events, MidiEvent{Time: note.Time, Message: noteOnMsg})
+
+		// Percussion is a short hit, cut off early by the next hit on the same key
+		endTime := note.Time + hitDurationTicks
+		for _, next := range hits[i+1:] {
+			if next.Time >= endTime {
+				break
+			}
+			if next.Key == note.Key {
+				endTime = next.Time
+				break
+			}
+		}
+
+		noteOffMsg := smf.Message(midi.NoteOff(gmDrumChannel, note.Key))
+		events = append(events, MidiEvent{Time: endTime, Message: noteOffMsg})
+	}
+
+	return e.addTrack(TrackInfo{
+		Name:    "Vocal Percussion",
+		Channel: gmDrumChannel,
+		Program: 0, // Standard drum kit
+		Events:  events,
+	})
+}
+
+// vocalPercussionSection is a stretch of a vocal track played on one percussion
+// instrument, starting at a [tambourine_start], [cowbell_start] or [clap_start] marker
+type vocalPercussionSection struct {
+	Time uint32
+	Kind string
+}
+
+// vocalPercussionSections returns the percussion sections of the track in order
+func vocalPercussionSections(track smf.Track) []vocalPercussionSection {
+	var sections []vocalPercussionSection
+	var currentTime uint32
+
+	for _, event := range track {
+		currentTime += event.Delta
+
+		var text string
+		if !event.Message.GetMetaText(&text) {
+			continue
+		}
+
+		for kind := range vocalPercussionGMKeys {
+			if text == "["+kind+"_start]" {
+				sections = append(sections, vocalPercussionSection{Time: currentTime, Kind: kind})
+				break
+			}
+		}
+	}
+
+	return sections
+}
+
+// vocalPercussionKindAt returns the percussion instrument of the section the time
+// falls in. Hits before the first marker use the first section's instrument, and
+// tracks without markers use the default.
+func vocalPercussionKindAt(sections []vocalPercussionSection, time uint32) string {
+	if len(sections) == 0 {
+		return vocalPercussionDefaultKind
+	}
+
+	kind := sections[0].Kind
+	for _, section := range sections {
+		if section.Time > time {
+			break
+		}
+		kind = section.Kind
+	}
+
+	return kind
+}
+
+// isPercussion reports whether the note is a percussion hit rather than a sung note
+func (n VocalNote) isPercussion() bool {
+	return n.Type == VocalNotePercussion || n.Type == VocalNotePercussionHidden
+}
+
+// vocalNoteType classifies a vocal track note by its key and lyric, returning an
+// empty string for keys that are not vocal notes
+func vocalNoteType(key uint8, lyric string) string {
+	switch {
+	case key == vocalPercussionNote:
+		return VocalNotePercussion
+	case key == vocalPercussionHiddenNote:
+		return VocalNotePercussionHidden
+	case key >= vocalNoteMin && key <= vocalNoteMax:
+		if isNonPitchedLyric(lyric) {
+			return VocalNoteNonPitched
+		}
+		return VocalNotePitched
+	default:
+		return ""
+	}
+}
+
+// extractVocalNotes finds all vocal notes (C1-C5: 36-84) and percussion hits
+// (96, 97) in the vocal track and associates the sung notes with lyric events
 func extractVocalNotes(vocalTrack smf.Track) []VocalNote {
 	var vocalNotes []VocalNote
 	var currentTime uint32
@@ -179,25 +347,28 @@ func extractVocalNotes(vocalTrack smf.Track) []VocalNote {
 
 		var ch, key, vel uint8
 		if msg.GetNoteOn(&ch, &key, &vel) && vel > 0 {
-			// Vocal notes are in the range C1-C5 (36-84)
-			if key >= 36 && key <= 84 {
+			// Get associated lyric (if any), percussion hits carry none
+			lyric := lyricsByTime[currentTime]
+			if key == vocalPercussionNote || key == vocalPercussionHiddenNote {
+				lyric = ""
+			}
+
+			if noteType := vocalNoteType(key, lyric); noteType != "" {
 				// Store note-on time for duration calculation
 				noteOnMap[key] = currentTime
 
-				// Get associated lyric (if any)
-				lyric := lyricsByTime[currentTime]
-
 				vocalNotes = append(vocalNotes, VocalNote{
 					Time:     currentTime,
 					Key:      key,
 					Velocity: vel,
 					Duration: 0, // Duration set later when note-off is found
 					Lyric:    lyric,
+					Type:     noteType,
 				})
 			}
 		} else if msg.GetNoteOff(&ch, &key, &vel) || (msg.GetNoteOn(&ch, &key, &vel) && vel == 0) {
 			// Handle note-off events (including note-on with velocity 0)
-			if key >= 36 && key <= 84 {
+			if vocalNoteType(key, "") != "" {
 				if noteOnTime, exists := noteOnMap[key]; exists {
 					// Find the corresponding note and update its duration
 					for i := len(vocalNotes) - 1; i >= 0; i-- {